@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+// targetUserID reads the id of the user an admin acts on, writing a 400 response and returning false
+// if it isn't valid or if it is the id of the admin himself, so that he can't lock himself out.
+func targetUserID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return 0, false
+	}
+
+	if uint(id) == auth.MustCurrentUser(c).ID {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "admins can't act on their own account"})
+		return 0, false
+	}
+
+	return uint(id), true
+}
+
+// revokeSessionsOf revokes the sessions of a user an admin acted on, writing a 500 response and returning false if it fails.
+func revokeSessionsOf(c *gin.Context, userID uint) bool {
+	if err := sessionService.RevokeUserSessions(userID); err != nil {
+		log.Printf("error while revoking the sessions of user %d : %s", userID, err.Error())
+		c.JSON(http.StatusInternalServerError, nil)
+		return false
+	}
+
+	return true
+}
+
+// @Summary      Get users
+// @Description  Get a page of users, optionally filtered by role and status. The total number of matching users is returned in the X-Total-Count header.
+// @Tags         admin
+// @Produce      json
+// @Param        role    query     string  false  "only return users having this role"
+// @Param        status  query     string  false  "only return users having this status"  Enums(active, pending, suspended)
+// @Param        page    query     int     false  "page number, starting at 1"
+// @Param        limit   query     int     false  "number of users per page, 20 by default and 100 at most"
+// @Success      200  {array}   user.User
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /admin/users [get]
+func getUsersEndpoint(c *gin.Context) {
+	page, limit, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	query := user.UserQuery{
+		Role:     user.Role(c.Query("role")),
+		Status:   user.Status(c.Query("status")),
+		Page:     page,
+		PageSize: limit,
+	}
+
+	if query.Status != "" {
+		if err := query.Status.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+	}
+
+	users, total, err := userService.GetUsers(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	setTotalCount(c, total)
+	c.JSON(http.StatusOK, users)
+}
+
+// @Summary      Change the role of a user
+// @Description  Give another role to a user. His sessions are revoked so that he logs in again with the permissions of his new role.
+// @Description  Giving a role requires the role:manage permission, or every permission of the role.
+// @Tags         admin
+// @Accept       json
+// @Param        id    path  int              true  "User ID"
+// @Param        role  body  user.RoleChange  true  "new role"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403  {object}  error.ErrorResponse
+// @Failure      404
+// @Failure      500
+// @Router       /admin/users/{id}/role [put]
+func updateUserRoleEndpoint(c *gin.Context) {
+	id, ok := targetUserID(c)
+	if !ok {
+		return
+	}
+
+	var json user.RoleChange
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	role, err := roleService.GetRole(json.Role)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "role " + string(json.Role) + " doesn't exist"})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	granted, err := auth.CanGrant(c, roleService, role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !granted {
+		c.JSON(http.StatusForbidden, error.ErrorResponse{ErrorMessage: auth.ErrRoleNotGrantable.Error()})
+		return
+	}
+
+	if err := userService.SetRole(id, json.Role); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !revokeSessionsOf(c, id) {
+		return
+	}
+
+	log.Printf("role of user %d changed to %q by %q", id, json.Role, auth.MustCurrentUser(c).Username)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Suspend a user
+// @Description  Suspend a user, refusing his logins, sessions and API keys until he is reactivated.
+// @Tags         admin
+// @Param        id   path      int  true  "User ID"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /admin/users/{id}/suspend [post]
+func suspendUserEndpoint(c *gin.Context) {
+	id, ok := targetUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := userService.Suspend(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !revokeSessionsOf(c, id) {
+		return
+	}
+
+	log.Printf("user %d suspended by %q", id, auth.MustCurrentUser(c).Username)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Reactivate a user
+// @Description  Reactivate a suspended user, who can log in again.
+// @Tags         admin
+// @Param        id   path      int  true  "User ID"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /admin/users/{id}/reactivate [post]
+func reactivateUserEndpoint(c *gin.Context) {
+	id, ok := targetUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := userService.Reactivate(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	log.Printf("user %d reactivated by %q", id, auth.MustCurrentUser(c).Username)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Delete a user
+// @Description  Soft delete a user and revoke his sessions. With recipes=anonymise, his username, email and password are erased
+// @Description  so that the recipes he authored are attributed to a deleted user, with recipes=keep (default) they stay attributed to him.
+// @Tags         admin
+// @Param        id       path   int     true   "User ID"
+// @Param        recipes  query  string  false  "what to do with the authored recipes"  Enums(keep, anonymise)
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /admin/users/{id} [delete]
+func deleteUserEndpoint(c *gin.Context) {
+	id, ok := targetUserID(c)
+	if !ok {
+		return
+	}
+
+	var anonymise bool
+	switch c.DefaultQuery("recipes", "keep") {
+	case "keep":
+	case "anonymise":
+		anonymise = true
+	default:
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "recipes must be keep or anonymise"})
+		return
+	}
+
+	if err := userService.DeleteUser(id, anonymise); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !revokeSessionsOf(c, id) {
+		return
+	}
+
+	log.Printf("user %d deleted by %q", id, auth.MustCurrentUser(c).Username)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Unlock a user
+// @Description  Forget the failed logins of a user, unlocking his account if too many failures locked it.
+// @Tags         admin
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /admin/users/{id}/unlock [post]
+func unlockUserEndpoint(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	lockedUser, err := userService.GetUser(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err := throttleService.Unlock(lockedUser.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	log.Printf("account %q unlocked by %q", lockedUser.Username, auth.MustCurrentUser(c).Username)
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+// @Summary      Create an API key
+// @Description  Create a long lived key to authenticate scripts and applications with an `Authorization: Bearer` header.
+// @Description  Requests made with the key are only allowed what both the role of the user and the scopes of the key allow.
+// @Description  The key is only returned once, and can't be created with another API key.
+// @Description  A key created without logging in with a second factor can't use the permissions of roles requiring two-factor authentication.
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Param key body auth.APIKey true "name, scopes and optional expiration of the key"
+// @Success      201  {object}  object{id=int,key=string}
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /users/api-keys [post]
+func createAPIKeyEndpoint(c *gin.Context) {
+	var json auth.APIKey
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if json.Name == "" || len(json.Name) > 80 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "name must be between 1 and 80 characters"})
+		return
+	}
+
+	currentUser := auth.MustCurrentUser(c)
+
+	id, key, err := apiKeyService.CreateAPIKey(json, currentUser.ID, auth.TwoFactorVerified(c))
+	if err != nil {
+		if errors.Is(err, user.ErrUnknownPermission) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":  id,
+		"key": key,
+	})
+}
+
+// @Summary      Get API keys
+// @Description  Get the API keys of the logged user, with when they were last used.
+// @Tags         api-keys
+// @Produce      json
+// @Success      200  {array}  auth.APIKey
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /users/api-keys [get]
+func getAPIKeysEndpoint(c *gin.Context) {
+	currentUser := auth.MustCurrentUser(c)
+
+	keys, err := apiKeyService.GetAPIKeys(currentUser.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// @Summary      Revoke an API key
+// @Description  Delete an API key of the logged user, requests made with it are rejected right away.
+// @Tags         api-keys
+// @Produce      json
+// @Param        id   path      int  true  "API key ID"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /users/api-keys/{id} [delete]
+func deleteAPIKeyEndpoint(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("keyId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	currentUser := auth.MustCurrentUser(c)
+
+	err = apiKeyService.DeleteAPIKey(uint(id), currentUser.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+)
+
+// rejectAPIKey aborts the request with a 403 response when it is authenticated with an API key.
+// It guards the routes about the account and the personal data of the user, which no scope of a key covers.
+func rejectAPIKey(c *gin.Context) {
+	if _, ok := auth.CurrentAPIKey(c); ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, nil)
+		return
+	}
+
+	c.Next()
+}
+
+// @Summary      Get the token signing keys
+// @Description  Get the public keys used to sign tokens, as a JSON Web Key Set, so that other services can verify them.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  auth.JSONWebKeySet
+// @Router       /.well-known/jwks.json [get]
+func getJWKSEndpoint(c *gin.Context) {
+	c.JSON(http.StatusOK, keySet.JWKS())
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/kataras/jwt"
 	"github.com/mjehanno/welsh-academy/pkg/error"
 	"github.com/mjehanno/welsh-academy/pkg/ingredient"
-	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
 )
 
 // @Summary      Create an Ingredient
@@ -20,32 +21,81 @@ import (
 // @Failure      400  {object}  error.ErrorResponse
 // @Failure   	 401
 // @Failure 	 	 403
+// @Failure      409  {object}  error.ErrorResponse
 // @Failure      500
 // @Router       /ingredients [post]
 func createIngredientEndpoint(c *gin.Context) {
 	var json ingredient.Ingredient
 
-	cookie, err := c.Cookie("jwt")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, nil)
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if json.Name == "" {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create ingredient with empty name"})
+		return
+	}
+
+	if json.Density < 0 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create ingredient with a negative density"})
+		return
+	}
+
+	if existing, err := ingredientService.GetIngredientByName(json.Name); err == nil {
+		c.JSON(http.StatusConflict, error.ErrorResponse{ErrorMessage: "ingredient " + strconv.FormatUint(uint64(existing.ID), 10) + " is already named " + json.Name})
 		return
 	}
 
-	verifiedToken, err := jwt.Verify(jwt.HS256, sharedKey, []byte(cookie))
+	id, err := ingredientService.CreateIngredient(json)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	var currentUser user.User
-	err = verifiedToken.Claims(&currentUser)
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// @Summary      Get the list of ingredients.
+// @Description  Get the whole list of ingredients.
+// @Tags         ingredients
+// @Produce      json
+// @Success      200  {array}  ingredient.Ingredient
+// @Failure      500
+// @Router       /ingredients [get]
+func getIngredientEndpoint(c *gin.Context) {
+	ingredients, err := ingredientService.GetAllIngredient()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	if currentUser.Role != user.CheddarExpert {
-		c.JSON(http.StatusForbidden, nil)
+	c.JSON(http.StatusOK, ingredients)
+}
+
+// @Summary      Update an Ingredient
+// @Description  Rename an ingredient or change its density, recipes using it are kept. Use the merge endpoint if an ingredient with the new name already exists.
+// @Tags         ingredients
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Ingredient ID"
+// @Param ingredient body ingredient.Ingredient true "ingredient with its new name and density"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      409  {object}  error.ErrorResponse
+// @Failure      500
+// @Router       /ingredients/{id} [put]
+func updateIngredientEndpoint(c *gin.Context) {
+	var json ingredient.Ingredient
+
+	ingredientID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
 		return
 	}
 
@@ -55,34 +105,128 @@ func createIngredientEndpoint(c *gin.Context) {
 	}
 
 	if json.Name == "" {
-		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create ingredient with empty name"})
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't rename ingredient with empty name"})
 		return
 	}
 
-	id, err := ingredientService.CreateIngredient(json)
+	if json.Density < 0 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't update ingredient with a negative density"})
+		return
+	}
+
+	existing, err := ingredientService.GetIngredientByName(json.Name)
+	if err == nil && existing.ID != uint(ingredientID) {
+		c.JSON(http.StatusConflict, error.ErrorResponse{ErrorMessage: "ingredient " + strconv.FormatUint(uint64(existing.ID), 10) + " is already named " + json.Name + ", merge them instead"})
+		return
+	}
+
+	json.ID = uint(ingredientID)
+	err = ingredientService.UpdateIngredient(json)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"id": id,
-	})
+	c.JSON(http.StatusNoContent, nil)
 }
 
-// @Summary      Get the list of ingredients.
-// @Description  Get the whole list of ingredients.
+// @Summary      Delete an Ingredient
+// @Description  Delete an ingredient that isn't used by any recipe, it is removed from the pantries and shopping lists holding it.
 // @Tags         ingredients
 // @Produce      json
-// @Success      200  {array}  ingredient.Ingredient
+// @Param        id   path      int  true  "Ingredient ID"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      409  {object}  ingredient.InUseError
 // @Failure      500
-// @Router       /ingredients [get]
-func getIngredientEndpoint(c *gin.Context) {
-	ingredients, err := ingredientService.GetAllIngredient()
+// @Router       /ingredients/{id} [delete]
+func deleteIngredientEndpoint(c *gin.Context) {
+	ingredientID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	err = ingredientService.DeleteIngredient(uint(ingredientID))
 	if err != nil {
+		var inUse *ingredient.InUseError
+		if errors.As(err, &inUse) {
+			c.JSON(http.StatusConflict, inUse)
+			return
+		}
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	c.JSON(http.StatusOK, ingredients)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Merge two Ingredients
+// @Description  Merge a duplicated ingredient into another one : every recipe using the duplicate will use the kept ingredient instead and the duplicate is deleted.
+// @Description  Recipes and pantries holding both ingredients get the quantities added up once converted to the same unit, the merge is refused
+// @Description  with the list of these recipes and pantries when their units can't be converted. Shopping lists get the quantities in the same unit added up.
+// @Tags         ingredients
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "ID of the duplicated ingredient"
+// @Param merge body ingredient.MergeRequest true "ingredient to keep"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      409  {object}  ingredient.MergeConflictError
+// @Failure      500
+// @Router       /ingredients/{id}/merge [post]
+func mergeIngredientEndpoint(c *gin.Context) {
+	var json ingredient.MergeRequest
+
+	ingredientID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	err = ingredientService.MergeIngredients(uint(ingredientID), json.IntoID)
+	if err != nil {
+		if errors.Is(err, ingredient.ErrSelfMerge) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		var conflict *ingredient.MergeConflictError
+		if errors.As(err, &conflict) {
+			c.JSON(http.StatusConflict, conflict)
+			return
+		}
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
 }
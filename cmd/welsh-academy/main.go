@@ -3,11 +3,19 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	docs "github.com/mjehanno/welsh-academy/docs"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
 	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+	"github.com/mjehanno/welsh-academy/pkg/mail"
+	"github.com/mjehanno/welsh-academy/pkg/oidc"
 	"github.com/mjehanno/welsh-academy/pkg/recipe"
+	"github.com/mjehanno/welsh-academy/pkg/settings"
+	"github.com/mjehanno/welsh-academy/pkg/shopping"
 	"github.com/mjehanno/welsh-academy/pkg/user"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -17,9 +25,20 @@ import (
 
 var db *gorm.DB
 var userService *user.UserService
+var roleService *user.RoleService
 var ingredientService *ingredient.IngredientService
 var recipeService *recipe.RecipeService
-var sharedKey = []byte("asupersecrettokenthatnooneshouldknow")
+var shoppingService *shopping.ShoppingService
+var keySet *auth.KeySet
+var sessionService *auth.SessionService
+var apiKeyService *auth.APIKeyService
+var throttleService *auth.ThrottleService
+var settingsService *settings.SettingsService
+var mailer mail.Mailer
+var oidcProvider *oidc.Provider
+var appURL string
+var cookieDomain string
+var secureCookies bool
 
 func init() {
 	var err error
@@ -30,30 +49,185 @@ func init() {
 		log.Fatalf("couldn't connect to database : %s", err.Error())
 	}
 
+	// roles used to be a postgres enum, they are now stored in the roles table
 	result := db.Exec(`DO $$
 BEGIN
-    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'roles') THEN
-        CREATE TYPE roles as ENUM
-        (
-            'basicuser',
-            'cheddarexpert',
-            'admin'
-        );
+    IF EXISTS (SELECT 1 FROM pg_type WHERE typname = 'roles' AND typtype = 'e') THEN
+        IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'role') THEN
+            ALTER TABLE users ALTER COLUMN role TYPE varchar(40) USING role::text;
+        END IF;
+        DROP TYPE roles;
     END IF;
 END$$;`)
 	if result.Error != nil {
-		log.Fatalf("couldn'nt create role type in db : %s", err.Error())
+		log.Fatalf("couldn't migrate the role enum to the roles table : %s", result.Error.Error())
 	}
 
-	err = db.AutoMigrate(&user.User{}, &ingredient.Ingredient{}, &recipe.Recipe{})
+	// ingredient names used to be unique among deleted ingredients too, they are now unique among the other ones only
+	result = db.Exec(`ALTER TABLE IF EXISTS ingredients DROP CONSTRAINT IF EXISTS ingredients_name_key`)
+	if result.Error != nil {
+		log.Fatalf("couldn't migrate the unique ingredient names : %s", result.Error.Error())
+	}
+
+	err = db.AutoMigrate(&user.RoleDefinition{}, &user.RolePermission{}, &user.User{}, &ingredient.Ingredient{}, &recipe.Recipe{}, &recipe.RecipeIngredient{}, &recipe.Step{}, &user.PantryItem{}, &shopping.ShoppingList{}, &shopping.ShoppingItem{}, &auth.RefreshToken{}, &auth.RevokedToken{}, &auth.APIKey{}, &auth.APIKeyScope{}, &settings.Setting{}, &user.PasswordResetToken{}, &user.TwoFactor{}, &user.RecoveryCode{}, &auth.LoginFailure{}, &user.ExternalIdentity{}, &user.Profile{})
 	if err != nil {
 		log.Fatalf("couldn't not create the database via migration : %s", err.Error())
 	}
 
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		keySet, err = auth.LoadKeySet(path)
+		if err != nil {
+			log.Fatalf("couldn't load the token signing keys : %s", err.Error())
+		}
+	} else {
+		keySet, err = auth.GenerateKeySet()
+		if err != nil {
+			log.Fatalf("couldn't generate a token signing key : %s", err.Error())
+		}
+		log.Println("JWT_KEYS_FILE isn't set, tokens are signed with a generated key and won't be valid after a restart")
+	}
+
 	userService = user.NewUserService(db)
+	userService.RegisterPersonalData(auth.PersonalData()...)
+	userService.RegisterPersonalData(shopping.PersonalData()...)
+	roleService = user.NewRoleService(db)
 	ingredientService = ingredient.NewIngredientService(db)
 	recipeService = recipe.NewRecipeService(db)
+	shoppingService = shopping.NewShoppingService(db)
+	sessionService = auth.NewSessionService(db, keySet)
+	apiKeyService = auth.NewAPIKeyService(db)
+	throttleService = auth.NewThrottleService(db, throttlePolicy())
+	settingsService = settings.NewSettingsService(db)
+	mailer = newMailer()
+
+	appURL = os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:9000"
+	}
+
+	// cookies are only sent over HTTPS unless COOKIE_SECURE is false, and to the host that set them unless COOKIE_DOMAIN is set
+	cookieDomain = os.Getenv("COOKIE_DOMAIN")
+	secureCookies = os.Getenv("COOKIE_SECURE") != "false"
+
+	err = roleService.SeedDefaultRoles()
+	if err != nil {
+		log.Fatalf("couldn't create the default roles : %s", err.Error())
+	}
+
+	oidcProvider = newOIDCProvider()
+}
+
+// newOIDCProvider returns the identity provider configured by the OIDC_* environment variables, or nil when OIDC_ISSUER isn't set.
+// OIDC_ROLES maps groups to roles as a comma separated list of group=role, the first group a user is a member of giving his role.
+func newOIDCProvider() *oidc.Provider {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+
+	config := oidc.Configuration{
+		Issuer:        issuer,
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:        strings.Fields(os.Getenv("OIDC_SCOPES")),
+		UsernameClaim: os.Getenv("OIDC_USERNAME_CLAIM"),
+		EmailClaim:    os.Getenv("OIDC_EMAIL_CLAIM"),
+		GroupsClaim:   os.Getenv("OIDC_GROUPS_CLAIM"),
+	}
+
+	if config.ClientID == "" {
+		log.Fatalf("OIDC_CLIENT_ID must be set along with OIDC_ISSUER")
+	}
+
+	if config.RedirectURL == "" {
+		config.RedirectURL = appURL + "/api/v1/users/oidc/callback"
+	}
+
+	for _, mapping := range strings.Split(os.Getenv("OIDC_ROLES"), ",") {
+		if strings.TrimSpace(mapping) == "" {
+			continue
+		}
+
+		group, role, ok := strings.Cut(mapping, "=")
+		if !ok {
+			log.Fatalf("OIDC_ROLES must be a comma separated list of group=role, got %q", mapping)
+		}
+
+		groupRole := oidc.GroupRole{Group: strings.TrimSpace(group), Role: user.Role(strings.TrimSpace(role))}
+		if _, err := roleService.GetRole(groupRole.Role); err != nil {
+			log.Fatalf("couldn't map the group %s to the role %s : %s", groupRole.Group, groupRole.Role, err.Error())
+		}
+
+		config.Roles = append(config.Roles, groupRole)
+	}
+
+	return oidc.NewProvider(config, nil)
+}
+
+// newMailer returns the mailer chosen by the MAIL_DRIVER environment variable : smtp, file or log (default).
+func newMailer() mail.Mailer {
+	switch os.Getenv("MAIL_DRIVER") {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+
+		return mail.NewSMTPMailer(mail.SMTPConfiguration{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		})
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			path = "mails.txt"
+		}
+
+		return mail.NewFileMailer(path)
+	default:
+		return mail.NewLogMailer(log.Default())
+	}
+}
+
+// throttlePolicy returns the default login throttling policy, with the failures locking an account and the duration of the lockout
+// overridden by the LOGIN_MAX_FAILURES and LOGIN_LOCKOUT_DURATION environment variables.
+func throttlePolicy() auth.ThrottlePolicy {
+	policy := auth.DefaultThrottlePolicy
+
+	if maxFailures := os.Getenv("LOGIN_MAX_FAILURES"); maxFailures != "" {
+		n, err := strconv.Atoi(maxFailures)
+		if err != nil || n < 1 {
+			log.Fatalf("LOGIN_MAX_FAILURES must be a positive number, got %q", maxFailures)
+		}
+		policy.MaxFailures = n
+	}
+
+	if lockout := os.Getenv("LOGIN_LOCKOUT_DURATION"); lockout != "" {
+		d, err := time.ParseDuration(lockout)
+		if err != nil || d <= 0 {
+			log.Fatalf("LOGIN_LOCKOUT_DURATION must be a positive duration such as 30m, got %q", lockout)
+		}
+		policy.LockoutDuration = d
+	}
+
+	return policy
+}
 
+// purgeExpiredTokens regularly deletes the tokens and the failed logins that have expired, they don't need to be kept to be rejected.
+func purgeExpiredTokens() {
+	for range time.Tick(time.Hour) {
+		if err := sessionService.PurgeExpired(); err != nil {
+			log.Printf("couldn't purge expired tokens : %s", err.Error())
+		}
+
+		if err := throttleService.PurgeExpired(); err != nil {
+			log.Printf("couldn't purge expired failed logins : %s", err.Error())
+		}
+	}
 }
 
 func createAdminUser() {
@@ -70,6 +244,24 @@ func createAdminUser() {
 	}
 }
 
+// migrateRecipeAuthors attributes the recipes created before their author was recorded to the admin created on the first start.
+func migrateRecipeAuthors() {
+	admin, err := userService.GetSeededAdmin()
+	if err != nil {
+		log.Printf("couldn't find the admin to attribute the recipes without author to : %s", err.Error())
+		return
+	}
+
+	count, err := recipeService.SetMissingAuthor(admin.ID)
+	if err != nil {
+		log.Fatalf("couldn't attribute the recipes without author to %s : %s", admin.Username, err.Error())
+	}
+
+	if count > 0 {
+		log.Printf("%d recipes without author attributed to %s", count, admin.Username)
+	}
+}
+
 // @title           Welsh-Academy OpenAPI Spec
 // @version         1.2.3
 // @description     This is a rest api made to handle some recipe so please have a sit and chees... chill !
@@ -81,38 +273,139 @@ func createAdminUser() {
 // @BasePath  /api/v1
 func main() {
 	createAdminUser()
+	migrateRecipeAuthors()
+	go purgeExpiredTokens()
 	r := gin.Default()
 	err := r.SetTrustedProxies(nil)
 	if err != nil {
 		log.Printf("couldn't unset trusted proxies on http server : %s", err.Error())
 	}
 
+	authenticated := auth.RequireAuthentication()
+	can := func(permissions ...user.Permission) gin.HandlerFunc {
+		return auth.RequirePermissions(roleService, permissions...)
+	}
+	// the author of a recipe can modify it as long as he can create recipes, other users need the permission
+	authorOr := func(permission user.Permission) gin.HandlerFunc {
+		return auth.RequireOwnerOrPermissions(roleService, recipeAuthor, user.RecipeCreate, permission)
+	}
+
 	docs.SwaggerInfo.BasePath = "/api/v1"
-	api := r.Group("/api")
+	api := r.Group("/api", auth.Authenticate(keySet, sessionService, apiKeyService))
 	{
 		v1 := api.Group("/v1")
 		{
-			user := v1.Group("/users")
+			v1.GET("/.well-known/jwks.json", getJWKSEndpoint)
+
+			users := v1.Group("/users")
 			{
-				user.POST("/", createUserEndpoint)
-				user.POST("/login", loginUserEndpoint)
+				users.POST("/", can(user.UserManage), createUserEndpoint)
+				users.POST("/login", loginUserEndpoint)
+				users.POST("/login/2fa", loginTwoFactorEndpoint)
+				users.POST("/token/refresh", refreshTokenEndpoint)
+				users.POST("/logout", logoutUserEndpoint)
+				users.POST("/register", registerUserEndpoint)
+				users.GET("/verify", verifyEmailEndpoint)
+				users.POST("/verify/resend", resendVerificationEndpoint)
+				users.POST("/password/forgot", forgotPasswordEndpoint)
+				users.POST("/password/reset", resetPasswordEndpoint)
 
-				favorites := user.Group("/favorites")
+				if oidcProvider != nil {
+					users.GET("/oidc/login", oidcLoginEndpoint)
+					users.GET("/oidc/callback", oidcCallbackEndpoint)
+				}
+
+				me := users.Group("/me", authenticated, rejectAPIKey)
+				{
+					me.GET("", getMeEndpoint)
+					me.PATCH("", updateMeEndpoint)
+					me.DELETE("", deleteMeEndpoint)
+					me.GET("/export", exportPersonalDataEndpoint)
+					me.PUT("/password", changePasswordEndpoint)
+					me.POST("/2fa", startTwoFactorEndpoint)
+					me.POST("/2fa/enable", enableTwoFactorEndpoint)
+					me.DELETE("/2fa", disableTwoFactorEndpoint)
+				}
+
+				favorites := users.Group("/favorites", authenticated, rejectAPIKey)
 				{
 					favorites.POST("/", createFavoriteRecipeEndpoint)
 					favorites.GET("/", getFavoriteRecipeEndpoint)
 					favorites.DELETE("/:recipeId", deleteFavoriteRecipeEndpoint)
 				}
+
+				pantry := users.Group("/pantry", authenticated, rejectAPIKey)
+				{
+					pantry.GET("/", getPantryEndpoint)
+					pantry.POST("/", createPantryItemEndpoint)
+					pantry.PUT("/:itemId", updatePantryItemEndpoint)
+					pantry.DELETE("/:itemId", deletePantryItemEndpoint)
+				}
+
+				apiKeys := users.Group("/api-keys", authenticated, rejectAPIKey)
+				{
+					apiKeys.GET("/", getAPIKeysEndpoint)
+					apiKeys.POST("/", createAPIKeyEndpoint)
+					apiKeys.DELETE("/:keyId", deleteAPIKeyEndpoint)
+				}
+
+				shoppingLists := users.Group("/shopping-lists", authenticated, rejectAPIKey)
+				{
+					shoppingLists.GET("/", getShoppingListsEndpoint)
+					shoppingLists.POST("/", createShoppingListEndpoint)
+					shoppingLists.GET("/:listId", getShoppingListEndpoint)
+					shoppingLists.DELETE("/:listId", deleteShoppingListEndpoint)
+					shoppingLists.PATCH("/:listId/items/:itemId", checkShoppingItemEndpoint)
+				}
+
+				users.GET("/:username", getPublicProfileEndpoint)
+			}
+			admin := v1.Group("/admin", can(user.UserManage))
+			{
+				admin.GET("/registration", getRegistrationEndpoint)
+				admin.PUT("/registration", updateRegistrationEndpoint)
+				admin.POST("/invitations", createInvitationEndpoint)
+				admin.GET("/two-factor", getTwoFactorPolicyEndpoint)
+				admin.PUT("/two-factor", updateTwoFactorPolicyEndpoint)
+				admin.GET("/users", getUsersEndpoint)
+				admin.PUT("/users/:id/role", updateUserRoleEndpoint)
+				admin.POST("/users/:id/suspend", suspendUserEndpoint)
+				admin.POST("/users/:id/reactivate", reactivateUserEndpoint)
+				admin.DELETE("/users/:id", deleteUserEndpoint)
+				admin.POST("/users/:id/unlock", unlockUserEndpoint)
+			}
+			roles := v1.Group("/roles", can(user.RoleManage))
+			{
+				roles.GET("/", getRolesEndpoint)
+				roles.GET("/permissions", getPermissionsEndpoint)
+				roles.PUT("/:name", saveRoleEndpoint)
+				roles.DELETE("/:name", deleteRoleEndpoint)
 			}
 			ingredient := v1.Group("/ingredients")
 			{
-				ingredient.POST("/", createIngredientEndpoint)
+				ingredient.POST("/", can(user.IngredientCreate), createIngredientEndpoint)
 				ingredient.GET("/", getIngredientEndpoint)
+				ingredient.PUT("/:id", can(user.IngredientUpdate), updateIngredientEndpoint)
+				ingredient.DELETE("/:id", can(user.IngredientDelete), deleteIngredientEndpoint)
+				ingredient.POST("/:id/merge", can(user.IngredientUpdate, user.IngredientDelete), mergeIngredientEndpoint)
 			}
 			recipe := v1.Group("/recipes")
 			{
 				recipe.GET("/", getRecipeEndoint)
-				recipe.POST("/", createRecipeEndpoint)
+				recipe.POST("/", can(user.RecipeCreate), createRecipeEndpoint)
+				recipe.GET("/cookable", getCookableRecipeEndpoint)
+				recipe.GET("/:id", getRecipeByIdEndpoint)
+				recipe.PUT("/:id", authorOr(user.RecipeUpdate), updateRecipeEndpoint)
+				recipe.PATCH("/:id", authorOr(user.RecipeUpdate), patchRecipeEndpoint)
+				recipe.DELETE("/:id", authorOr(user.RecipeDelete), deleteRecipeEndpoint)
+
+				steps := recipe.Group("/:id/steps")
+				{
+					steps.GET("/", getStepsEndpoint)
+					steps.POST("/", authorOr(user.RecipeUpdate), createStepEndpoint)
+					steps.PUT("/", authorOr(user.RecipeUpdate), reorderStepsEndpoint)
+					steps.DELETE("/:stepId", authorOr(user.RecipeUpdate), deleteStepEndpoint)
+				}
 			}
 		}
 	}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+)
+
+// oidcCookie is the name of the cookie keeping the state of a login through the identity provider until the user comes back.
+const oidcCookie = "oidc_login"
+
+const oidcLoginLifetime = 10 * time.Minute
+
+// oidcCookiePath restricts the cookie to the routes of the login through the identity provider.
+const oidcCookiePath = "/api/v1/users/oidc"
+
+// @Summary Log in with the identity provider
+// @Schemes
+// @Description Redirect the user to the identity provider to log in, he is sent back to /users/oidc/callback afterward.
+// @Description Only available when an identity provider is configured.
+// @Tags users
+// @Success 302
+// @Failure 500
+// @Router /users/oidc/login [get]
+func oidcLoginEndpoint(c *gin.Context) {
+	request, err := oidcProvider.AuthorizationURL()
+	if err != nil {
+		log.Printf("couldn't start a login through the identity provider : %s", err.Error())
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	token, err := keySet.SignPurpose(auth.OIDCLogin, auth.PurposeClaims{State: request.State, Nonce: request.Nonce, Verifier: request.Verifier}, oidcLoginLifetime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.SetCookie(oidcCookie, token, int(oidcLoginLifetime.Seconds()), oidcCookiePath, cookieDomain, secureCookies, true)
+	c.Redirect(http.StatusFound, request.URL)
+}
+
+// @Summary Identity provider callback
+// @Schemes
+// @Description Finish the login through the identity provider. On his first login, the user is linked to the active user having the same verified email,
+// @Description or created, replacing a pending user having this email. His role follows his groups at the provider when they are mapped to roles.
+// @Description Returns an access token and a refresh token, also sent as cookies, or a two-factor challenge like /users/login.
+// @Tags users
+// @Produce json
+// @Param code query string true "authorization code"
+// @Param state query string true "state of the login"
+// @Success 200 {object} auth.TokenPair
+// @Success 202 {object} user.TwoFactorChallenge
+// @Failure 400 {object} error.ErrorResponse
+// @Failure 401 {object} error.ErrorResponse
+// @Failure 403 {object} error.ErrorResponse
+// @Failure 500
+// @Router /users/oidc/callback [get]
+func oidcCallbackEndpoint(c *gin.Context) {
+	cookie, _ := c.Cookie(oidcCookie)
+	c.SetCookie(oidcCookie, "", -1, oidcCookiePath, cookieDomain, secureCookies, true)
+
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusUnauthorized, error.ErrorResponse{ErrorMessage: "the identity provider refused the login : " + providerError})
+		return
+	}
+
+	claims, err := keySet.VerifyPurpose(cookie, auth.OIDCLogin)
+	if err != nil || claims.State == "" || subtle.ConstantTimeCompare([]byte(claims.State), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "the login has expired or was started elsewhere, please log in again"})
+		return
+	}
+
+	identity, err := oidcProvider.Exchange(c.Query("code"), claims.Verifier, claims.Nonce)
+	if err != nil {
+		log.Printf("couldn't finish a login through the identity provider : %s", err.Error())
+		c.JSON(http.StatusUnauthorized, error.ErrorResponse{ErrorMessage: "the identity provider couldn't confirm the login"})
+		return
+	}
+
+	loggedUser, err := userService.LoginExternal(user.ExternalUser{
+		Issuer:        identity.Issuer,
+		Subject:       identity.Subject,
+		Username:      identity.Username,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Role:          oidcProvider.RoleFor(identity.Groups),
+	})
+	if err != nil {
+		if errors.Is(err, user.ErrAccountNotActive) {
+			c.JSON(http.StatusForbidden, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	// the second factor is only asked when the provider didn't already ask for one
+	if !identity.MultiFactor && challengeSecondFactor(c, loggedUser.ID) {
+		return
+	}
+
+	pair, err := sessionService.StartSession(loggedUser, identity.MultiFactor)
+	if err != nil {
+		log.Printf("error while starting session : %s", err.Error())
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	setSessionCookies(c, pair)
+	c.JSON(http.StatusOK, pair)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+)
+
+const defaultPageSize = 20
+const maxPageSize = 100
+
+// parsePagination reads the page and limit query parameters, writing a 400 response and returning false if they aren't valid.
+func parsePagination(c *gin.Context) (int, int, bool) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "page must be a positive number"})
+		return 0, 0, false
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "limit must be between 1 and " + strconv.Itoa(maxPageSize)})
+		return 0, 0, false
+	}
+
+	return page, limit, true
+}
+
+// setTotalCount exposes the total number of paginated items in the X-Total-Count header.
+func setTotalCount(c *gin.Context, total int64) {
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+const defaultExpiringWithinDays = 3
+
+// pantryIngredients returns the ingredients of the logged user's pantry that aren't expired, writing an error response and returning false if it can't.
+func pantryIngredients(c *gin.Context) ([]ingredient.Ingredient, bool) {
+	currentUser, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, nil)
+		return nil, false
+	}
+
+	ingredients, err := userService.GetPantryIngredients(currentUser.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return nil, false
+	}
+
+	return ingredients, true
+}
+
+// @Summary      Get pantry
+// @Description  Get the ingredients a user has at home, the ones expiring first coming first.
+// @Tags         pantry
+// @Produce      json
+// @Param	expiring_within query int false "number of days before an item is flagged as expiring soon" default(3)
+// @Success      200  {array}  user.PantryItem
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /users/pantry [get]
+func getPantryEndpoint(c *gin.Context) {
+	currentUser := auth.MustCurrentUser(c)
+
+	days, err := strconv.Atoi(c.DefaultQuery("expiring_within", strconv.Itoa(defaultExpiringWithinDays)))
+	if err != nil || days < 0 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "expiring_within can't be a negative number of days"})
+		return
+	}
+
+	items, err := userService.GetPantry(currentUser.ID, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// @Summary      Add an ingredient to the pantry
+// @Description  Add an ingredient, referenced by ID or by name, to the user's pantry with an optional quantity and expiry date.
+// @Tags         pantry
+// @Accept       json
+// @Produce      json
+// @Param item body user.PantryItem true "item to add"
+// @Success      201  {integer}  id
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      409  {object}  error.ErrorResponse
+// @Failure      500
+// @Router       /users/pantry [post]
+func createPantryItemEndpoint(c *gin.Context) {
+	var json user.PantryItem
+
+	currentUser := auth.MustCurrentUser(c)
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if json.Quantity < 0 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't have a negative quantity of an ingredient"})
+		return
+	}
+
+	if json.IngredientID == 0 {
+		ing, err := ingredientService.GetIngredientByName(json.Ingredient.Name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "ingredient " + json.Ingredient.Name + " doesn't exist"})
+			return
+		}
+
+		json.IngredientID = ing.ID
+	} else if _, err := ingredientService.GetIngredientById(json.IngredientID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "ingredient " + strconv.FormatUint(uint64(json.IngredientID), 10) + " doesn't exist"})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	items, err := userService.GetPantry(currentUser.ID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	for _, item := range items {
+		if item.IngredientID == json.IngredientID {
+			c.JSON(http.StatusConflict, error.ErrorResponse{ErrorMessage: "this ingredient is already in your pantry, update it instead"})
+			return
+		}
+	}
+
+	json.ID = 0
+	json.UserID = currentUser.ID
+	id, err := userService.AddPantryItem(json)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// @Summary      Update a pantry item
+// @Description  Update the quantity, unit and expiry date of an item of the user's pantry.
+// @Tags         pantry
+// @Accept       json
+// @Produce      json
+// @Param        itemId   path      int  true  "Pantry item ID"
+// @Param item body user.PantryItem true "new quantity, unit and expiry date"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /users/pantry/{itemId} [put]
+func updatePantryItemEndpoint(c *gin.Context) {
+	var json user.PantryItem
+
+	currentUser := auth.MustCurrentUser(c)
+
+	itemID, err := strconv.ParseUint(c.Param("itemId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if json.Quantity < 0 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't have a negative quantity of an ingredient"})
+		return
+	}
+
+	json.ID = uint(itemID)
+	json.UserID = currentUser.ID
+	err = userService.UpdatePantryItem(json)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Remove a pantry item
+// @Description  Remove an ingredient from the user's pantry.
+// @Tags         pantry
+// @Produce      json
+// @Param        itemId   path      int  true  "Pantry item ID"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /users/pantry/{itemId} [delete]
+func deletePantryItemEndpoint(c *gin.Context) {
+	currentUser := auth.MustCurrentUser(c)
+
+	itemID, err := strconv.ParseUint(c.Param("itemId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	err = userService.DeletePantryItem(currentUser.ID, uint(itemID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/mail"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+// validPassword tells if a password is long enough, writing a 400 response if it isn't.
+func validPassword(c *gin.Context, password string) bool {
+	if len(password) < user.MinPasswordLength {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "password must be at least " + strconv.Itoa(user.MinPasswordLength) + " characters long"})
+		return false
+	}
+
+	return true
+}
+
+// @Summary      Change password
+// @Description  Change the password of the logged user, the current password is required.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param passwords body user.PasswordChange true "current and new password"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /users/me/password [put]
+func changePasswordEndpoint(c *gin.Context) {
+	var json user.PasswordChange
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if !validPassword(c, json.NewPassword) {
+		return
+	}
+
+	currentUser := auth.MustCurrentUser(c)
+
+	err := userService.ChangePassword(currentUser.ID, json.CurrentPassword, json.NewPassword)
+	if err != nil {
+		if errors.Is(err, user.ErrWrongPassword) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Forgot password
+// @Description  Send a password reset token, valid for an hour, to the email of a user.
+// @Description  The response is the same whether a user has this email or not.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param request body user.PasswordResetRequest true "email of the user"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      500
+// @Router       /users/password/forgot [post]
+func forgotPasswordEndpoint(c *gin.Context) {
+	var json user.PasswordResetRequest
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	resetUser, token, err := userService.CreatePasswordReset(json.Email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNoContent, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	err = mailer.Send(mail.Message{
+		To:      resetUser.Email,
+		Subject: "Reset your Welsh Academy password",
+		Body: "Hello " + resetUser.Username + ",\n\n" +
+			"Someone asked to reset your password. If it was you, choose a new password within an hour by sending the following token to " + appURL + "/api/v1/users/password/reset :\n" +
+			token + "\n\n" +
+			"Otherwise you can ignore this email.\n",
+	})
+	if err != nil {
+		log.Printf("couldn't send the password reset email of user %d : %s", resetUser.ID, err.Error())
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Reset password
+// @Description  Choose a new password with the token received by email, every session of the user is revoked.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param reset body user.PasswordReset true "reset token and new password"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      500
+// @Router       /users/password/reset [post]
+func resetPasswordEndpoint(c *gin.Context) {
+	var json user.PasswordReset
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if !validPassword(c, json.NewPassword) {
+		return
+	}
+
+	_, err := userService.ResetPassword(json.Token, json.NewPassword, auth.RevokeUserSessionsTx)
+	if err != nil {
+		if errors.Is(err, user.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kataras/jwt"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"gorm.io/gorm"
+)
+
+// @Summary      Export the data of the logged user
+// @Description  Download a ZIP archive holding a JSON file per kind of data linked to the logged user : his profile, favorites, recipes, pantry,
+// @Description  shopping lists, API keys and activity such as his sessions and failed logins.
+// @Tags         profile
+// @Produce      application/zip
+// @Success      200
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /users/me/export [get]
+func exportPersonalDataEndpoint(c *gin.Context) {
+	currentUser := auth.MustCurrentUser(c)
+
+	files, err := userService.ExportPersonalData(currentUser.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="welsh-academy-export.zip"`)
+	c.Status(http.StatusOK)
+
+	// the archive is streamed, so an error can't change the status anymore and only ends the download early
+	archive := zip.NewWriter(c.Writer)
+	now := time.Now()
+
+	for _, file := range files {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: file.Name + ".json", Method: zip.Deflate, Modified: now})
+		if err != nil {
+			log.Printf("error while exporting the data of %q : %s", currentUser.Username, err.Error())
+			return
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.Data); err != nil {
+			log.Printf("error while exporting the data of %q : %s", currentUser.Username, err.Error())
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Printf("error while exporting the data of %q : %s", currentUser.Username, err.Error())
+	}
+}
+
+// @Summary      Delete the logged user
+// @Description  Delete the account of the logged user and erase every data linked to him, then log him out.
+// @Description  The recipes he shared are kept and attributed to a deleted user.
+// @Tags         profile
+// @Success      204
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /users/me [delete]
+func deleteMeEndpoint(c *gin.Context) {
+	currentUser := auth.MustCurrentUser(c)
+
+	if err := userService.ErasePersonalData(currentUser.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, nil)
+			return
+		}
+
+		log.Printf("error while erasing the data of %q : %s", currentUser.Username, err.Error())
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	var claims *jwt.Claims
+	if currentClaims, ok := auth.CurrentClaims(c); ok {
+		claims = &currentClaims
+	}
+
+	if err := sessionService.EndSession(claims, ""); err != nil {
+		log.Printf("error while revoking the access token of deleted user %d : %s", currentUser.ID, err.Error())
+	}
+
+	log.Printf("user %d deleted his account", currentUser.ID)
+	clearSessionCookies(c)
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/units"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+// @Summary      Get the logged user
+// @Description  Get the logged user along with his profile.
+// @Tags         profile
+// @Produce      json
+// @Success      200  {object}  user.Account
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /users/me [get]
+func getMeEndpoint(c *gin.Context) {
+	currentUser := auth.MustCurrentUser(c)
+
+	account, err := userService.GetAccount(currentUser.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, account)
+}
+
+// @Summary      Update the profile of the logged user
+// @Description  Change the display name, bio, avatar, preferred units and language of the logged user, and whether his favorites are public.
+// @Description  Fields left out are kept, empty strings clear them.
+// @Tags         profile
+// @Accept       json
+// @Produce      json
+// @Param profile body user.ProfileUpdate true "fields to change"
+// @Success      200  {object}  user.Profile
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /users/me [patch]
+func updateMeEndpoint(c *gin.Context) {
+	var json user.ProfileUpdate
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	currentUser := auth.MustCurrentUser(c)
+
+	profile, err := userService.UpdateProfile(currentUser.ID, json)
+	if err != nil {
+		if errors.Is(err, user.ErrDisplayNameTooLong) || errors.Is(err, user.ErrBioTooLong) || errors.Is(err, user.ErrInvalidAvatar) ||
+			errors.Is(err, user.ErrInvalidLanguage) || errors.Is(err, units.ErrUnknownSystem) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// @Summary      Get the public profile of a user
+// @Description  Get the public profile of an active user, with his favorites when he made them public.
+// @Tags         profile
+// @Produce      json
+// @Param        username   path      string  true  "Username"
+// @Success      200  {object}  user.PublicProfile
+// @Failure      404
+// @Failure      500
+// @Router       /users/{username} [get]
+func getPublicProfileEndpoint(c *gin.Context) {
+	profile, err := userService.GetPublicProfile(c.Param("username"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
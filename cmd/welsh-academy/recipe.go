@@ -1,68 +1,229 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/kataras/jwt"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
 	"github.com/mjehanno/welsh-academy/pkg/error"
 	"github.com/mjehanno/welsh-academy/pkg/ingredient"
 	"github.com/mjehanno/welsh-academy/pkg/recipe"
-	"github.com/mjehanno/welsh-academy/pkg/user"
+	"github.com/mjehanno/welsh-academy/pkg/units"
+	"gorm.io/gorm"
 )
 
+// parseIngredientQuery reads a list of ingredient names from a query parameter (repeated or comma separated) and returns the corresponding ingredients, writing a 400 response and returning false if one of them doesn't exist.
+func parseIngredientQuery(c *gin.Context, key string) ([]ingredient.Ingredient, bool) {
+	ingredientsName := c.QueryArray(key)
+	if len(ingredientsName) == 1 && strings.Contains(ingredientsName[0], ",") {
+		ingredientsName = strings.Split(ingredientsName[0], ",")
+	}
+
+	ingredients := make([]ingredient.Ingredient, len(ingredientsName))
+	for i, name := range ingredientsName {
+		ing, err := ingredientService.GetIngredientByName(name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "ingredient " + name + " doesn't exist"})
+			return nil, false
+		}
+
+		ingredients[i] = ing
+	}
+
+	return ingredients, true
+}
+
+// parseServings reads the servings query parameter, returning 0 if recipes shouldn't be scaled and writing a 400 response and returning false if it isn't valid.
+func parseServings(c *gin.Context) (uint, bool) {
+	if c.Query("servings") == "" {
+		return 0, true
+	}
+
+	servings, err := strconv.ParseUint(c.Query("servings"), 10, 32)
+	if err != nil || servings == 0 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "servings must be a positive number"})
+		return 0, false
+	}
+
+	return uint(servings), true
+}
+
 // @Summary      Get All Recipe
-// @Description  Get the list of every created recipe with their ingredient.
+// @Description  Get the list of every created recipe with their ingredient and steps, optionally filtered by ingredients.
+// @Description  With match=all (default) recipes must contain every ingredient, with match=any at least one of them and with match=none none of them.
+// @Description  Excluded ingredients are never in the returned recipes. Recipes matching the most ingredients come first.
+// @Description  When searching the pantry, match defaults to any so that recipes using some of its ingredients are returned.
 // @Tags         recipes
 // @Produce      json
 // @Param	ingredient query []string false "filter by ingredient"
-// @Success      200  {array}  recipe.Recipe
-// @Failure      400
+// @Param	exclude query []string false "exclude recipes containing these ingredients"
+// @Param	match query string false "how ingredients are matched, any by default with pantry=true" Enums(all, any, none)
+// @Param	pantry query bool false "also search the non expired ingredients of your pantry"
+// @Param	servings query int false "scale ingredient quantities for this number of people"
+// @Param	units query string false "convert ingredient quantities to this unit system" Enums(metric, imperial)
+// @Param	author query string false "only return the recipes created by the user having this username"
+// @Success      200  {array}  recipe.SearchResult
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
 // @Failure      500
 // @Router       /recipes [get]
 func getRecipeEndoint(c *gin.Context) {
-	ingredientQuery := c.QueryArray("ingredient")
-	if len(ingredientQuery) > 0 {
-		ingredientsName := ingredientQuery
-		if len(ingredientQuery) == 1 && strings.Contains(ingredientQuery[0], ",") {
-			ingredientsName = strings.Split(ingredientQuery[0], ",")
+	usePantry := c.Query("pantry") == "true"
+
+	mode := c.Query("match")
+	if mode == "" && usePantry {
+		mode = string(recipe.MatchAny)
+	}
+
+	match, err := recipe.ParseMatchMode(mode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	ingredients, ok := parseIngredientQuery(c, "ingredient")
+	if !ok {
+		return
+	}
+
+	excluded, ok := parseIngredientQuery(c, "exclude")
+	if !ok {
+		return
+	}
+
+	servings, ok := parseServings(c)
+	if !ok {
+		return
+	}
+
+	system, err := units.ParseSystem(c.Query("units"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if usePantry {
+		pantry, ok := pantryIngredients(c)
+		if !ok {
+			return
 		}
-		ingredients := make([]ingredient.Ingredient, len(ingredientsName))
 
-		for i, name := range ingredientsName {
-			ing, err := ingredientService.GetIngredientByName(name)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
-				return
+		for _, pantryIngredient := range pantry {
+			alreadySearched := false
+			for _, searched := range ingredients {
+				if searched.ID == pantryIngredient.ID {
+					alreadySearched = true
+					break
+				}
 			}
 
-			ingredients[i] = ing
+			if !alreadySearched {
+				ingredients = append(ingredients, pantryIngredient)
+			}
 		}
+	}
+
+	recipes, err := recipeService.SearchRecipes(recipe.SearchQuery{Ingredients: ingredients, Exclude: excluded, Match: match, Author: c.Query("author")})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	for i := range recipes {
+		recipes[i].Recipe = recipes[i].Recipe.Scale(servings).ConvertUnits(system)
+	}
+
+	c.JSON(http.StatusOK, recipes)
+}
+
+// validateRecipe checks the content of a recipe sent by a user and resolves its ingredients, writing a 400 response and returning false if it isn't valid.
+func validateRecipe(c *gin.Context, json *recipe.Recipe) bool {
+	if json.Name == "" || len(json.Ingredients) == 0 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create a recipe without a name or without ingredients"})
+		return false
+	}
+
+	usedIngredients := make(map[uint]bool, len(json.Ingredients))
+	for i, recipeIngredient := range json.Ingredients {
+		if recipeIngredient.Quantity < 0 {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't use a negative quantity of " + recipeIngredient.Ingredient.Name})
+			return false
+		}
+
+		if recipeIngredient.Quantity == 0 && recipeIngredient.Unit != "" {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't specify a unit without a quantity for " + recipeIngredient.Ingredient.Name})
+			return false
+		}
+
+		if recipeIngredient.IngredientID == 0 {
+			ing, err := ingredientService.GetIngredientByName(recipeIngredient.Ingredient.Name)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "ingredient " + recipeIngredient.Ingredient.Name + " doesn't exist"})
+				return false
+			}
+
+			json.Ingredients[i].IngredientID = ing.ID
+		} else if _, err := ingredientService.GetIngredientById(recipeIngredient.IngredientID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "ingredient " + strconv.FormatUint(uint64(recipeIngredient.IngredientID), 10) + " doesn't exist"})
+				return false
+			}
 
-		recipes, err := recipeService.GetRecipeByIngredient(ingredients)
-		if err != nil {
 			c.JSON(http.StatusInternalServerError, nil)
-			return
+			return false
 		}
 
-		c.JSON(http.StatusOK, recipes)
-		return
+		if usedIngredients[json.Ingredients[i].IngredientID] {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't use the same ingredient twice in a recipe"})
+			return false
+		}
+		usedIngredients[json.Ingredients[i].IngredientID] = true
+	}
+
+	for i := range json.Steps {
+		if json.Steps[i].Text == "" {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create a step without text"})
+			return false
+		}
+
+		if err := json.Steps[i].ResolveIngredients(json.Ingredients); err != nil {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return false
+		}
 
+		json.Steps[i].Position = uint(i) + 1
 	}
 
-	recipes, err := recipeService.GetAllRecipe()
+	return true
+}
+
+// recipeAuthor returns the author of the recipe of the request, writing a 400 or 404 response and returning false if there is no such recipe.
+func recipeAuthor(c *gin.Context) (uint, bool) {
+	recipeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return 0, false
+	}
 
+	authorID, err := recipeService.GetRecipeAuthor(uint(recipeID))
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return 0, false
+		}
+
 		c.JSON(http.StatusInternalServerError, nil)
-		return
+		return 0, false
 	}
 
-	c.JSON(http.StatusOK, recipes)
+	return authorID, true
 }
 
 // @Summary      Create a Recipe
-// @Description  Create a Recipe with one or multiple ingredient.
+// @Description  Create a Recipe with one or multiple ingredient and its ordered steps, the logged user being its author.
 // @Tags         recipes
 // @Accept       json
 // @Produce      json
@@ -76,27 +237,141 @@ func getRecipeEndoint(c *gin.Context) {
 func createRecipeEndpoint(c *gin.Context) {
 	var json recipe.Recipe
 
-	cookie, err := c.Cookie("jwt")
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if !validateRecipe(c, &json) {
+		return
+	}
+
+	json.AuthorID = auth.MustCurrentUser(c).ID
+	json.Author = nil
+
+	id, err := recipeService.CreateRecipe(json)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, nil)
+		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	verifiedToken, err := jwt.Verify(jwt.HS256, sharedKey, []byte(cookie))
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// @Summary      Get a Recipe
+// @Description  Get a recipe with its ingredients and steps, quantities can be scaled for a given number of people and converted to metric or imperial units.
+// @Tags         recipes
+// @Produce      json
+// @Param        id   path      int  true  "Recipe ID"
+// @Param	servings query int false "scale ingredient quantities for this number of people"
+// @Param	units query string false "convert ingredient quantities to this unit system" Enums(metric, imperial)
+// @Success      200  {object}  recipe.Recipe
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      404
+// @Failure      500
+// @Router       /recipes/{id} [get]
+func getRecipeByIdEndpoint(c *gin.Context) {
+	recipeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	servings, ok := parseServings(c)
+	if !ok {
+		return
+	}
+
+	system, err := units.ParseSystem(c.Query("units"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	recipe, err := recipeService.GetRecipeById(uint(recipeID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	var currentUser user.User
-	err = verifiedToken.Claims(&currentUser)
+	c.JSON(http.StatusOK, recipe.Scale(servings).ConvertUnits(system))
+}
+
+// @Summary      Replace a Recipe
+// @Description  Replace the name, ingredients and steps of a recipe. Its author can replace it, other users need the recipe:update permission.
+// @Tags         recipes
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Recipe ID"
+// @Param recipe body recipe.Recipe true "new content of the recipe"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /recipes/{id} [put]
+func updateRecipeEndpoint(c *gin.Context) {
+	var json recipe.Recipe
+
+	recipeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if !validateRecipe(c, &json) {
+		return
+	}
+
+	json.ID = uint(recipeID)
+	err = recipeService.UpdateRecipe(json)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	if currentUser.Role != user.CheddarExpert {
-		c.JSON(http.StatusForbidden, nil)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Update a Recipe
+// @Description  Update some fields of a recipe, omitted fields are kept. Steps using an ingredient removed from the recipe must be updated in the same request.
+// @Description  Its author can update it, other users need the recipe:update permission.
+// @Tags         recipes
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Recipe ID"
+// @Param recipe body recipe.Recipe true "fields of the recipe to update"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /recipes/{id} [patch]
+func patchRecipeEndpoint(c *gin.Context) {
+	var json recipe.Recipe
+
+	recipeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
 		return
 	}
 
@@ -105,18 +380,152 @@ func createRecipeEndpoint(c *gin.Context) {
 		return
 	}
 
-	if json.Name == "" || len(json.Ingredients) == 0 {
-		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create a recipe without a name or without ingredients"})
+	existingRecipe, err := recipeService.GetRecipeById(uint(recipeID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	id, err := recipeService.CreateRecipe(json)
+	if json.Name != "" {
+		existingRecipe.Name = json.Name
+	}
+
+	if json.Servings != 0 {
+		existingRecipe.Servings = json.Servings
+	}
+
+	if json.Ingredients != nil {
+		existingRecipe.Ingredients = json.Ingredients
+	}
+
+	if json.Steps != nil {
+		existingRecipe.Steps = json.Steps
+	}
+
+	if !validateRecipe(c, &existingRecipe) {
+		return
+	}
+
+	err = recipeService.UpdateRecipe(existingRecipe)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Delete a Recipe
+// @Description  Delete a recipe, it's only soft deleted in the database. Its author can delete it, other users need the recipe:delete permission.
+// @Tags         recipes
+// @Produce      json
+// @Param        id   path      int  true  "Recipe ID"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /recipes/{id} [delete]
+func deleteRecipeEndpoint(c *gin.Context) {
+	recipeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	err = recipeService.DeleteRecipe(uint(recipeID))
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"id": id,
-	})
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Get cookable recipes
+// @Description  Get the recipes that can be cooked with the given ingredients, the ones missing the fewest mandatory ingredients first.
+// @Description  When no ingredient is given, the non expired ingredients of the logged user's pantry are used.
+// @Description  The total number of recipes is returned in the X-Total-Count header.
+// @Tags         recipes
+// @Produce      json
+// @Param	ingredient query []string false "ingredients you have at home"
+// @Param	max_missing query int false "only return recipes missing at most this number of mandatory ingredients"
+// @Param	page query int false "page number, starting at 1" default(1)
+// @Param	limit query int false "number of recipes per page" default(20)
+// @Param	servings query int false "scale ingredient quantities for this number of people"
+// @Param	units query string false "convert ingredient quantities to this unit system" Enums(metric, imperial)
+// @Success      200  {array}  recipe.CookableResult
+// @Header       200  {integer}  X-Total-Count  "total number of cookable recipes"
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      500
+// @Router       /recipes/cookable [get]
+func getCookableRecipeEndpoint(c *gin.Context) {
+	owned, ok := parseIngredientQuery(c, "ingredient")
+	if !ok {
+		return
+	}
+
+	if _, loggedIn := auth.CurrentUser(c); len(owned) == 0 && loggedIn {
+		owned, ok = pantryIngredients(c)
+		if !ok {
+			return
+		}
+	}
+
+	maxMissing := -1
+	if c.Query("max_missing") != "" {
+		value, err := strconv.Atoi(c.Query("max_missing"))
+		if err != nil || value < 0 {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "max_missing can't be a negative number"})
+			return
+		}
+
+		maxMissing = value
+	}
+
+	page, limit, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	servings, ok := parseServings(c)
+	if !ok {
+		return
+	}
+
+	system, err := units.ParseSystem(c.Query("units"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	recipes, total, err := recipeService.GetCookableRecipes(recipe.CookableQuery{Owned: owned, MaxMissing: maxMissing, Page: page, PageSize: limit})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	for i := range recipes {
+		recipes[i].Recipe = recipes[i].Recipe.Scale(servings).ConvertUnits(system)
+	}
+
+	setTotalCount(c, total)
+	c.JSON(http.StatusOK, recipes)
 }
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/mail"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+const verificationTokenLifetime = user.PendingLifetime
+const invitationTokenLifetime = 7 * 24 * time.Hour
+
+// registrationMode returns the current registration mode, writing a 500 response and returning false if it can't be read.
+func registrationMode(c *gin.Context) (user.RegistrationMode, bool) {
+	mode, err := settingsService.Get(user.RegistrationModeSetting, string(user.RegistrationClosed))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return "", false
+	}
+
+	return user.RegistrationMode(mode), true
+}
+
+// validEmail tells if an email address is a bare address, without display name.
+func validEmail(email string) bool {
+	address, err := netmail.ParseAddress(email)
+
+	return err == nil && address.Address == email
+}
+
+// @Summary Register
+// @Schemes
+// @Description Create a basic user by yourself when registration isn't closed, an invitation is required when registration is invite-only.
+// @Description The account stays pending until it is activated with the link sent by email, invited users are activated right away.
+// @Description A pending account that wasn't activated within 24 hours is replaced by the next registration with the same username or email.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param registration body user.Registration true "user registering"
+// @Success 201 {number} id
+// @Failure 400 {object} error.ErrorResponse
+// @Failure 403 {object} error.ErrorResponse
+// @Failure 409 {object} error.ErrorResponse
+// @Failure 500
+// @Router /users/register [post]
+func registerUserEndpoint(c *gin.Context) {
+	mode, ok := registrationMode(c)
+	if !ok {
+		return
+	}
+
+	if mode == user.RegistrationClosed {
+		c.JSON(http.StatusForbidden, error.ErrorResponse{ErrorMessage: "registration is closed"})
+		return
+	}
+
+	var json user.Registration
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if json.Username == "" || len(json.Username) > 40 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "username must be between 1 and 40 characters"})
+		return
+	}
+
+	if !validPassword(c, json.Password) {
+		return
+	}
+
+	if !validEmail(json.Email) {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "email isn't a valid address"})
+		return
+	}
+
+	// the invitation was sent to the address, so it doesn't need to be verified again
+	verified := false
+	if mode == user.RegistrationInviteOnly {
+		claims, err := keySet.VerifyPurpose(json.Invitation, auth.Invitation)
+		if err != nil || !strings.EqualFold(claims.Email, json.Email) {
+			c.JSON(http.StatusForbidden, error.ErrorResponse{ErrorMessage: "a valid invitation is required to register"})
+			return
+		}
+		verified = true
+	}
+
+	id, err := userService.Register(user.User{Username: json.Username, Password: json.Password, Email: json.Email}, verified)
+	if err != nil {
+		if errors.Is(err, user.ErrReservedUsername) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		if errors.Is(err, user.ErrUserExists) {
+			c.JSON(http.StatusConflict, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !verified {
+		sendVerificationEmail(id, json.Username, json.Email)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// sendVerificationEmail sends the link activating the account of a registered user, failures are only logged since the account is created anyway.
+func sendVerificationEmail(id uint, username string, email string) {
+	token, err := keySet.SignPurpose(auth.EmailVerification, auth.PurposeClaims{UserID: id, Email: email}, verificationTokenLifetime)
+	if err != nil {
+		log.Printf("couldn't sign the verification token of user %d : %s", id, err.Error())
+		return
+	}
+
+	err = mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Welcome to the Welsh Academy",
+		Body: "Hello " + username + ",\n\n" +
+			"Please activate your account within 24 hours by opening the following link :\n" +
+			appURL + "/api/v1/users/verify?token=" + url.QueryEscape(token) + "\n",
+	})
+	if err != nil {
+		log.Printf("couldn't send the verification email of user %d : %s", id, err.Error())
+	}
+}
+
+// @Summary Verify an email
+// @Schemes
+// @Description Activate a pending account with the token sent by email when registering.
+// @Tags users
+// @Produce json
+// @Param token query string true "verification token"
+// @Success 204
+// @Failure 400 {object} error.ErrorResponse
+// @Failure 500
+// @Router /users/verify [get]
+func verifyEmailEndpoint(c *gin.Context) {
+	claims, err := keySet.VerifyPurpose(c.Query("token"), auth.EmailVerification)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	err = userService.Activate(claims.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "the account doesn't exist or is already active"})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary Resend the verification email
+// @Schemes
+// @Description Send a new activation link, valid for 24 hours, to a pending account that wasn't replaced yet.
+// @Description The response is the same whether a pending account has this email or not.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body user.VerificationRequest true "email given when registering"
+// @Success 204
+// @Failure 400 {object} error.ErrorResponse
+// @Failure 500
+// @Router /users/verify/resend [post]
+func resendVerificationEndpoint(c *gin.Context) {
+	var json user.VerificationRequest
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	pending, err := userService.RenewPending(json.Email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNoContent, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	sendVerificationEmail(pending.ID, pending.Username, pending.Email)
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Get registration settings
+// @Description  Get who can register by himself.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  user.RegistrationSettings
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /admin/registration [get]
+func getRegistrationEndpoint(c *gin.Context) {
+	mode, ok := registrationMode(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, user.RegistrationSettings{Mode: mode})
+}
+
+// @Summary      Update registration settings
+// @Description  Switch registration between open, invite-only and closed.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param settings body user.RegistrationSettings true "registration settings"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /admin/registration [put]
+func updateRegistrationEndpoint(c *gin.Context) {
+	var json user.RegistrationSettings
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if err := json.Mode.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if err := settingsService.Set(user.RegistrationModeSetting, string(json.Mode)); err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Invite a user
+// @Description  Send an invitation to register to an email address, it is valid for 7 days.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param invitation body user.Invitation true "invited address"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /admin/invitations [post]
+func createInvitationEndpoint(c *gin.Context) {
+	var json user.Invitation
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if !validEmail(json.Email) {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "email isn't a valid address"})
+		return
+	}
+
+	token, err := keySet.SignPurpose(auth.Invitation, auth.PurposeClaims{Email: json.Email}, invitationTokenLifetime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	err = mailer.Send(mail.Message{
+		To:      json.Email,
+		Subject: "You're invited to the Welsh Academy",
+		Body: "Hello,\n\n" +
+			"You have been invited to join the Welsh Academy. Register within 7 days with this address and the following invitation :\n" +
+			token + "\n",
+	})
+	if err != nil {
+		log.Printf("couldn't send an invitation : %s", err.Error())
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+// @Summary      Get roles
+// @Description  Get every role with the permissions it grants.
+// @Tags         roles
+// @Produce      json
+// @Success      200  {array}  user.RoleDefinition
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /roles [get]
+func getRolesEndpoint(c *gin.Context) {
+	roles, err := roleService.GetRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+// @Summary      Get permissions
+// @Description  Get every permission that can be granted to a role.
+// @Tags         roles
+// @Produce      json
+// @Success      200  {array}  string
+// @Failure      401
+// @Failure      403
+// @Router       /roles/permissions [get]
+func getPermissionsEndpoint(c *gin.Context) {
+	c.JSON(http.StatusOK, user.Permissions)
+}
+
+// @Summary      Create or update a role
+// @Description  Create a role or replace the permissions of an existing one, the change applies to the next request of every user having this role.
+// @Description  The admin role can't be modified.
+// @Tags         roles
+// @Accept       json
+// @Produce      json
+// @Param        name   path      string  true  "Role name"
+// @Param role body user.RoleDefinition true "permissions of the role"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /roles/{name} [put]
+func saveRoleEndpoint(c *gin.Context) {
+	var json user.RoleDefinition
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	json.Name = user.Role(c.Param("name"))
+	if len(json.Name) > 40 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "role name can't be longer than 40 characters"})
+		return
+	}
+
+	err := roleService.SaveRole(json)
+	if err != nil {
+		if errors.Is(err, user.ErrUnknownPermission) || errors.Is(err, user.ErrProtectedRole) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Delete a role
+// @Description  Delete a role that isn't given to any user anymore. The admin role can't be deleted.
+// @Tags         roles
+// @Produce      json
+// @Param        name   path      string  true  "Role name"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      409  {object}  error.ErrorResponse
+// @Failure      500
+// @Router       /roles/{name} [delete]
+func deleteRoleEndpoint(c *gin.Context) {
+	err := roleService.DeleteRole(user.Role(c.Param("name")))
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrProtectedRole):
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		case errors.Is(err, user.ErrRoleInUse):
+			c.JSON(http.StatusConflict, error.ErrorResponse{ErrorMessage: err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, nil)
+		default:
+			c.JSON(http.StatusInternalServerError, nil)
+		}
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
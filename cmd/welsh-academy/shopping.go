@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/shopping"
+	"gorm.io/gorm"
+)
+
+// @Summary      Generate a shopping list
+// @Description  Generate a shopping list from several recipes and/or the user's favorites, quantities of the same ingredient are summed.
+// @Tags         shopping
+// @Accept       json
+// @Produce      json
+// @Param request body shopping.GenerateRequest true "recipes to shop for"
+// @Success      201  {integer}  id
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /users/shopping-lists [post]
+func createShoppingListEndpoint(c *gin.Context) {
+	var json shopping.GenerateRequest
+
+	currentUser := auth.MustCurrentUser(c)
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if json.Name == "" {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create a shopping list with empty name"})
+		return
+	}
+
+	recipeIDs := json.RecipeIDs
+	if json.FromFavorites {
+		favorites, err := userService.GetFavoriteRecipe(currentUser.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, nil)
+			return
+		}
+
+		for _, favorite := range favorites {
+			recipeIDs = append(recipeIDs, favorite.ID)
+		}
+	}
+
+	if len(recipeIDs) == 0 {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create a shopping list without recipes"})
+		return
+	}
+
+	id, err := shoppingService.GenerateShoppingList(currentUser.ID, json.Name, recipeIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// @Summary      Get shopping lists
+// @Description  Get every shopping list of the user.
+// @Tags         shopping
+// @Produce      json
+// @Success      200  {array}  shopping.ShoppingList
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /users/shopping-lists [get]
+func getShoppingListsEndpoint(c *gin.Context) {
+	currentUser := auth.MustCurrentUser(c)
+
+	lists, err := shoppingService.GetShoppingLists(currentUser.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, lists)
+}
+
+// @Summary      Get a shopping list
+// @Description  Get a shopping list as JSON, or export it as plain text or Markdown.
+// @Tags         shopping
+// @Produce      json,plain,markdown
+// @Param        listId   path      int  true  "Shopping list ID"
+// @Param	format query string false "export format" Enums(json, text, markdown)
+// @Success      200  {object}  shopping.ShoppingList
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /users/shopping-lists/{listId} [get]
+func getShoppingListEndpoint(c *gin.Context) {
+	currentUser := auth.MustCurrentUser(c)
+
+	listID, err := strconv.ParseUint(c.Param("listId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	list, err := shoppingService.GetShoppingList(currentUser.ID, uint(listID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, list)
+	case "text":
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(list.Text()))
+	case "markdown":
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(list.Markdown()))
+	default:
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "format must be one of json, text or markdown"})
+	}
+}
+
+// @Summary      Check a shopping item
+// @Description  Check or uncheck an item of a shopping list.
+// @Tags         shopping
+// @Accept       json
+// @Produce      json
+// @Param        listId   path      int  true  "Shopping list ID"
+// @Param        itemId   path      int  true  "Shopping item ID"
+// @Param check body shopping.CheckRequest true "checked state"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /users/shopping-lists/{listId}/items/{itemId} [patch]
+func checkShoppingItemEndpoint(c *gin.Context) {
+	var json shopping.CheckRequest
+
+	currentUser := auth.MustCurrentUser(c)
+
+	listID, err := strconv.ParseUint(c.Param("listId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	itemID, err := strconv.ParseUint(c.Param("itemId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	err = shoppingService.SetItemChecked(currentUser.ID, uint(listID), uint(itemID), json.Checked)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Delete a shopping list
+// @Description  Delete a shopping list and its items.
+// @Tags         shopping
+// @Produce      json
+// @Param        listId   path      int  true  "Shopping list ID"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /users/shopping-lists/{listId} [delete]
+func deleteShoppingListEndpoint(c *gin.Context) {
+	currentUser := auth.MustCurrentUser(c)
+
+	listID, err := strconv.ParseUint(c.Param("listId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	err = shoppingService.DeleteShoppingList(currentUser.ID, uint(listID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
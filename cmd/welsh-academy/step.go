@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/recipe"
+	"gorm.io/gorm"
+)
+
+// @Summary      Get the steps of a recipe
+// @Description  Get the ordered list of steps needed to cook a recipe.
+// @Tags         steps
+// @Produce      json
+// @Param        id   path      int  true  "Recipe ID"
+// @Success      200  {array}   recipe.Step
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      404
+// @Failure      500
+// @Router       /recipes/{id}/steps [get]
+func getStepsEndpoint(c *gin.Context) {
+	recipeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if _, err := recipeService.GetRecipeById(uint(recipeID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	steps, err := recipeService.GetSteps(uint(recipeID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, steps)
+}
+
+// @Summary      Add a step to a recipe
+// @Description  Add a step at the end of a recipe.
+// @Tags         steps
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Recipe ID"
+// @Param step body recipe.Step true "step to add"
+// @Success      201  {integer} id
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /recipes/{id}/steps [post]
+func createStepEndpoint(c *gin.Context) {
+	var json recipe.Step
+
+	recipeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if json.Text == "" {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create a step without text"})
+		return
+	}
+
+	existingRecipe, err := recipeService.GetRecipeById(uint(recipeID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	recipeIngredients, err := recipeService.GetRecipeIngredients(existingRecipe.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err := json.ResolveIngredients(recipeIngredients); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	json.RecipeID = existingRecipe.ID
+	id, err := recipeService.AddStep(json)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id": id,
+	})
+}
+
+// @Summary      Reorder the steps of a recipe
+// @Description  Reorder the steps of a recipe, the body must contain the ID of every step of the recipe in the new order.
+// @Tags         steps
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "Recipe ID"
+// @Param order body []int true "step IDs in their new order"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /recipes/{id}/steps [put]
+func reorderStepsEndpoint(c *gin.Context) {
+	var json []uint
+
+	recipeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	err = recipeService.ReorderSteps(uint(recipeID), json)
+	if err != nil {
+		if errors.Is(err, recipe.ErrStepOrderMismatch) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Delete a step
+// @Description  Delete a step from a recipe, following steps are moved up.
+// @Tags         steps
+// @Produce      json
+// @Param        id   path      int  true  "Recipe ID"
+// @Param        stepId   path      int  true  "Step ID"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      404
+// @Failure      500
+// @Router       /recipes/{id}/steps/{stepId} [delete]
+func deleteStepEndpoint(c *gin.Context) {
+	recipeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	stepID, err := strconv.ParseUint(c.Param("stepId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	err = recipeService.DeleteStep(uint(recipeID), uint(stepID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/totp"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+const twoFactorLoginLifetime = 5 * time.Minute
+
+// twoFactorIssuer is the name authenticator applications show next to the accounts.
+const twoFactorIssuer = "Welsh Academy"
+
+// @Summary Log a user with a second factor
+// @Schemes
+// @Description Finish the login of a user having two-factor authentication enabled with the token returned by /users/login and a code
+// @Description of his authenticator application, or one of his recovery codes. Returns an access token and a refresh token, also sent as cookies.
+// @Description Wrong codes are throttled like wrong passwords.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param code body user.TwoFactorCode true "token of the login and code"
+// @Success 200 {object} auth.TokenPair
+// @Failure 400 {object} error.ErrorResponse
+// @Failure 401 {object} error.ErrorResponse
+// @Failure 429 {object} error.ErrorResponse
+// @Failure 500
+// @Router /users/login/2fa [post]
+func loginTwoFactorEndpoint(c *gin.Context) {
+	var json user.TwoFactorCode
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	claims, err := keySet.VerifyPurpose(json.Token, auth.TwoFactorLogin)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	loggedUser, err := userService.GetActiveUser(claims.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, error.ErrorResponse{ErrorMessage: user.ErrAccountNotActive.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if !allowLogin(c, loggedUser.Username) {
+		return
+	}
+
+	err = userService.VerifySecondFactor(loggedUser.ID, json.Code)
+	if err != nil {
+		if errors.Is(err, user.ErrInvalidCode) {
+			recordLoginFailure(c, loggedUser.Username)
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	forgetLoginFailures(loggedUser.Username)
+
+	pair, err := sessionService.StartSession(loggedUser, true)
+	if err != nil {
+		log.Printf("error while starting session : %s", err.Error())
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	setSessionCookies(c, pair)
+	c.JSON(http.StatusOK, pair)
+}
+
+// @Summary      Start two-factor enrolment
+// @Description  Generate a new TOTP secret for the logged user, along with the otpauth URI to show as a QR code.
+// @Description  Two-factor authentication is only enabled once a code of the secret is sent to /users/me/2fa/enable.
+// @Tags         two-factor
+// @Produce      json
+// @Success      200  {object}  user.TwoFactorEnrolment
+// @Failure      401
+// @Failure      403
+// @Failure      409  {object}  error.ErrorResponse
+// @Failure      500
+// @Router       /users/me/2fa [post]
+func startTwoFactorEndpoint(c *gin.Context) {
+	currentUser := auth.MustCurrentUser(c)
+
+	secret, err := userService.StartTwoFactorEnrolment(currentUser.ID)
+	if err != nil {
+		if errors.Is(err, user.ErrTwoFactorAlreadyEnabled) {
+			c.JSON(http.StatusConflict, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, user.TwoFactorEnrolment{
+		Secret: secret,
+		URI:    totp.ProvisioningURI(twoFactorIssuer, currentUser.Username, secret),
+	})
+}
+
+// @Summary      Enable two-factor authentication
+// @Description  Confirm the enrolment with a code of the authenticator application, and get the recovery codes which are only shown once.
+// @Description  The second factor is asked from the next login on.
+// @Tags         two-factor
+// @Accept       json
+// @Produce      json
+// @Param code body user.TwoFactorCode true "code of the authenticator application"
+// @Success      200  {object}  user.RecoveryCodes
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      409  {object}  error.ErrorResponse
+// @Failure      500
+// @Router       /users/me/2fa/enable [post]
+func enableTwoFactorEndpoint(c *gin.Context) {
+	var json user.TwoFactorCode
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	currentUser := auth.MustCurrentUser(c)
+
+	codes, err := userService.EnableTwoFactor(currentUser.ID, json.Code)
+	if err != nil {
+		if errors.Is(err, user.ErrInvalidCode) || errors.Is(err, user.ErrTwoFactorNotEnrolled) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		if errors.Is(err, user.ErrTwoFactorAlreadyEnabled) {
+			c.JSON(http.StatusConflict, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, user.RecoveryCodes{Codes: codes})
+}
+
+// @Summary      Disable two-factor authentication
+// @Description  Disable two-factor authentication of the logged user with a code of his authenticator application or a recovery code.
+// @Description  It can't be disabled when the role of the user requires it.
+// @Tags         two-factor
+// @Accept       json
+// @Param code body user.TwoFactorCode true "code of the authenticator application or recovery code"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403  {object}  error.ErrorResponse
+// @Failure      500
+// @Router       /users/me/2fa [delete]
+func disableTwoFactorEndpoint(c *gin.Context) {
+	var json user.TwoFactorCode
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	currentUser := auth.MustCurrentUser(c)
+
+	required, err := roleService.RequiresTwoFactor(currentUser.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if required {
+		c.JSON(http.StatusForbidden, error.ErrorResponse{ErrorMessage: "the role " + string(currentUser.Role) + " requires two-factor authentication"})
+		return
+	}
+
+	err = userService.DisableTwoFactor(currentUser.ID, json.Code)
+	if err != nil {
+		if errors.Is(err, user.ErrInvalidCode) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// @Summary      Get two-factor policy
+// @Description  Get the roles whose users must log in with a second factor to use their permissions.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  user.TwoFactorPolicy
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /admin/two-factor [get]
+func getTwoFactorPolicyEndpoint(c *gin.Context) {
+	policy, err := roleService.GetTwoFactorPolicy()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// @Summary      Update two-factor policy
+// @Description  Replace the roles whose users must log in with a second factor to use their permissions.
+// @Description  Users of these roles logged in without a second factor are refused the routes requiring a permission until they log in again with one.
+// @Tags         admin
+// @Accept       json
+// @Param policy body user.TwoFactorPolicy true "roles requiring two-factor authentication"
+// @Success      204
+// @Failure      400  {object}  error.ErrorResponse
+// @Failure      401
+// @Failure      403
+// @Failure      500
+// @Router       /admin/two-factor [put]
+func updateTwoFactorPolicyEndpoint(c *gin.Context) {
+	var json user.TwoFactorPolicy
+
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	err := roleService.SetTwoFactorPolicy(json)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "a role of the policy doesn't exist"})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
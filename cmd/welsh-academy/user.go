@@ -3,12 +3,13 @@ package main
 import (
 	"errors"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kataras/jwt"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
 	"github.com/mjehanno/welsh-academy/pkg/error"
 	"github.com/mjehanno/welsh-academy/pkg/recipe"
 	"github.com/mjehanno/welsh-academy/pkg/user"
@@ -17,7 +18,7 @@ import (
 
 // @Summary Create user
 // @Schemes
-// @Description Create a user with a username and a password (hashed)
+// @Description Create a user with a username and a password (hashed), users are basic users unless another role is given
 // @Tags users
 // @Accept json
 // @Produce json
@@ -31,42 +32,37 @@ import (
 func createUserEndpoint(c *gin.Context) {
 	var jsonPayload user.User
 
-	cookie, err := c.Cookie("jwt")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, nil)
-		return
-	}
-
-	verifiedToken, err := jwt.Verify(jwt.HS256, sharedKey, []byte(cookie))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, nil)
+	if err := c.ShouldBindJSON(&jsonPayload); err != nil {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
 		return
 	}
 
-	var currentUser user.User
-	err = verifiedToken.Claims(&currentUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, nil)
+	if jsonPayload.Password == "" || jsonPayload.Username == "" {
+		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create user with empty username/password"})
 		return
 	}
 
-	if currentUser.Role != user.Admin {
-		c.JSON(http.StatusForbidden, nil)
-		return
+	if jsonPayload.Role == "" {
+		jsonPayload.Role = user.BasicUser
 	}
 
-	if err := c.ShouldBindJSON(&jsonPayload); err != nil {
-		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
-		return
-	}
+	if _, err := roleService.GetRole(jsonPayload.Role); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "role " + string(jsonPayload.Role) + " doesn't exist"})
+			return
+		}
 
-	if jsonPayload.Password == "" || jsonPayload.Username == "" {
-		c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "can't create user with empty username/password"})
+		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
 	id, err := userService.CreateUser(jsonPayload)
 	if err != nil {
+		if errors.Is(err, user.ErrReservedUsername) {
+			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
@@ -78,13 +74,18 @@ func createUserEndpoint(c *gin.Context) {
 
 // @Summary Log a user
 // @Schemes
-// @Description Log a user with his username and password, returns some cookie (but not made with cheese).
+// @Description Log a user with his username and password, returns an access token and a refresh token, also sent as cookies (but not made with cheese).
+// @Description When the user has enabled two-factor authentication, a challenge is returned instead, to send back with a code to /users/login/2fa.
+// @Description Failed logins delay the next ones of the username and from the IP address, twice longer after each failure, and lock the account for a while after too many of them.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param user body user.User true "user information in order to log in"
-// @Success 200
+// @Success 200 {object} auth.TokenPair
+// @Success 202 {object} user.TwoFactorChallenge
 // @Failure 400 {object} error.ErrorResponse
+// @Failure 403 {object} error.ErrorResponse
+// @Failure 429 {object} error.ErrorResponse
 // @Failure 500
 // @Router /users/login [post]
 func loginUserEndpoint(c *gin.Context) {
@@ -95,29 +96,192 @@ func loginUserEndpoint(c *gin.Context) {
 		return
 	}
 
-	user, err := userService.LogUser(json)
+	if !allowLogin(c, json.Username) {
+		return
+	}
+
+	loggedUser, err := userService.LogUser(json)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			recordLoginFailure(c, json.Username)
 			c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "wrong data for user/password"})
 			return
 		}
 
+		if errors.Is(err, user.ErrAccountNotActive) {
+			c.JSON(http.StatusForbidden, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	if user != nil {
-		token, err := jwt.Sign(jwt.HS256, sharedKey, user, jwt.MaxAge(15*time.Minute))
-		if err != nil {
-			log.Printf("error while signing token : %s", err.Error())
+	if challengeSecondFactor(c, loggedUser.ID) {
+		return
+	}
+
+	forgetLoginFailures(loggedUser.Username)
+
+	pair, err := sessionService.StartSession(*loggedUser, false)
+	if err != nil {
+		log.Printf("error while starting session : %s", err.Error())
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	setSessionCookies(c, pair)
+	c.JSON(http.StatusOK, pair)
+}
+
+// allowLogin writes a 429 response with a Retry-After header and returns false when logins of the username or from the IP address
+// of the request have to wait after too many failures.
+func allowLogin(c *gin.Context, username string) bool {
+	wait, err := throttleService.Check(c.ClientIP(), username)
+	if err != nil && !errors.Is(err, auth.ErrTooManyAttempts) && !errors.Is(err, auth.ErrAccountLocked) {
+		c.JSON(http.StatusInternalServerError, nil)
+		return false
+	}
+
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, error.ErrorResponse{ErrorMessage: err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// recordLoginFailure records a failed login of the username from the IP address of the request, logging when it locks the account.
+func recordLoginFailure(c *gin.Context, username string) {
+	locked, err := throttleService.Fail(c.ClientIP(), username)
+	if err != nil {
+		log.Printf("couldn't record a failed login of %q : %s", username, err.Error())
+		return
+	}
+
+	if locked {
+		log.Printf("account %q locked after too many failed logins, the last one from %s", username, c.ClientIP())
+	}
+}
+
+// forgetLoginFailures forgets the failed logins of a user who logged in, failures are only logged since he is logged in anyway.
+func forgetLoginFailures(username string) {
+	if err := throttleService.Succeed(username); err != nil {
+		log.Printf("couldn't forget the failed logins of %q : %s", username, err.Error())
+	}
+}
+
+// challengeSecondFactor answers with a challenge to send back with a code, and returns true, when the user has enabled two-factor authentication.
+// It also returns true after writing a 500 response if it can't tell.
+func challengeSecondFactor(c *gin.Context, userID uint) bool {
+	twoFactor, err := userService.IsTwoFactorEnabled(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return true
+	}
+
+	if !twoFactor {
+		return false
+	}
+
+	token, err := keySet.SignPurpose(auth.TwoFactorLogin, auth.PurposeClaims{UserID: userID}, twoFactorLoginLifetime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return true
+	}
+
+	c.JSON(http.StatusAccepted, user.TwoFactorChallenge{Token: token})
+
+	return true
+}
+
+// setSessionCookies sends the tokens of a session as cookies, the refresh token one being only sent back to the users routes.
+func setSessionCookies(c *gin.Context, pair auth.TokenPair) {
+	c.SetCookie(auth.TokenCookie, pair.AccessToken, int(auth.AccessTokenLifetime.Seconds()), "/", cookieDomain, secureCookies, true)
+	c.SetCookie(auth.RefreshCookie, pair.RefreshToken, int(auth.RefreshTokenLifetime.Seconds()), "/api/v1/users", cookieDomain, secureCookies, true)
+}
+
+// clearSessionCookies asks the client to delete the session cookies.
+func clearSessionCookies(c *gin.Context) {
+	c.SetCookie(auth.TokenCookie, "", -1, "/", cookieDomain, secureCookies, true)
+	c.SetCookie(auth.RefreshCookie, "", -1, "/api/v1/users", cookieDomain, secureCookies, true)
+}
+
+// refreshTokenOf returns the refresh token sent in the cookie, or in the body for clients not using cookies.
+func refreshTokenOf(c *gin.Context) string {
+	if cookie, err := c.Cookie(auth.RefreshCookie); err == nil && cookie != "" {
+		return cookie
+	}
+
+	var json auth.TokenPair
+	if err := c.ShouldBindJSON(&json); err != nil {
+		return ""
+	}
+
+	return json.RefreshToken
+}
+
+// @Summary Refresh a session
+// @Schemes
+// @Description Exchange a refresh token, sent as a cookie or in the body, for a new access token and a new refresh token. A refresh token can only be used once, using it again revokes the whole session.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param token body auth.TokenPair false "refresh token, only needed when it isn't sent as a cookie"
+// @Success 200 {object} auth.TokenPair
+// @Failure 401 {object} error.ErrorResponse
+// @Failure 500
+// @Router /users/token/refresh [post]
+func refreshTokenEndpoint(c *gin.Context) {
+	refreshToken := refreshTokenOf(c)
+	if refreshToken == "" {
+		c.JSON(http.StatusUnauthorized, error.ErrorResponse{ErrorMessage: auth.ErrInvalidRefreshToken.Error()})
+		return
+	}
+
+	pair, err := sessionService.Refresh(refreshToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			log.Println("a refresh token has been reused, its session has been revoked")
+		}
+
+		if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+			clearSessionCookies(c)
+			c.JSON(http.StatusUnauthorized, error.ErrorResponse{ErrorMessage: err.Error()})
+			return
 		}
 
-		c.SetCookie("jwt", string(token), 36000, "/", "localhost", false, true)
-		c.JSON(http.StatusOK, nil)
+		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
 
-	c.JSON(http.StatusBadRequest, error.ErrorResponse{ErrorMessage: "wrong data for username/password"})
+	setSessionCookies(c, pair)
+	c.JSON(http.StatusOK, pair)
+}
+
+// @Summary Log out
+// @Schemes
+// @Description Revoke the access token and the session of the refresh token sent with the request, and delete their cookies.
+// @Tags users
+// @Accept json
+// @Param token body auth.TokenPair false "refresh token, only needed when it isn't sent as a cookie"
+// @Success 204
+// @Failure 500
+// @Router /users/logout [post]
+func logoutUserEndpoint(c *gin.Context) {
+	var claims *jwt.Claims
+	if currentClaims, ok := auth.CurrentClaims(c); ok {
+		claims = &currentClaims
+	}
+
+	if err := sessionService.EndSession(claims, refreshTokenOf(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	clearSessionCookies(c)
+	c.JSON(http.StatusNoContent, nil)
 }
 
 // @Summary      Flag a favorite recipe
@@ -129,6 +293,7 @@ func loginUserEndpoint(c *gin.Context) {
 // @Success      201
 // @Failure      400  {object}  error.ErrorResponse
 // @Failure      401
+// @Failure      403
 // @Failure      500
 // @Router       /users/favorites [post]
 func createFavoriteRecipeEndpoint(c *gin.Context) {
@@ -139,26 +304,9 @@ func createFavoriteRecipeEndpoint(c *gin.Context) {
 		return
 	}
 
-	cookie, err := c.Cookie("jwt")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, nil)
-		return
-	}
-
-	verifiedToken, err := jwt.Verify(jwt.HS256, sharedKey, []byte(cookie))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, nil)
-		return
-	}
-
-	var currentUser user.User
-	err = verifiedToken.Claims(&currentUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, nil)
-		return
-	}
+	currentUser := auth.MustCurrentUser(c)
 
-	err = userService.AddFavoriteRecipe(json, currentUser.ID)
+	err := userService.AddFavoriteRecipe(json, currentUser.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, nil)
 		return
@@ -174,27 +322,11 @@ func createFavoriteRecipeEndpoint(c *gin.Context) {
 // @Success      200  {array}  recipe.Recipe
 // @Failure      400  {object}  error.ErrorResponse
 // @Failure      401
+// @Failure      403
 // @Failure      500
 // @Router       /users/favorites [get]
 func getFavoriteRecipeEndpoint(c *gin.Context) {
-	cookie, err := c.Cookie("jwt")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, nil)
-		return
-	}
-
-	verifiedToken, err := jwt.Verify(jwt.HS256, sharedKey, []byte(cookie))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, nil)
-		return
-	}
-
-	var currentUser user.User
-	err = verifiedToken.Claims(&currentUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, nil)
-		return
-	}
+	currentUser := auth.MustCurrentUser(c)
 
 	recipes, err := userService.GetFavoriteRecipe(currentUser.ID)
 	if err != nil {
@@ -214,27 +346,12 @@ func getFavoriteRecipeEndpoint(c *gin.Context) {
 // @Success      204
 // @Failure      400  {object}  error.ErrorResponse
 // @Failure      401
+// @Failure      403
+// @Failure      404
 // @Failure      500
 // @Router       /users/favorites/{id} [delete]
 func deleteFavoriteRecipeEndpoint(c *gin.Context) {
-	cookie, err := c.Cookie("jwt")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, nil)
-		return
-	}
-
-	verifiedToken, err := jwt.Verify(jwt.HS256, sharedKey, []byte(cookie))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, nil)
-		return
-	}
-
-	var currentUser user.User
-	err = verifiedToken.Claims(&currentUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, nil)
-		return
-	}
+	currentUser := auth.MustCurrentUser(c)
 
 	paramId := c.Param("recipeId")
 
@@ -246,6 +363,11 @@ func deleteFavoriteRecipeEndpoint(c *gin.Context) {
 
 	recipe, err := recipeService.GetRecipeById(uint(recipeID))
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, nil)
 		return
 	}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mjehanno/welsh-academy/pkg/tokenhash"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidAPIKey is returned when an API key doesn't exist or has expired.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKeyPrefix starts every API key, so that they can be told apart from access tokens.
+const APIKeyPrefix = "wa_"
+
+// APIKey is a long lived key a user creates to authenticate scripts and applications.
+// A request authenticated with a key is only allowed what both the role of the user and the scopes of the key allow.
+// @Description APIKey is a long lived key a user creates to authenticate scripts and applications.
+type APIKey struct {
+	ID        uint `gorm:"primarykey" example:"1"`
+	CreatedAt time.Time
+	// The user owning the key
+	UserID uint `gorm:"not null;index" json:"-"`
+	// A name to remember what the key is used for
+	Name string `gorm:"size:80;not null" example:"shopping list script"`
+	// The beginning of the key, to recognize it
+	Prefix string `gorm:"size:12;not null" example:"wa_Xk2Pq9z"`
+	// The SHA-256 hash of the key, the key itself is never stored
+	Hash string `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	// The permissions the key can be used for
+	Scopes []user.Permission `gorm:"-" example:"recipe:create,recipe:update"`
+	Grants []APIKeyScope     `gorm:"foreignKey:APIKeyID" json:"-" swaggerignore:"true"`
+	// When the key stops being valid, it never expires when empty
+	ExpiresAt *time.Time
+	// When the key was last used to authenticate a request
+	LastUsedAt *time.Time
+	// Whether the key was created by a user logged in with a second factor
+	TwoFactor bool `gorm:"not null;default:false" json:"-"`
+}
+
+// APIKeyScope is a permission an API key can be used for.
+type APIKeyScope struct {
+	APIKeyID   uint            `gorm:"primaryKey"`
+	Permission user.Permission `gorm:"primaryKey;size:40"`
+}
+
+func (k *APIKey) toGrants() {
+	k.Grants = make([]APIKeyScope, len(k.Scopes))
+	for i, scope := range k.Scopes {
+		k.Grants[i] = APIKeyScope{APIKeyID: k.ID, Permission: scope}
+	}
+}
+
+func (k *APIKey) fromGrants() {
+	k.Scopes = make([]user.Permission, len(k.Grants))
+	for i, grant := range k.Grants {
+		k.Scopes[i] = grant.Permission
+	}
+}
+
+// Allows tells if the key has been given a scope.
+func (k APIKey) Allows(permission user.Permission) bool {
+	for _, scope := range k.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewAPIKeyService is the constructor for an APIKeyService.
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	return &APIKeyService{
+		db: db,
+	}
+}
+
+// APIKeyService is a service made to manage the API keys of users.
+type APIKeyService struct {
+	db *gorm.DB
+}
+
+// CreateAPIKey takes a key description, the ID of its owner and whether he logged in with a second factor, and returns the ID
+// of the created key along with the key itself, which can't be retrieved afterward. It returns user.ErrUnknownPermission if a scope doesn't exist.
+func (ks *APIKeyService) CreateAPIKey(key APIKey, userID uint, twoFactor bool) (uint, string, error) {
+	if err := (user.RoleDefinition{Permissions: key.Scopes}).Validate(); err != nil {
+		return 0, "", err
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return 0, "", err
+	}
+	secret = APIKeyPrefix + secret
+
+	key.ID = 0
+	key.UserID = userID
+	key.Prefix = secret[:10]
+	key.Hash = tokenhash.Sum(secret)
+	key.LastUsedAt = nil
+	key.TwoFactor = twoFactor
+	key.toGrants()
+
+	err = ks.db.Create(&key).Error
+
+	return key.ID, secret, err
+}
+
+// GetAPIKeys takes the ID of a user and returns his API keys.
+func (ks *APIKeyService) GetAPIKeys(userID uint) ([]APIKey, error) {
+	var keys []APIKey
+
+	err := ks.db.Preload("Grants").Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	for i := range keys {
+		keys[i].fromGrants()
+	}
+
+	return keys, err
+}
+
+// DeleteAPIKey takes the ID of a key and the ID of its owner and deletes the key, returning gorm.ErrRecordNotFound if the user has no such key.
+func (ks *APIKeyService) DeleteAPIKey(id uint, userID uint) error {
+	return ks.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&APIKey{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Where("api_key_id = ?", id).Delete(&APIKeyScope{}).Error
+	})
+}
+
+// VerifyAPIKey takes a key and returns it along with its owner, recording it has been used.
+// It returns ErrInvalidAPIKey if the key doesn't exist, has expired or if its owner isn't active anymore.
+func (ks *APIKeyService) VerifyAPIKey(secret string) (user.User, APIKey, error) {
+	var key APIKey
+	var owner user.User
+
+	if !strings.HasPrefix(secret, APIKeyPrefix) {
+		return owner, key, ErrInvalidAPIKey
+	}
+
+	err := ks.db.Preload("Grants").Where("hash = ?", tokenhash.Sum(secret)).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return owner, key, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return owner, key, err
+	}
+	key.fromGrants()
+
+	now := time.Now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return owner, key, ErrInvalidAPIKey
+	}
+
+	err = ks.db.Omit("password").Where("id = ? AND status = ?", key.UserID, user.Active).First(&owner).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return owner, key, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return owner, key, err
+	}
+
+	err = ks.db.Model(&APIKey{}).Where("id = ?", key.ID).Update("last_used_at", now).Error
+	key.LastUsedAt = &now
+
+	return owner, key, err
+}
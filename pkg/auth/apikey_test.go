@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mjehanno/welsh-academy/pkg/tokenhash"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+func TestCreateAPIKeySucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "api_keys" ("created_at","user_id","name","prefix","hash","expires_at","last_used_at","two_factor") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), 1, "shopping list script", sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, true).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "api_key_scopes" ("api_key_id","permission") VALUES ($1,$2) ON CONFLICT ("api_key_id","permission") DO UPDATE SET "api_key_id"="excluded"."api_key_id"`)).WithArgs(3, "recipe:create").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	id, secret, err := apiKeyService.CreateAPIKey(APIKey{Name: "shopping list script", Scopes: []user.Permission{user.RecipeCreate}}, 1, true)
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if id != 3 || !strings.HasPrefix(secret, APIKeyPrefix) {
+		t.Errorf("expected key 3 starting with %s, got %d %s", APIKeyPrefix, id, secret)
+	}
+}
+
+func TestCreateAPIKeyFailOnUnknownScope(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	_, _, err := apiKeyService.CreateAPIKey(APIKey{Name: "script", Scopes: []user.Permission{"cheese:eat"}}, 1, false)
+	if !errors.Is(err, user.ErrUnknownPermission) {
+		t.Errorf("expected an unknown permission error, got %v", err)
+	}
+}
+
+func TestDeleteAPIKeyFailOnOtherUserKey(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "api_keys" WHERE id = $1 AND user_id = $2`)).WithArgs(3, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err := apiKeyService.DeleteAPIKey(3, 2)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestVerifyAPIKeySucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	secret := APIKeyPrefix + "secret"
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "api_keys" WHERE hash = $1 ORDER BY "api_keys"."id" LIMIT 1`)).WithArgs(tokenhash.Sum(secret)).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "hash"}).AddRow(3, 1, "script", tokenhash.Sum(secret)))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "api_key_scopes" WHERE "api_key_scopes"."api_key_id" = $1`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"api_key_id", "permission"}).AddRow(3, "recipe:create"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE (id = $1 AND status = $2)`)).WithArgs(1, "active").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "cam-amber", "cheddarexpert"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "api_keys" SET "last_used_at"=$1 WHERE id = $2`)).WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	owner, key, err := apiKeyService.VerifyAPIKey(secret)
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if owner.Username != "cam-amber" || !key.Allows(user.RecipeCreate) || key.LastUsedAt == nil {
+		t.Errorf("expected cam-amber's key allowing recipe:create, got %+v %+v", owner, key)
+	}
+}
+
+func TestVerifyAPIKeyFailOnExpiredKey(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	secret := APIKeyPrefix + "secret"
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "api_keys" WHERE hash = $1`)).WithArgs(tokenhash.Sum(secret)).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "expires_at"}).AddRow(3, 1, time.Now().Add(-time.Hour)))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "api_key_scopes"`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"api_key_id", "permission"}))
+
+	_, _, err := apiKeyService.VerifyAPIKey(secret)
+	if !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected an invalid API key error, got %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/kataras/jwt"
+)
+
+// ErrUnsupportedAlg is returned when a configured key uses an algorithm other than EdDSA or RS256.
+var ErrUnsupportedAlg = errors.New("only EdDSA and RS256 keys are supported")
+
+// ErrNoSigningKey is returned when the signing key isn't configured or doesn't have a private key.
+var ErrNoSigningKey = errors.New("the signing key must be one of the configured keys and have a private key")
+
+// KeyConfiguration describes a key used to sign or verify tokens.
+type KeyConfiguration struct {
+	// The key ID, sent in the kid header of the tokens it signs
+	ID string `json:"id"`
+	// The algorithm of the key, EdDSA or RS256
+	Alg string `json:"alg"`
+	// The PEM encoded private key, only required for the signing key
+	Private string `json:"private"`
+	// The PEM encoded public key, derived from the private key when empty
+	Public string `json:"public"`
+}
+
+// Configuration lists the active keys and the one used to sign new tokens.
+// Other keys are only used to verify tokens, so that tokens signed with a previous key are still accepted after a rotation.
+type Configuration struct {
+	// The ID of the key used to sign new tokens
+	SigningKey string `json:"signing_key"`
+	// Every active key
+	Keys []KeyConfiguration `json:"keys"`
+}
+
+// KeySet holds the keys used to sign and verify tokens.
+type KeySet struct {
+	keys         jwt.Keys
+	signingKeyID string
+}
+
+func parseKey(config KeyConfiguration) (*jwt.Key, error) {
+	key := &jwt.Key{ID: config.ID}
+	var err error
+
+	switch config.Alg {
+	case jwt.EdDSA.Name():
+		key.Alg = jwt.EdDSA
+		if config.Private != "" {
+			var private ed25519.PrivateKey
+			private, err = jwt.ParsePrivateKeyEdDSA([]byte(config.Private))
+			if err == nil {
+				key.Private, key.Public = private, private.Public()
+			}
+		}
+		if err == nil && config.Public != "" {
+			key.Public, err = jwt.ParsePublicKeyEdDSA([]byte(config.Public))
+		}
+	case jwt.RS256.Name():
+		key.Alg = jwt.RS256
+		if config.Private != "" {
+			var private *rsa.PrivateKey
+			private, err = jwt.ParsePrivateKeyRSA([]byte(config.Private))
+			if err == nil {
+				key.Private, key.Public = private, &private.PublicKey
+			}
+		}
+		if err == nil && config.Public != "" {
+			key.Public, err = jwt.ParsePublicKeyRSA([]byte(config.Public))
+		}
+	default:
+		return nil, fmt.Errorf("key %s : %w", config.ID, ErrUnsupportedAlg)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("key %s : %w", config.ID, err)
+	}
+
+	if key.Public == nil {
+		return nil, fmt.Errorf("key %s : %w", config.ID, jwt.ErrInvalidKey)
+	}
+
+	return key, nil
+}
+
+// NewKeySet is the KeySet constructor, it parses the configured keys.
+func NewKeySet(config Configuration) (*KeySet, error) {
+	keys := make(jwt.Keys, len(config.Keys))
+	for _, keyConfig := range config.Keys {
+		key, err := parseKey(keyConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[key.ID] = key
+	}
+
+	if signingKey, ok := keys[config.SigningKey]; !ok || signingKey.Private == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	return &KeySet{keys: keys, signingKeyID: config.SigningKey}, nil
+}
+
+// LoadKeySet takes the path of a JSON file containing a Configuration and returns the corresponding KeySet.
+func LoadKeySet(path string) (*KeySet, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Configuration
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+
+	return NewKeySet(config)
+}
+
+// GenerateKeySet returns a KeySet made of a new EdDSA key, tokens it signs won't be valid anymore once the application restarts.
+func GenerateKeySet() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := jwt.Keys{}
+	keys.Register(jwt.EdDSA, "generated", public, private)
+
+	return &KeySet{keys: keys, signingKeyID: "generated"}, nil
+}
+
+// Sign takes some claims and returns a token signed with the signing key, its ID being set in the kid header.
+func (ks *KeySet) Sign(claims interface{}, opts ...jwt.SignOption) ([]byte, error) {
+	return ks.keys.SignToken(ks.signingKeyID, claims, opts...)
+}
+
+// Verify takes a token and verifies it was signed by one of the active keys and that it hasn't expired.
+func (ks *KeySet) Verify(token []byte, validators ...jwt.TokenValidator) (*jwt.VerifiedToken, error) {
+	return jwt.VerifyWithHeaderValidator(nil, nil, token, ks.keys.ValidateHeader, validators...)
+}
+
+// JSONWebKey is the public part of a key, as defined by RFC 7517.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JSONWebKeySet is the list of public keys other services can use to verify tokens.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// JWKS returns the public part of every active key.
+func (ks *KeySet) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	for id, key := range ks.keys {
+		jwk := JSONWebKey{Use: "sig", Kid: id, Alg: key.Alg.Name()}
+
+		switch public := key.Public.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv, jwk.X = "OKP", "Ed25519", encode(public)
+		case *rsa.PublicKey:
+			jwk.Kty, jwk.N, jwk.E = "RSA", encode(public.N.Bytes()), encode(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
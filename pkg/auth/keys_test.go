@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kataras/jwt"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+)
+
+type testClaims struct {
+	Username string
+}
+
+func ed25519PEM(t *testing.T) string {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("couldn't generate key : %s", err.Error())
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("couldn't marshal key : %s", err.Error())
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func rsaPEM(t *testing.T) string {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("couldn't generate key : %s", err.Error())
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}))
+}
+
+func TestSignAndVerifySucceed(t *testing.T) {
+	for _, config := range []KeyConfiguration{
+		{ID: "ed", Alg: "EdDSA", Private: ed25519PEM(t)},
+		{ID: "rsa", Alg: "RS256", Private: rsaPEM(t)},
+	} {
+		keySet, err := NewKeySet(Configuration{SigningKey: config.ID, Keys: []KeyConfiguration{config}})
+		if err != nil {
+			t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+		}
+
+		token, err := keySet.Sign(testClaims{Username: "cam-amber"}, jwt.MaxAge(time.Minute))
+		if err != nil {
+			t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+		}
+
+		verifiedToken, err := keySet.Verify(token)
+		if err != nil {
+			t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+		}
+
+		var claims testClaims
+		if err := verifiedToken.Claims(&claims); err != nil || claims.Username != "cam-amber" {
+			t.Errorf("expected cam-amber claims, got %v %v", claims, err)
+		}
+	}
+}
+
+func TestVerifySucceedAfterRotation(t *testing.T) {
+	previous := KeyConfiguration{ID: "2022-10", Alg: "EdDSA", Private: ed25519PEM(t)}
+	current := KeyConfiguration{ID: "2022-11", Alg: "EdDSA", Private: ed25519PEM(t)}
+
+	oldKeySet, _ := NewKeySet(Configuration{SigningKey: previous.ID, Keys: []KeyConfiguration{previous}})
+	token, _ := oldKeySet.Sign(testClaims{Username: "cam-amber"}, jwt.MaxAge(time.Minute))
+
+	previous.Private = ""
+	previous.Public = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER(t, oldKeySet, "2022-10")}))
+	keySet, err := NewKeySet(Configuration{SigningKey: current.ID, Keys: []KeyConfiguration{previous, current}})
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if _, err := keySet.Verify(token); err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	newToken, _ := keySet.Sign(testClaims{Username: "cam-amber"}, jwt.MaxAge(time.Minute))
+	if _, err := oldKeySet.Verify(newToken); !errors.Is(err, jwt.ErrUnknownKid) {
+		t.Errorf("expected an unknown kid error, got %v", err)
+	}
+}
+
+func publicDER(t *testing.T, keySet *KeySet, kid string) []byte {
+	der, err := x509.MarshalPKIXPublicKey(keySet.keys[kid].Public)
+	if err != nil {
+		t.Fatalf("couldn't marshal key : %s", err.Error())
+	}
+
+	return der
+}
+
+func TestVerifyFailOnExpiredToken(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	token, _ := keySet.Sign(jwt.Map{"Username": "cam-amber", "exp": time.Now().Add(-time.Minute).Unix()})
+
+	if _, err := keySet.Verify(token); !errors.Is(err, jwt.ErrExpired) {
+		t.Errorf("expected an expired error, got %v", err)
+	}
+}
+
+func TestVerifyFailOnForgedToken(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	otherKeySet, _ := GenerateKeySet()
+	token, err := otherKeySet.Sign(testClaims{Username: "cam-amber"}, jwt.MaxAge(time.Minute))
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if _, err := keySet.Verify(token); !errors.Is(err, jwt.ErrTokenSignature) {
+		t.Errorf("expected an invalid signature error, got %v", err)
+	}
+
+	hmacToken, _ := jwt.Sign(jwt.HS256, []byte("asupersecrettokenthatnooneshouldknow"), testClaims{Username: "cam-amber"}, jwt.MaxAge(time.Minute))
+	if _, err := keySet.Verify(hmacToken); !errors.Is(err, jwt.ErrEmptyKid) {
+		t.Errorf("expected an empty kid error, got %v", err)
+	}
+}
+
+func TestNewKeySetFail(t *testing.T) {
+	if _, err := NewKeySet(Configuration{SigningKey: "hs", Keys: []KeyConfiguration{{ID: "hs", Alg: "HS256", Private: "secret"}}}); !errors.Is(err, ErrUnsupportedAlg) {
+		t.Errorf("expected an unsupported alg error, got %v", err)
+	}
+
+	if _, err := NewKeySet(Configuration{SigningKey: "unknown", Keys: []KeyConfiguration{{ID: "ed", Alg: "EdDSA", Private: ed25519PEM(t)}}}); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("expected a no signing key error, got %v", err)
+	}
+
+	if _, err := NewKeySet(Configuration{SigningKey: "ed", Keys: []KeyConfiguration{{ID: "ed", Alg: "EdDSA", Private: "not a key"}}}); err == nil {
+		t.Error("error did not occured while it should have")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	keySet, err := NewKeySet(Configuration{SigningKey: "ed", Keys: []KeyConfiguration{
+		{ID: "ed", Alg: "EdDSA", Private: ed25519PEM(t)},
+		{ID: "rsa", Alg: "RS256", Private: rsaPEM(t)},
+	}})
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	jwks := keySet.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("expected 2 keys, got %v", jwks.Keys)
+	}
+
+	if jwks.Keys[0].Kid != "ed" || jwks.Keys[0].Kty != "OKP" || jwks.Keys[0].Crv != "Ed25519" || jwks.Keys[0].X == "" {
+		t.Errorf("unexpected EdDSA key %v", jwks.Keys[0])
+	}
+
+	if jwks.Keys[1].Kid != "rsa" || jwks.Keys[1].Kty != "RSA" || jwks.Keys[1].E != "AQAB" || jwks.Keys[1].N == "" {
+		t.Errorf("unexpected RSA key %v", jwks.Keys[1])
+	}
+}
+
+func TestVerifyPurposeSucceed(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	token, _ := keySet.SignPurpose(EmailVerification, PurposeClaims{UserID: 4, Email: "cam-amber@welsh.academy"}, time.Hour)
+
+	claims, err := keySet.VerifyPurpose(token, EmailVerification)
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if claims.UserID != 4 || claims.Email != "cam-amber@welsh.academy" {
+		t.Errorf("expected the claims of user 4, got %+v", claims)
+	}
+}
+
+func TestVerifyPurposeFailOnOtherPurpose(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	invitation, _ := keySet.SignPurpose(Invitation, PurposeClaims{Email: "cam-amber@welsh.academy"}, time.Hour)
+	accessToken, _ := keySet.Sign(user.User{Username: "cam-amber"}, jwt.MaxAge(time.Hour))
+
+	for _, token := range []string{invitation, string(accessToken)} {
+		if _, err := keySet.VerifyPurpose(token, EmailVerification); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected an invalid token error, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kataras/jwt"
+	apierror "github.com/mjehanno/welsh-academy/pkg/error"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+)
+
+// TokenCookie is the name of the cookie the token is sent in.
+const TokenCookie = "jwt"
+
+// RefreshCookie is the name of the cookie the refresh token is sent in.
+const RefreshCookie = "refresh_token"
+
+// principalKey is the key the authenticated user is stored under in the gin context.
+const principalKey = "principal"
+
+// claimsKey is the key the standard claims of the token are stored under in the gin context.
+const claimsKey = "claims"
+
+// twoFactorKey is the key telling if the user logged in with a second factor is stored under in the gin context.
+const twoFactorKey = "twoFactor"
+
+// ErrTwoFactorRequired is returned to users whose role requires a second factor when they logged in without it.
+var ErrTwoFactorRequired = errors.New("your role requires to log in with two-factor authentication")
+
+// ErrRoleNotGrantable is returned to users giving a role with permissions they aren't allowed themselves.
+var ErrRoleNotGrantable = errors.New("giving this role requires the role:manage permission or every permission of the role")
+
+// apiKeyKey is the key the API key authenticating the request is stored under in the gin context.
+const apiKeyKey = "apiKey"
+
+// RevocationChecker tells if the access token with the given ID has been revoked.
+type RevocationChecker interface {
+	IsRevoked(jti string) (bool, error)
+}
+
+// APIKeyVerifier returns the API key matching a secret along with its owner, or ErrInvalidAPIKey if there is none.
+type APIKeyVerifier interface {
+	VerifyAPIKey(secret string) (user.User, APIKey, error)
+}
+
+// requestToken returns the token sent in the Authorization header, or in the cookie for browsers.
+func requestToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(header[len("Bearer "):])
+	}
+
+	cookie, err := c.Cookie(TokenCookie)
+	if err != nil {
+		return ""
+	}
+
+	return cookie
+}
+
+// Authenticate returns a middleware verifying the access token or API key sent with the request and putting the authenticated user in the context.
+// Requests without a valid token, or with a revoked one, go through anonymously, it's up to the routes to require an authenticated user.
+func Authenticate(keySet *KeySet, revocations RevocationChecker, apiKeys APIKeyVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := requestToken(c)
+		if token == "" {
+			c.Next()
+			return
+		}
+
+		if strings.HasPrefix(token, APIKeyPrefix) {
+			owner, key, err := apiKeys.VerifyAPIKey(token)
+			if err != nil && !errors.Is(err, ErrInvalidAPIKey) {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, nil)
+				return
+			}
+
+			if err == nil {
+				c.Set(principalKey, owner)
+				c.Set(apiKeyKey, key)
+				c.Set(twoFactorKey, key.TwoFactor)
+			}
+
+			c.Next()
+			return
+		}
+
+		verifiedToken, err := keySet.Verify([]byte(token))
+		// tokens made for another purpose have an audience and can't authenticate requests
+		if err != nil || len(verifiedToken.StandardClaims.Audience) > 0 {
+			c.Next()
+			return
+		}
+
+		if jti := verifiedToken.StandardClaims.ID; jti != "" {
+			revoked, err := revocations.IsRevoked(jti)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, nil)
+				return
+			}
+
+			if revoked {
+				c.Next()
+				return
+			}
+		}
+
+		var claims accessClaims
+		if err := verifiedToken.Claims(&claims); err != nil {
+			c.Next()
+			return
+		}
+
+		c.Set(principalKey, claims.principal())
+		c.Set(claimsKey, verifiedToken.StandardClaims)
+		c.Set(twoFactorKey, claims.TwoFactor)
+		c.Next()
+	}
+}
+
+// PermissionChecker tells if a role grants a permission, and if users having the role must log in with a second factor to use it.
+type PermissionChecker interface {
+	HasPermission(role user.Role, permission user.Permission) (bool, error)
+	RequiresTwoFactor(role user.Role) (bool, error)
+}
+
+// RequireAuthentication returns a middleware rejecting anonymous requests with a 401.
+func RequireAuthentication() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := CurrentUser(c); !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, nil)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequirePermissions returns a middleware rejecting anonymous requests with a 401 and requests of users whose role
+// doesn't grant every given permission with a 403, as well as requests authenticated by an API key missing one of the permissions in its scopes
+// and requests of users who logged in without a second factor while their role requires it.
+func RequirePermissions(checker PermissionChecker, permissions ...user.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := CurrentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, nil)
+			return
+		}
+
+		if !checkPermissions(c, checker, principal, permissions) {
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// OwnerFunc returns the id of the user owning the resource a request is about.
+// It writes the response and returns false when the resource can't be found.
+type OwnerFunc func(c *gin.Context) (uint, bool)
+
+// RequireOwnerOrPermissions returns a middleware working as RequirePermissions, except that the owner of the resource
+// given by owner only needs ownerPermission while other users need every given permission.
+func RequireOwnerOrPermissions(checker PermissionChecker, owner OwnerFunc, ownerPermission user.Permission, permissions ...user.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := CurrentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, nil)
+			return
+		}
+
+		ownerID, ok := owner(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		required := permissions
+		if ownerID == principal.ID {
+			required = []user.Permission{ownerPermission}
+		}
+
+		if !checkPermissions(c, checker, principal, required) {
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// checkPermissions aborts the request and returns false if the principal or the API key of the request misses one of the permissions,
+// or if the principal logged in without a second factor while his role requires it.
+func checkPermissions(c *gin.Context, checker PermissionChecker, principal user.User, permissions []user.Permission) bool {
+	if len(permissions) > 0 && !TwoFactorVerified(c) {
+		required, err := checker.RequiresTwoFactor(principal.Role)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, nil)
+			return false
+		}
+
+		if required {
+			c.AbortWithStatusJSON(http.StatusForbidden, apierror.ErrorResponse{ErrorMessage: ErrTwoFactorRequired.Error()})
+			return false
+		}
+	}
+
+	for _, permission := range permissions {
+		granted, err := allows(c, checker, principal, permission)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, nil)
+			return false
+		}
+
+		if !granted {
+			c.AbortWithStatusJSON(http.StatusForbidden, nil)
+			return false
+		}
+	}
+
+	return true
+}
+
+// allows tells if both the role of the principal and the API key of the request, if any, grant a permission.
+func allows(c *gin.Context, checker PermissionChecker, principal user.User, permission user.Permission) (bool, error) {
+	granted, err := checker.HasPermission(principal.Role, permission)
+	if err != nil || !granted {
+		return false, err
+	}
+
+	if key, ok := CurrentAPIKey(c); ok && !key.Allows(permission) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// CanGrant tells if the user of the request may give a role to another user : he needs the role:manage permission,
+// or to be allowed every permission of the role himself, so that managing users doesn't give a way to get more permissions.
+func CanGrant(c *gin.Context, checker PermissionChecker, role user.RoleDefinition) (bool, error) {
+	principal, ok := CurrentUser(c)
+	if !ok {
+		return false, nil
+	}
+
+	if granted, err := allows(c, checker, principal, user.RoleManage); err != nil || granted {
+		return granted, err
+	}
+
+	for _, permission := range role.Permissions {
+		if granted, err := allows(c, checker, principal, permission); err != nil || !granted {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// CurrentUser returns the authenticated user of the request, the boolean is false if the request is anonymous.
+func CurrentUser(c *gin.Context) (user.User, bool) {
+	principal, ok := c.Get(principalKey)
+	if !ok {
+		return user.User{}, false
+	}
+
+	return principal.(user.User), true
+}
+
+// CurrentClaims returns the standard claims of the token authenticating the request, the boolean is false if the request is anonymous.
+func CurrentClaims(c *gin.Context) (jwt.Claims, bool) {
+	claims, ok := c.Get(claimsKey)
+	if !ok {
+		return jwt.Claims{}, false
+	}
+
+	return claims.(jwt.Claims), true
+}
+
+// CurrentAPIKey returns the API key authenticating the request, the boolean is false if the request isn't authenticated by an API key.
+func CurrentAPIKey(c *gin.Context) (APIKey, bool) {
+	key, ok := c.Get(apiKeyKey)
+	if !ok {
+		return APIKey{}, false
+	}
+
+	return key.(APIKey), true
+}
+
+// TwoFactorVerified tells if the user of the request logged in with a second factor.
+func TwoFactorVerified(c *gin.Context) bool {
+	return c.GetBool(twoFactorKey)
+}
+
+// MustCurrentUser returns the authenticated user of the request and panics if the request is anonymous.
+// It should only be used by handlers of routes requiring an authenticated user.
+func MustCurrentUser(c *gin.Context) user.User {
+	return c.MustGet(principalKey).(user.User)
+}
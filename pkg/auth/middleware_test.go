@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kataras/jwt"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+// fakeChecker grants the permissions listed for each role.
+type fakeChecker map[user.Role][]user.Permission
+
+func (fc fakeChecker) HasPermission(role user.Role, permission user.Permission) (bool, error) {
+	for _, granted := range fc[role] {
+		if granted == permission {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RequiresTwoFactor makes administrators use a second factor.
+func (fc fakeChecker) RequiresTwoFactor(role user.Role) (bool, error) {
+	return role == user.Admin, nil
+}
+
+var checker = fakeChecker{"contributor": {user.RecipeCreate}, "moderator": {user.RecipeCreate, user.RecipeUpdate, user.UserManage}, user.CheddarExpert: {user.RecipeCreate, user.RecipeUpdate}, user.Admin: user.Permissions}
+
+// fakeRevocations holds the IDs of the revoked tokens.
+type fakeRevocations map[string]bool
+
+func (fr fakeRevocations) IsRevoked(jti string) (bool, error) {
+	return fr[jti], nil
+}
+
+var revocations = fakeRevocations{"revoked-jti": true}
+
+// fakeAPIKeys maps API keys to their owner and description.
+type fakeAPIKeys map[string]APIKey
+
+func (fk fakeAPIKeys) VerifyAPIKey(secret string) (user.User, APIKey, error) {
+	key, ok := fk[secret]
+	if !ok {
+		return user.User{}, APIKey{}, ErrInvalidAPIKey
+	}
+
+	return user.User{Model: gorm.Model{ID: key.UserID}, Username: "cam-amber", Role: user.CheddarExpert}, key, nil
+}
+
+var apiKeys = fakeAPIKeys{
+	"wa_creator": {UserID: 1, Scopes: []user.Permission{user.RecipeCreate, user.RecipeUpdate}},
+	"wa_reader":  {UserID: 1, Scopes: []user.Permission{}},
+}
+
+func setupRouter(keySet *KeySet, permissions ...user.Permission) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/protected", Authenticate(keySet, revocations, apiKeys), RequirePermissions(checker, permissions...), func(c *gin.Context) {
+		c.JSON(http.StatusOK, MustCurrentUser(c).Username)
+	})
+
+	return r
+}
+
+func request(t *testing.T, r *gin.Engine, token []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	if token != nil {
+		req.AddCookie(&http.Cookie{Name: TokenCookie, Value: string(token)})
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+func bearerRequest(t *testing.T, r *gin.Engine, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestRequirePermissionsSucceed(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	token, _ := keySet.Sign(user.User{Model: gorm.Model{ID: 1}, Username: "cam-amber", Role: user.CheddarExpert}, jwt.MaxAge(time.Minute))
+
+	w := request(t, setupRouter(keySet, user.RecipeCreate, user.RecipeUpdate), token)
+	if w.Code != http.StatusOK || w.Body.String() != `"cam-amber"` {
+		t.Errorf("expected a 200 for cam-amber, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRequirePermissionsFailOnAnonymousRequest(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+
+	if w := request(t, setupRouter(keySet), nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a 401, got %d", w.Code)
+	}
+}
+
+func TestRequirePermissionsFailOnInvalidToken(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	otherKeySet, _ := GenerateKeySet()
+
+	expired, _ := keySet.Sign(jwt.Map{"Username": "cam-amber", "exp": time.Now().Add(-time.Minute).Unix()})
+	forged, _ := otherKeySet.Sign(user.User{Username: "cam-amber", Role: user.Admin}, jwt.MaxAge(time.Minute))
+
+	for _, token := range [][]byte{expired, forged, []byte("not a token")} {
+		if w := request(t, setupRouter(keySet), token); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected a 401, got %d", w.Code)
+		}
+	}
+}
+
+func TestRequirePermissionsFailOnMissingPermission(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	for _, role := range []user.Role{user.BasicUser, user.CheddarExpert} {
+		token, _ := keySet.Sign(user.User{Username: "cam-amber", Role: role}, jwt.MaxAge(time.Minute))
+
+		if w := request(t, setupRouter(keySet, user.RecipeCreate, user.UserManage), token); w.Code != http.StatusForbidden {
+			t.Errorf("expected a 403 for %s, got %d", role, w.Code)
+		}
+	}
+}
+
+func TestAuthenticateFailOnRevokedToken(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	cheddarExpert := user.User{Username: "cam-amber", Role: user.CheddarExpert}
+
+	revoked, _ := keySet.Sign(cheddarExpert, jwt.MaxAge(time.Minute), jwt.Claims{ID: "revoked-jti"})
+	if w := request(t, setupRouter(keySet), revoked); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a 401 for a revoked token, got %d", w.Code)
+	}
+
+	valid, _ := keySet.Sign(cheddarExpert, jwt.MaxAge(time.Minute), jwt.Claims{ID: "valid-jti"})
+	if w := request(t, setupRouter(keySet), valid); w.Code != http.StatusOK {
+		t.Errorf("expected a 200 for a token that isn't revoked, got %d", w.Code)
+	}
+}
+
+func TestAuthenticateSucceedWithBearerToken(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	token, _ := keySet.Sign(user.User{Username: "cam-amber", Role: user.CheddarExpert}, jwt.MaxAge(time.Minute))
+
+	if w := bearerRequest(t, setupRouter(keySet, user.RecipeCreate), string(token)); w.Code != http.StatusOK {
+		t.Errorf("expected a 200 for a bearer token, got %d", w.Code)
+	}
+}
+
+func TestAuthenticateSucceedWithAPIKey(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+
+	if w := bearerRequest(t, setupRouter(keySet, user.RecipeCreate), "wa_creator"); w.Code != http.StatusOK {
+		t.Errorf("expected a 200 for an API key having the recipe:create scope, got %d", w.Code)
+	}
+
+	if w := bearerRequest(t, setupRouter(keySet), "wa_reader"); w.Code != http.StatusOK {
+		t.Errorf("expected a 200 for an API key on a route requiring no permission, got %d", w.Code)
+	}
+}
+
+func TestAuthenticateFailOnAPIKeyScope(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+
+	if w := bearerRequest(t, setupRouter(keySet, user.RecipeCreate), "wa_reader"); w.Code != http.StatusForbidden {
+		t.Errorf("expected a 403 for an API key without the recipe:create scope, got %d", w.Code)
+	}
+
+	if w := bearerRequest(t, setupRouter(keySet, user.RecipeCreate), "wa_unknown"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a 401 for an unknown API key, got %d", w.Code)
+	}
+}
+
+func TestAuthenticateFailOnPurposeToken(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	token, _ := keySet.SignPurpose(EmailVerification, PurposeClaims{UserID: 1}, time.Minute)
+
+	if w := bearerRequest(t, setupRouter(keySet), token); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a 401 for an email verification token, got %d", w.Code)
+	}
+}
+
+func TestRequirePermissionsFailWithoutSecondFactor(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	admin := user.User{Username: "cam-amber", Role: user.Admin}
+
+	token, _ := keySet.Sign(newAccessClaims(admin, false), jwt.MaxAge(time.Minute))
+	if w := request(t, setupRouter(keySet, user.UserManage), token); w.Code != http.StatusForbidden {
+		t.Errorf("expected a 403 for an administrator without a second factor, got %d", w.Code)
+	}
+
+	token, _ = keySet.Sign(newAccessClaims(admin, true), jwt.MaxAge(time.Minute))
+	if w := request(t, setupRouter(keySet, user.UserManage), token); w.Code != http.StatusOK {
+		t.Errorf("expected a 200 for an administrator with a second factor, got %d", w.Code)
+	}
+
+	if w := request(t, setupRouter(keySet), token); w.Code != http.StatusOK {
+		t.Errorf("expected a 200 on a route requiring no permission, got %d", w.Code)
+	}
+}
+
+func setupOwnerRouter(keySet *KeySet, ownerID uint) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	owner := func(c *gin.Context) (uint, bool) {
+		if ownerID == 0 {
+			c.JSON(http.StatusNotFound, nil)
+			return 0, false
+		}
+
+		return ownerID, true
+	}
+	r.GET("/protected", Authenticate(keySet, revocations, apiKeys), RequireOwnerOrPermissions(checker, owner, user.RecipeCreate, user.RecipeUpdate), func(c *gin.Context) {
+		c.JSON(http.StatusOK, MustCurrentUser(c).Username)
+	})
+
+	return r
+}
+
+func TestRequireOwnerOrPermissionsSucceed(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	owner, _ := keySet.Sign(user.User{Model: gorm.Model{ID: 1}, Username: "cam-amber", Role: "contributor"}, jwt.MaxAge(time.Minute))
+	expert, _ := keySet.Sign(user.User{Model: gorm.Model{ID: 2}, Username: "gouda-lover", Role: user.CheddarExpert}, jwt.MaxAge(time.Minute))
+
+	for _, token := range [][]byte{owner, expert} {
+		if w := request(t, setupOwnerRouter(keySet, 1), token); w.Code != http.StatusOK {
+			t.Errorf("expected a 200, got %d", w.Code)
+		}
+	}
+}
+
+func TestRequireOwnerOrPermissionsFailOnOtherUser(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	token, _ := keySet.Sign(user.User{Model: gorm.Model{ID: 3}, Username: "brie-fan", Role: "contributor"}, jwt.MaxAge(time.Minute))
+
+	if w := request(t, setupOwnerRouter(keySet, 1), token); w.Code != http.StatusForbidden {
+		t.Errorf("expected a 403, got %d", w.Code)
+	}
+}
+
+func TestRequireOwnerOrPermissionsFailOnUnknownResource(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	token, _ := keySet.Sign(user.User{Model: gorm.Model{ID: 1}, Username: "cam-amber", Role: "contributor"}, jwt.MaxAge(time.Minute))
+
+	if w := request(t, setupOwnerRouter(keySet, 0), token); w.Code != http.StatusNotFound {
+		t.Errorf("expected a 404, got %d", w.Code)
+	}
+}
+
+func setupGrantRouter(keySet *KeySet, role user.RoleDefinition) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/protected", Authenticate(keySet, revocations, apiKeys), func(c *gin.Context) {
+		granted, err := CanGrant(c, checker, role)
+		if err != nil || !granted {
+			c.JSON(http.StatusForbidden, nil)
+			return
+		}
+
+		c.JSON(http.StatusOK, MustCurrentUser(c).Username)
+	})
+
+	return r
+}
+
+func TestCanGrant(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	moderator, _ := keySet.Sign(user.User{Username: "cam-amber", Role: "moderator"}, jwt.MaxAge(time.Minute))
+	admin, _ := keySet.Sign(newAccessClaims(user.User{Username: "brie-fan", Role: user.Admin}, true), jwt.MaxAge(time.Minute))
+
+	cases := []struct {
+		token    []byte
+		role     user.RoleDefinition
+		expected int
+	}{
+		{token: moderator, role: user.RoleDefinition{Name: user.BasicUser, Permissions: []user.Permission{}}, expected: http.StatusOK},
+		{token: moderator, role: user.RoleDefinition{Name: "contributor", Permissions: []user.Permission{user.RecipeCreate}}, expected: http.StatusOK},
+		{token: moderator, role: user.RoleDefinition{Name: user.CheddarExpert, Permissions: []user.Permission{user.RecipeCreate, user.IngredientCreate}}, expected: http.StatusForbidden},
+		{token: moderator, role: user.RoleDefinition{Name: user.Admin, Permissions: user.Permissions}, expected: http.StatusForbidden},
+		{token: admin, role: user.RoleDefinition{Name: user.Admin, Permissions: user.Permissions}, expected: http.StatusOK},
+	}
+
+	for _, c := range cases {
+		if w := request(t, setupGrantRouter(keySet, c.role), c.token); w.Code != c.expected {
+			t.Errorf("expected a %d when giving the %s role, got %d", c.expected, c.role.Name, w.Code)
+		}
+	}
+}
+
+func TestCanGrantFailOnAPIKeyScope(t *testing.T) {
+	keySet, _ := GenerateKeySet()
+	contributor := user.RoleDefinition{Name: "contributor", Permissions: []user.Permission{user.RecipeCreate}}
+
+	if w := bearerRequest(t, setupGrantRouter(keySet, contributor), "wa_creator"); w.Code != http.StatusOK {
+		t.Errorf("expected a 200 for an API key having the recipe:create scope, got %d", w.Code)
+	}
+
+	if w := bearerRequest(t, setupGrantRouter(keySet, contributor), "wa_reader"); w.Code != http.StatusForbidden {
+		t.Errorf("expected a 403 for an API key without the recipe:create scope, got %d", w.Code)
+	}
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+// sessionExport is a session token issued to a user, the token itself being left out.
+type sessionExport struct {
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	TwoFactor bool
+}
+
+// loginFailureExport is the recent failed logins of a username.
+type loginFailureExport struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// PersonalData returns the data linked to users stored by this package : their sessions, API keys and failed logins.
+func PersonalData() []user.PersonalData {
+	return []user.PersonalData{
+		{
+			Name: "sessions",
+			Export: func(tx *gorm.DB, u user.User) (interface{}, error) {
+				sessions := []sessionExport{}
+				err := tx.Model(&RefreshToken{}).Where("user_id = ?", u.ID).Order("id").Find(&sessions).Error
+
+				return sessions, err
+			},
+			Erase: func(tx *gorm.DB, u user.User) error {
+				// the access tokens are revoked first since they stay valid without their refresh token
+				if err := RevokeUserSessionsTx(tx, u.ID); err != nil {
+					return err
+				}
+
+				return tx.Where("user_id = ?", u.ID).Delete(&RefreshToken{}).Error
+			},
+		},
+		{
+			Name: "api-keys",
+			Export: func(tx *gorm.DB, u user.User) (interface{}, error) {
+				keys := []APIKey{}
+				if err := tx.Preload("Grants").Where("user_id = ?", u.ID).Order("id").Find(&keys).Error; err != nil {
+					return nil, err
+				}
+
+				for i := range keys {
+					keys[i].fromGrants()
+				}
+
+				return keys, nil
+			},
+			Erase: func(tx *gorm.DB, u user.User) error {
+				keys := tx.Model(&APIKey{}).Select("id").Where("user_id = ?", u.ID)
+				if err := tx.Where("api_key_id IN (?)", keys).Delete(&APIKeyScope{}).Error; err != nil {
+					return err
+				}
+
+				return tx.Where("user_id = ?", u.ID).Delete(&APIKey{}).Error
+			},
+		},
+		{
+			Name: "login-failures",
+			Export: func(tx *gorm.DB, u user.User) (interface{}, error) {
+				failures := []loginFailureExport{}
+				err := tx.Model(&LoginFailure{}).Where("key = ?", userKey(u.Username)).Find(&failures).Error
+
+				return failures, err
+			},
+			Erase: func(tx *gorm.DB, u user.User) error {
+				return tx.Where("key = ?", userKey(u.Username)).Delete(&LoginFailure{}).Error
+			},
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+)
+
+func TestPersonalDataExportSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	now := time.Now()
+	camAmber := user.User{Username: "cam-amber"}
+	camAmber.ID = 3
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "refresh_tokens"."created_at","refresh_tokens"."expires_at","refresh_tokens"."used_at","refresh_tokens"."revoked_at","refresh_tokens"."two_factor" FROM "refresh_tokens" WHERE user_id = $1 ORDER BY id`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"created_at", "expires_at", "two_factor"}).AddRow(now, now.Add(RefreshTokenLifetime), true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "api_keys" WHERE user_id = $1 ORDER BY id`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "hash"}).AddRow(1, "shopping list script", "secret hash"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "api_key_scopes" WHERE "api_key_scopes"."api_key_id" = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"api_key_id", "permission"}).AddRow(1, "recipe:create"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "login_failures"."failures","login_failures"."last_failure_at","login_failures"."locked_until" FROM "login_failures" WHERE key = $1`)).WithArgs("user:cam-amber").WillReturnRows(sqlmock.NewRows([]string{"failures"}))
+
+	var exported []interface{}
+	for _, data := range PersonalData() {
+		export, err := data.Export(sessionService.db, camAmber)
+		if err != nil {
+			t.Errorf("error occured while it shouldn't have : %s", err)
+		}
+
+		exported = append(exported, export)
+	}
+
+	if sessions := exported[0].([]sessionExport); len(sessions) != 1 || !sessions[0].TwoFactor {
+		t.Errorf("expected the session of cam-amber, got %+v", sessions)
+	}
+
+	if keys := exported[1].([]APIKey); len(keys) != 1 || len(keys[0].Scopes) != 1 || keys[0].Scopes[0] != user.RecipeCreate {
+		t.Errorf("expected the API key of cam-amber with its scope, got %+v", keys)
+	}
+}
+
+func TestPersonalDataEraseSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	camAmber := user.User{Username: "cam-amber"}
+	camAmber.ID = 3
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refresh_tokens" WHERE user_id = $1 AND created_at > $2`)).WithArgs(3, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(1, time.Now(), 3, "hash", "family", "jti", time.Now().Add(time.Hour), nil, nil, true))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "revoked_tokens" ("jti","expires_at") VALUES ($1,$2) ON CONFLICT DO NOTHING`)).WithArgs("jti", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refresh_tokens" SET "revoked_at"=$1 WHERE user_id = $2 AND revoked_at IS NULL`)).WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "refresh_tokens" WHERE user_id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "api_key_scopes" WHERE api_key_id IN (SELECT "id" FROM "api_keys" WHERE user_id = $1)`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "api_keys" WHERE user_id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "login_failures" WHERE key = $1`)).WithArgs("user:cam-amber").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	for _, data := range PersonalData() {
+		if err := data.Erase(sessionService.db, camAmber); err != nil {
+			t.Errorf("error occured while it shouldn't have : %s", err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kataras/jwt"
+)
+
+// ErrInvalidToken is returned when a purpose token isn't valid, has expired or was made for another purpose.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Purpose is what a token other than an access token can be used for, it's set as the audience of the token
+// so that it can't be used to authenticate requests.
+type Purpose string
+
+const (
+	EmailVerification Purpose = "email-verification"
+	Invitation        Purpose = "invitation"
+	TwoFactorLogin    Purpose = "two-factor-login"
+	OIDCLogin         Purpose = "oidc-login"
+)
+
+// PurposeClaims are the claims of a token made for a given purpose.
+type PurposeClaims struct {
+	// The user the token was made for
+	UserID uint `json:"uid,omitempty"`
+	// The email address the token was sent to
+	Email string `json:"email,omitempty"`
+	// The state, nonce and PKCE verifier of a login through an identity provider, kept by the browser until it comes back
+	State    string `json:"state,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
+	Verifier string `json:"cv,omitempty"`
+}
+
+// SignPurpose takes some claims and returns a token that can only be used for the given purpose until it expires.
+func (ks *KeySet) SignPurpose(purpose Purpose, claims PurposeClaims, lifetime time.Duration) (string, error) {
+	token, err := ks.Sign(claims, jwt.MaxAge(lifetime), jwt.Claims{Audience: jwt.Audience{string(purpose)}})
+
+	return string(token), err
+}
+
+// VerifyPurpose takes a token and returns its claims, or ErrInvalidToken if it isn't valid for the given purpose.
+func (ks *KeySet) VerifyPurpose(token string, purpose Purpose) (PurposeClaims, error) {
+	var claims PurposeClaims
+
+	verifiedToken, err := ks.Verify([]byte(token), jwt.Expected{Audience: jwt.Audience{string(purpose)}})
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	if err := verifiedToken.Claims(&claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	return claims, nil
+}
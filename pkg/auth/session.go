@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/kataras/jwt"
+	"github.com/mjehanno/welsh-academy/pkg/tokenhash"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidRefreshToken is returned when a refresh token doesn't exist, has expired or has been revoked.
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// ErrRefreshTokenReused is returned when a refresh token that was already exchanged is used again,
+// every token of its session is revoked since it has probably been stolen.
+var ErrRefreshTokenReused = errors.New("refresh token already used, the session has been revoked")
+
+// AccessTokenLifetime is the duration an access token is valid for.
+const AccessTokenLifetime = 15 * time.Minute
+
+// RefreshTokenLifetime is the duration a refresh token is valid for.
+const RefreshTokenLifetime = 30 * 24 * time.Hour
+
+// RefreshToken is a long lived token exchanged for a new access token, it can only be used once.
+type RefreshToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	// The user the token was issued to
+	UserID uint `gorm:"not null;index"`
+	// The SHA-256 hash of the token, the token itself is never stored
+	Hash string `gorm:"size:64;not null;uniqueIndex"`
+	// The session the token belongs to, every rotation of a token stays in the same family
+	Family string `gorm:"size:32;not null;index"`
+	// The ID of the access token issued along with this token
+	AccessTokenID string `gorm:"size:32"`
+	// Whether the user logged in with a second factor, kept by the tokens of the session
+	TwoFactor bool `gorm:"not null;default:false"`
+	ExpiresAt time.Time
+	// When the token was exchanged for a new one
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// RevokedToken is an access token that isn't valid anymore even though it hasn't expired.
+type RevokedToken struct {
+	// The jti claim of the token
+	JTI string `gorm:"primaryKey;size:32"`
+	// When the token expires, after what it doesn't need to be kept
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// TokenPair is the access token and refresh token given to a user when he logs in or refreshes his session.
+// @Description TokenPair is the access token and refresh token given to a user when he logs in or refreshes his session.
+type TokenPair struct {
+	// The JWT to authenticate requests with
+	AccessToken string `json:"access_token" example:"eyJhbGciOiJFZERTQSIsImtpZCI6ImdlbmVyYXRlZCIsInR5cCI6IkpXVCJ9..."`
+	// The token to exchange for a new pair once the access token expires
+	RefreshToken string `json:"refresh_token" example:"q0Nf3W1v7kXo2zXbq6b0hH3i8Yb5o2c1yU9l4rP0s2E"`
+	// The lifetime of the access token in seconds
+	ExpiresIn int `json:"expires_in" example:"900"`
+}
+
+// accessClaims are the claims of an access token, which only identify the user so that his personal data doesn't end up in the token.
+// The claim names are the JSON names of the user fields.
+type accessClaims struct {
+	ID       uint      `json:"ID"`
+	Username string    `json:"Username"`
+	Role     user.Role `json:"Role"`
+	// Whether the user logged in with a second factor
+	TwoFactor bool `json:"mfa,omitempty"`
+}
+
+// newAccessClaims returns the claims of an access token for a user.
+func newAccessClaims(u user.User, twoFactor bool) accessClaims {
+	return accessClaims{ID: u.ID, Username: u.Username, Role: u.Role, TwoFactor: twoFactor}
+}
+
+// principal returns the user identified by the claims.
+func (ac accessClaims) principal() user.User {
+	return user.User{Model: gorm.Model{ID: ac.ID}, Username: ac.Username, Role: ac.Role}
+}
+
+// NewSessionService is the constructor for a SessionService.
+func NewSessionService(db *gorm.DB, keySet *KeySet) *SessionService {
+	return &SessionService{
+		db:     db,
+		keySet: keySet,
+	}
+}
+
+// SessionService is a service made to issue, rotate and revoke the tokens of user sessions.
+type SessionService struct {
+	db     *gorm.DB
+	keySet *KeySet
+}
+
+// randomToken returns a random URL safe string made of size bytes.
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// issue signs an access token for the user and stores a new refresh token of the given family.
+func (ss *SessionService) issue(tx *gorm.DB, u user.User, family string, twoFactor bool) (TokenPair, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	accessToken, err := ss.keySet.Sign(newAccessClaims(u, twoFactor), jwt.MaxAge(AccessTokenLifetime), jwt.Claims{ID: jti})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	err = tx.Create(&RefreshToken{
+		UserID:        u.ID,
+		Hash:          tokenhash.Sum(refreshToken),
+		Family:        family,
+		AccessTokenID: jti,
+		TwoFactor:     twoFactor,
+		ExpiresAt:     time.Now().Add(RefreshTokenLifetime),
+	}).Error
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  string(accessToken),
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(AccessTokenLifetime.Seconds()),
+	}, nil
+}
+
+// StartSession takes a logged user, and whether he logged in with a second factor, and returns the tokens of a new session.
+func (ss *SessionService) StartSession(u user.User, twoFactor bool) (TokenPair, error) {
+	family, err := randomToken(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return ss.issue(ss.db, u, family, twoFactor)
+}
+
+// Refresh takes a refresh token and exchanges it for a new pair of tokens of the same session.
+// It returns ErrInvalidRefreshToken if the token isn't valid, or ErrRefreshTokenReused after revoking the session if it was already used.
+func (ss *SessionService) Refresh(refreshToken string) (TokenPair, error) {
+	var pair TokenPair
+	reused := false
+
+	err := ss.db.Transaction(func(tx *gorm.DB) error {
+		var token RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("hash = ?", tokenhash.Sum(refreshToken)).First(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if token.RevokedAt != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if token.UsedAt != nil {
+			// the revocation has to be committed, so the error is only returned once the transaction is over
+			reused = true
+			return revokeFamily(tx, token.Family)
+		}
+
+		if time.Now().After(token.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.Model(&RefreshToken{}).Where("id = ?", token.ID).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		var u user.User
+		err = tx.Omit("password").Where("id = ? AND status = ?", token.UserID, user.Active).First(&u).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		pair, err = ss.issue(tx, u, token.Family, token.TwoFactor)
+
+		return err
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if reused {
+		return TokenPair{}, ErrRefreshTokenReused
+	}
+
+	return pair, nil
+}
+
+// revokeFamily revokes every refresh token of a session along with the access tokens issued with them that haven't expired yet.
+func revokeFamily(tx *gorm.DB, family string) error {
+	return revokeTokens(tx, "family = ?", family)
+}
+
+// revokeTokens revokes the refresh tokens matching a condition along with the access tokens issued with them that haven't expired yet.
+func revokeTokens(tx *gorm.DB, condition string, value interface{}) error {
+	now := time.Now()
+
+	var tokens []RefreshToken
+	err := tx.Where(condition+" AND created_at > ?", value, now.Add(-AccessTokenLifetime)).Find(&tokens).Error
+	if err != nil {
+		return err
+	}
+
+	revoked := make([]RevokedToken, 0, len(tokens))
+	for _, token := range tokens {
+		if token.AccessTokenID != "" {
+			revoked = append(revoked, RevokedToken{JTI: token.AccessTokenID, ExpiresAt: token.CreatedAt.Add(AccessTokenLifetime)})
+		}
+	}
+
+	if len(revoked) > 0 {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&RefreshToken{}).Where(condition+" AND revoked_at IS NULL", value).Update("revoked_at", now).Error
+}
+
+// RevokeUserSessions revokes every session of a user.
+func (ss *SessionService) RevokeUserSessions(userID uint) error {
+	return ss.db.Transaction(func(tx *gorm.DB) error {
+		return RevokeUserSessionsTx(tx, userID)
+	})
+}
+
+// RevokeUserSessionsTx revokes every session of a user within a transaction, so that it happens along with a change of his account.
+func RevokeUserSessionsTx(tx *gorm.DB, userID uint) error {
+	return revokeTokens(tx, "user_id = ?", userID)
+}
+
+// EndSession revokes an access token given its claims and the session of the refresh token, both being optional.
+func (ss *SessionService) EndSession(claims *jwt.Claims, refreshToken string) error {
+	return ss.db.Transaction(func(tx *gorm.DB) error {
+		if claims != nil && claims.ID != "" {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&RevokedToken{JTI: claims.ID, ExpiresAt: claims.ExpiresAt()}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if refreshToken == "" {
+			return nil
+		}
+
+		var token RefreshToken
+		err := tx.Where("hash = ?", tokenhash.Sum(refreshToken)).First(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return revokeFamily(tx, token.Family)
+	})
+}
+
+// IsRevoked tells if the access token with the given ID has been revoked.
+func (ss *SessionService) IsRevoked(jti string) (bool, error) {
+	var count int64
+
+	err := ss.db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+
+	return count > 0, err
+}
+
+// PurgeExpired deletes the revoked access tokens and the refresh tokens that have expired.
+func (ss *SessionService) PurgeExpired() error {
+	now := time.Now()
+
+	if err := ss.db.Where("expires_at < ?", now).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+
+	return ss.db.Where("expires_at < ?", now).Delete(&RefreshToken{}).Error
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kataras/jwt"
+	"github.com/mjehanno/welsh-academy/pkg/tokenhash"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var mock sqlmock.Sqlmock
+var db *sql.DB
+var sessionService *SessionService
+var apiKeyService *APIKeyService
+var throttleService *ThrottleService
+
+func Setup(t *testing.T) func(t *testing.T) {
+	var err error
+
+	db, mock, err = sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp)) // mock sql.DB
+	if err != nil {
+		t.Fatalf("error shouldn't have occured while mocking db")
+	}
+
+	dialector := postgres.New(postgres.Config{
+		DSN:                  "sqlmock_db_0",
+		DriverName:           "postgres",
+		Conn:                 db,
+		PreferSimpleProtocol: true,
+	})
+
+	gdb, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("error shouldn't have occured while opening the mocked db")
+	}
+
+	keySet, _ := GenerateKeySet()
+	sessionService = NewSessionService(gdb, keySet)
+	apiKeyService = NewAPIKeyService(gdb)
+	throttleService = NewThrottleService(gdb, DefaultThrottlePolicy)
+
+	return func(t *testing.T) {
+		defer db.Close()
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	}
+}
+
+var refreshTokenColumns = []string{"id", "created_at", "user_id", "hash", "family", "access_token_id", "expires_at", "used_at", "revoked_at", "two_factor"}
+
+const selectRefreshToken = `SELECT * FROM "refresh_tokens" WHERE hash = $1 ORDER BY "refresh_tokens"."id" LIMIT 1 FOR UPDATE`
+
+func TestStartSessionSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "refresh_tokens" ("created_at","user_id","hash","family","access_token_id","two_factor","expires_at","used_at","revoked_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), true, sqlmock.AnyArg(), nil, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	pair, err := sessionService.StartSession(user.User{Model: gorm.Model{ID: 1}, Username: "cam-amber", Password: "secret", Role: user.BasicUser, Email: "cam-amber@welsh.academy"}, true)
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	verified, err := sessionService.keySet.Verify([]byte(pair.AccessToken))
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	var principal user.User
+	verified.Claims(&principal)
+	if principal.ID != 1 || principal.Username != "cam-amber" || principal.Role != user.BasicUser || verified.StandardClaims.ID == "" {
+		t.Errorf("expected a token for cam-amber with an ID, got %+v %+v", principal, verified.StandardClaims)
+	}
+
+	if principal.Password != "" || principal.Email != "" {
+		t.Errorf("expected a token without the password and email of cam-amber, got %+v", principal)
+	}
+
+	if pair.RefreshToken == "" || pair.ExpiresIn != 900 {
+		t.Errorf("expected a refresh token and an access token lasting 900 seconds, got %+v", pair)
+	}
+}
+
+func TestRefreshSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectRefreshToken)).WithArgs(tokenhash.Sum("old-token")).WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(1, time.Now(), 1, tokenhash.Sum("old-token"), "family", "old-jti", time.Now().Add(time.Hour), nil, nil, true))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refresh_tokens" SET "used_at"=$1 WHERE id = $2`)).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE (id = $1 AND status = $2) AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs(1, "active").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "cam-amber", "basicuser"))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "refresh_tokens"`)).WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), "family", sqlmock.AnyArg(), true, sqlmock.AnyArg(), nil, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectCommit()
+
+	pair, err := sessionService.Refresh("old-token")
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if pair.RefreshToken == "" || pair.RefreshToken == "old-token" {
+		t.Errorf("expected a new refresh token, got %s", pair.RefreshToken)
+	}
+}
+
+func TestRefreshFailOnUnknownToken(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectRefreshToken)).WithArgs(tokenhash.Sum("unknown")).WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	_, err := sessionService.Refresh("unknown")
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected an invalid refresh token error, got %v", err)
+	}
+}
+
+func TestRefreshFailOnExpiredToken(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectRefreshToken)).WithArgs(tokenhash.Sum("expired")).WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(1, time.Now().Add(-48*time.Hour), 1, tokenhash.Sum("expired"), "family", "jti", time.Now().Add(-time.Hour), nil, nil, true))
+	mock.ExpectRollback()
+
+	_, err := sessionService.Refresh("expired")
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected an invalid refresh token error, got %v", err)
+	}
+}
+
+func TestRefreshFailOnReusedToken(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectRefreshToken)).WithArgs(tokenhash.Sum("stolen")).WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(1, time.Now(), 1, tokenhash.Sum("stolen"), "family", "old-jti", time.Now().Add(time.Hour), time.Now(), nil, true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refresh_tokens" WHERE family = $1 AND created_at > $2`)).WithArgs("family", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(2, time.Now(), 1, "hash", "family", "new-jti", time.Now().Add(time.Hour), nil, nil, true))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "revoked_tokens" ("jti","expires_at") VALUES ($1,$2) ON CONFLICT DO NOTHING`)).WithArgs("new-jti", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refresh_tokens" SET "revoked_at"=$1 WHERE family = $2 AND revoked_at IS NULL`)).WithArgs(sqlmock.AnyArg(), "family").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	_, err := sessionService.Refresh("stolen")
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("expected a reused refresh token error, got %v", err)
+	}
+}
+
+func TestEndSessionSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "revoked_tokens" ("jti","expires_at") VALUES ($1,$2) ON CONFLICT DO NOTHING`)).WithArgs("jti", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refresh_tokens" WHERE hash = $1 ORDER BY "refresh_tokens"."id" LIMIT 1`)).WithArgs(tokenhash.Sum("token")).WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(1, time.Now(), 1, tokenhash.Sum("token"), "family", "jti", time.Now().Add(time.Hour), nil, nil, true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refresh_tokens" WHERE family = $1 AND created_at > $2`)).WithArgs("family", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(1, time.Now(), 1, tokenhash.Sum("token"), "family", "jti", time.Now().Add(time.Hour), nil, nil, true))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "revoked_tokens" ("jti","expires_at") VALUES ($1,$2) ON CONFLICT DO NOTHING`)).WithArgs("jti", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refresh_tokens" SET "revoked_at"=$1 WHERE family = $2 AND revoked_at IS NULL`)).WithArgs(sqlmock.AnyArg(), "family").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := sessionService.EndSession(&jwt.Claims{ID: "jti", Expiry: time.Now().Add(time.Minute).Unix()}, "token")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestIsRevokedSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "revoked_tokens" WHERE jti = $1`)).WithArgs("jti").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	revoked, err := sessionService.IsRevoked("jti")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if !revoked {
+		t.Errorf("expected the token to be revoked")
+	}
+}
+
+func TestRevokeUserSessionsSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refresh_tokens" WHERE user_id = $1 AND created_at > $2`)).WithArgs(1, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(1, time.Now(), 1, "hash", "family", "jti", time.Now().Add(time.Hour), nil, nil, true).AddRow(2, time.Now(), 1, "other-hash", "other-family", "other-jti", time.Now().Add(time.Hour), nil, nil, true))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "revoked_tokens" ("jti","expires_at") VALUES ($1,$2),($3,$4) ON CONFLICT DO NOTHING`)).WithArgs("jti", sqlmock.AnyArg(), "other-jti", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refresh_tokens" SET "revoked_at"=$1 WHERE user_id = $2 AND revoked_at IS NULL`)).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectCommit()
+
+	err := sessionService.RevokeUserSessions(1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
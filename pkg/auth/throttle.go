@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrTooManyAttempts is returned when logins of a username or from an IP address have to wait after failing too many times.
+var ErrTooManyAttempts = errors.New("too many failed logins, retry later")
+
+// ErrAccountLocked is returned when logins of a username are refused until its lockout ends.
+var ErrAccountLocked = errors.New("the account is temporarily locked after too many failed logins")
+
+// maxLoginDelay is the longest a login has to wait after a failure, however many failures there were.
+const maxLoginDelay = 15 * time.Minute
+
+// failureWindow is the duration after which failed logins are forgotten.
+const failureWindow = 24 * time.Hour
+
+// ThrottlePolicy defines how failed logins slow down the next ones.
+type ThrottlePolicy struct {
+	// The failures allowed for a username before each new login has to wait, twice longer after each failure
+	FreeFailures int
+	// The failures allowed from an IP address before each new login has to wait, it is higher since addresses can be shared
+	IPFreeFailures int
+	// The failures after which the account of a username is locked
+	MaxFailures int
+	// How long a locked account stays locked
+	LockoutDuration time.Duration
+}
+
+// DefaultThrottlePolicy locks an account for 30 minutes after 10 failures.
+var DefaultThrottlePolicy = ThrottlePolicy{
+	FreeFailures:    3,
+	IPFreeFailures:  20,
+	MaxFailures:     10,
+	LockoutDuration: 30 * time.Minute,
+}
+
+// LoginFailure counts the recent failed logins of a username or from an IP address.
+type LoginFailure struct {
+	// The username or the IP address, prefixed by its kind
+	Key      string `gorm:"primaryKey;size:300"`
+	Failures int    `gorm:"not null"`
+	// When the last login failed, the failures are forgotten a day after it
+	LastFailureAt time.Time `gorm:"index"`
+	// When the account of the username can log in again
+	LockedUntil *time.Time
+}
+
+// userKey returns the key of the failures of a username.
+func userKey(username string) string {
+	if len(username) > 255 {
+		username = username[:255]
+	}
+
+	return "user:" + username
+}
+
+// ipKey returns the key of the failures from an IP address.
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// backoff returns how long to wait after a failure given the number of failures and how many are allowed without waiting.
+func backoff(failures int, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+
+	shift := failures - free - 1
+	if shift > 20 {
+		return maxLoginDelay
+	}
+
+	delay := time.Second << shift
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+
+	return delay
+}
+
+// NewThrottleService is the constructor for a ThrottleService.
+func NewThrottleService(db *gorm.DB, policy ThrottlePolicy) *ThrottleService {
+	return &ThrottleService{
+		db:     db,
+		policy: policy,
+	}
+}
+
+// ThrottleService is a service made to slow down password guessing by delaying and locking logins after failures.
+type ThrottleService struct {
+	db     *gorm.DB
+	policy ThrottlePolicy
+}
+
+// Check takes the IP address a login comes from and the username it is for, and tells how long the login has to wait.
+// It returns ErrAccountLocked if the account is locked, or ErrTooManyAttempts if the login has to wait after recent failures.
+func (ts *ThrottleService) Check(ip string, username string) (time.Duration, error) {
+	var failures []LoginFailure
+
+	err := ts.db.Where("key IN ?", []string{ipKey(ip), userKey(username)}).Find(&failures).Error
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	var reason error
+
+	for _, failure := range failures {
+		if failure.LockedUntil != nil && failure.LockedUntil.After(now) {
+			return failure.LockedUntil.Sub(now), ErrAccountLocked
+		}
+
+		if failure.LastFailureAt.Before(now.Add(-failureWindow)) {
+			continue
+		}
+
+		free := ts.policy.FreeFailures
+		if failure.Key == ipKey(ip) {
+			free = ts.policy.IPFreeFailures
+		}
+
+		if remaining := failure.LastFailureAt.Add(backoff(failure.Failures, free)).Sub(now); remaining > wait {
+			wait = remaining
+			reason = ErrTooManyAttempts
+		}
+	}
+
+	return wait, reason
+}
+
+// Fail records a failed login from an IP address for a username, whether it exists or not,
+// and tells if the account of the username has been locked because of it.
+func (ts *ThrottleService) Fail(ip string, username string) (bool, error) {
+	locked := false
+	now := time.Now()
+
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		for _, key := range []string{ipKey(ip), userKey(username)} {
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "key"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"failures":        gorm.Expr("CASE WHEN login_failures.last_failure_at < ? THEN 1 ELSE login_failures.failures + 1 END", now.Add(-failureWindow)),
+					"last_failure_at": now,
+				}),
+			}).Create(&LoginFailure{Key: key, Failures: 1, LastFailureAt: now}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		result := tx.Model(&LoginFailure{}).Where("key = ? AND failures >= ?", userKey(username), ts.policy.MaxFailures).Update("locked_until", now.Add(ts.policy.LockoutDuration))
+		locked = result.RowsAffected > 0
+
+		return result.Error
+	})
+
+	return locked, err
+}
+
+// Succeed forgets the failed logins of a username once he logged in.
+// The failures from the IP address are kept, so that logging in an account doesn't allow guessing the password of another one.
+func (ts *ThrottleService) Succeed(username string) error {
+	return ts.db.Where("key = ?", userKey(username)).Delete(&LoginFailure{}).Error
+}
+
+// Unlock forgets the failed logins of a username, unlocking his account.
+func (ts *ThrottleService) Unlock(username string) error {
+	return ts.Succeed(username)
+}
+
+// PurgeExpired deletes the failed logins that have been forgotten and aren't locking an account anymore.
+func (ts *ThrottleService) PurgeExpired() error {
+	now := time.Now()
+
+	return ts.db.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-failureWindow), now).Delete(&LoginFailure{}).Error
+}
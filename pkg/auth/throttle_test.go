@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var loginFailureColumns = []string{"key", "failures", "last_failure_at", "locked_until"}
+
+const selectLoginFailures = `SELECT * FROM "login_failures" WHERE key IN ($1,$2)`
+
+func TestBackoff(t *testing.T) {
+	for failures, expected := range map[int]time.Duration{0: 0, 3: 0, 4: time.Second, 5: 2 * time.Second, 8: 16 * time.Second, 20: maxLoginDelay, 100: maxLoginDelay} {
+		if delay := backoff(failures, 3); delay != expected {
+			t.Errorf("expected a %s delay after %d failures, got %s", expected, failures, delay)
+		}
+	}
+}
+
+func TestCheckSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectLoginFailures)).WithArgs("ip:192.0.2.1", "user:cam-amber").WillReturnRows(sqlmock.NewRows(loginFailureColumns).
+		AddRow("user:cam-amber", 3, time.Now(), nil).
+		AddRow("ip:192.0.2.1", 15, time.Now(), nil))
+
+	wait, err := throttleService.Check("192.0.2.1", "cam-amber")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if wait != 0 {
+		t.Errorf("expected no wait before the free failures are used, got %s", wait)
+	}
+}
+
+func TestCheckFailAfterFailures(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectLoginFailures)).WithArgs("ip:192.0.2.1", "user:cam-amber").WillReturnRows(sqlmock.NewRows(loginFailureColumns).
+		AddRow("user:cam-amber", 9, time.Now(), nil).
+		AddRow("ip:192.0.2.1", 9, time.Now(), nil))
+
+	wait, err := throttleService.Check("192.0.2.1", "cam-amber")
+	if !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("expected a too many attempts error, got %v", err)
+	}
+
+	if wait <= 16*time.Second || wait > 32*time.Second {
+		t.Errorf("expected a wait of about 32s after 9 failures, got %s", wait)
+	}
+}
+
+func TestCheckFailOnLockedAccount(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectLoginFailures)).WithArgs("ip:192.0.2.1", "user:admin").WillReturnRows(sqlmock.NewRows(loginFailureColumns).
+		AddRow("user:admin", 10, time.Now().Add(-time.Hour), time.Now().Add(10*time.Minute)))
+
+	wait, err := throttleService.Check("192.0.2.1", "admin")
+	if !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("expected an account locked error, got %v", err)
+	}
+
+	if wait <= 9*time.Minute {
+		t.Errorf("expected to wait for the end of the lockout, got %s", wait)
+	}
+}
+
+func TestCheckIgnoreForgottenFailures(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectLoginFailures)).WithArgs("ip:192.0.2.1", "user:cam-amber").WillReturnRows(sqlmock.NewRows(loginFailureColumns).
+		AddRow("user:cam-amber", 9, time.Now().Add(-2*failureWindow), nil))
+
+	if wait, err := throttleService.Check("192.0.2.1", "cam-amber"); wait != 0 || err != nil {
+		t.Errorf("expected failures older than a day to be forgotten, got %s %v", wait, err)
+	}
+}
+
+func TestFailSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	upsert := `INSERT INTO "login_failures" ("key","failures","last_failure_at","locked_until") VALUES ($1,$2,$3,$4) ON CONFLICT ("key") DO UPDATE SET "failures"=CASE WHEN login_failures.last_failure_at < $5 THEN 1 ELSE login_failures.failures + 1 END,"last_failure_at"=$6`
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(upsert)).WithArgs("ip:192.0.2.1", 1, sqlmock.AnyArg(), nil, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(upsert)).WithArgs("user:admin", 1, sqlmock.AnyArg(), nil, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "login_failures" SET "locked_until"=$1 WHERE key = $2 AND failures >= $3`)).WithArgs(sqlmock.AnyArg(), "user:admin", 10).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	locked, err := throttleService.Fail("192.0.2.1", "admin")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if !locked {
+		t.Errorf("expected the account to be locked after its tenth failure")
+	}
+}
+
+func TestUnlockSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "login_failures" WHERE key = $1`)).WithArgs("user:admin").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := throttleService.Unlock("admin"); err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
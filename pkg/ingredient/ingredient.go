@@ -1,16 +1,89 @@
 package ingredient
 
-import "gorm.io/gorm"
+import (
+	"errors"
+	"strings"
+
+	"github.com/mjehanno/welsh-academy/pkg/units"
+	"gorm.io/gorm"
+)
+
+// ErrSelfMerge is returned when trying to merge an ingredient with itself.
+var ErrSelfMerge = errors.New("can't merge an ingredient with itself")
+
+// ingredientReference is a table referencing ingredients.
+type ingredientReference struct {
+	table string
+	// The column of the entity owning the reference
+	owner string
+	// Whether the rows hold a quantity and a unit, which are added up when merging two ingredients held by the same owner
+	quantified bool
+	// Whether the rows hold a note on how to prepare the ingredient, notes being joined when merging
+	noted bool
+	// Whether the rows belong to users, they are soft deleted and removed along with the ingredient
+	personal bool
+	// Whether an owner holds an ingredient once per unit instead of once, rows being only merged when they share the same unit
+	perUnit bool
+}
+
+// ingredientReferences lists the tables referencing an ingredient.
+var ingredientReferences = []ingredientReference{
+	{table: "recipe_ingredient", owner: "recipe_id", quantified: true, noted: true},
+	{table: "step_ingredient", owner: "step_id"},
+	{table: "pantry_items", owner: "user_id", quantified: true, personal: true},
+	{table: "shopping_items", owner: "shopping_list_id", quantified: true, personal: true, perUnit: true},
+}
 
 // Ingredient defines a product in cooking.
 // @Description Ingredient defines a product in cooking.
 type Ingredient struct {
 	gorm.Model
 	// The name of the ingredient
-	Name string `example:"cheddar" gorm:"unique;not null; default:null"`
+	Name string `example:"cheddar" gorm:"uniqueIndex:idx_ingredients_name,where:deleted_at IS NULL;not null; default:null"`
+	// The density in grams per millilitre, used to convert between mass and volume, 0 if unknown
+	Density float64 `example:"0.45"`
 	// Recipes []*Recipe `gorm:"many2many:recipe_ingredient;"`
 }
 
+// RecipeReference is a light view of a recipe using an ingredient.
+type RecipeReference struct {
+	ID uint `example:"1"`
+	// The name of the Recipe
+	Name string `example:"welsh"`
+}
+
+// InUseError is returned when an ingredient can't be deleted because some recipes still use it.
+// @Description InUseError lists the recipes preventing an ingredient from being deleted.
+type InUseError struct {
+	// ErrorMessage define the error message returned by the api
+	ErrorMessage string `example:"ingredient is still used by some recipes"`
+	// The recipes using the ingredient
+	Recipes []RecipeReference
+}
+
+func (e *InUseError) Error() string {
+	return e.ErrorMessage
+}
+
+// MergeConflictError is returned when two ingredients can't be merged because some owners hold both in units that can't be added up.
+// @Description MergeConflictError lists the recipes and pantries holding both ingredients in units that can't be added up.
+type MergeConflictError struct {
+	// ErrorMessage define the error message returned by the api
+	ErrorMessage string `example:"some recipes or pantries hold both ingredients in units that can't be added up"`
+	// The IDs of the owners holding both ingredients, by kind of owner (recipe_id or user_id for pantries)
+	Conflicts map[string][]uint
+}
+
+func (e *MergeConflictError) Error() string {
+	return e.ErrorMessage
+}
+
+// MergeRequest defines which ingredient is kept when merging two duplicated ingredients.
+type MergeRequest struct {
+	// The ID of the ingredient that will be kept
+	IntoID uint `example:"1"`
+}
+
 // NewIngredientService is the IngredientService constructor.
 func NewIngredientService(db *gorm.DB) *IngredientService {
 	return &IngredientService{
@@ -40,6 +113,15 @@ func (is *IngredientService) GetIngredientByName(name string) (Ingredient, error
 	return ingredient, result.Error
 }
 
+// GetIngredientById takes an ingredient ID and returns the corresponding ingredient or an error.
+func (is *IngredientService) GetIngredientById(ingredientID uint) (Ingredient, error) {
+	var ingredient Ingredient
+
+	result := is.db.Where("id = ?", ingredientID).First(&ingredient)
+
+	return ingredient, result.Error
+}
+
 // GetAllIngredient returns a list containing all created ingredient.
 func (is *IngredientService) GetAllIngredient() ([]Ingredient, error) {
 	var ingredients []Ingredient
@@ -48,3 +130,234 @@ func (is *IngredientService) GetAllIngredient() ([]Ingredient, error) {
 
 	return ingredients, result.Error
 }
+
+// UpdateIngredient takes an ingredient and updates the name and density of the stored ingredient with the same ID, returning gorm.ErrRecordNotFound if it doesn't exist.
+func (is *IngredientService) UpdateIngredient(ingredient Ingredient) error {
+	result := is.db.Model(&Ingredient{}).Where("id = ?", ingredient.ID).Select("name", "density").Updates(ingredient)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetRecipesUsingIngredient takes an ingredient ID and returns the recipes that still use it.
+func (is *IngredientService) GetRecipesUsingIngredient(ingredientID uint) ([]RecipeReference, error) {
+	var recipes []RecipeReference
+
+	result := is.db.Table("recipes").Select("recipes.id, recipes.name").
+		Joins("inner join recipe_ingredient ri on ri.recipe_id = recipes.id").
+		Where("ri.ingredient_id = ?", ingredientID).
+		Where("recipes.deleted_at IS NULL").
+		Order("recipes.id").
+		Find(&recipes)
+
+	return recipes, result.Error
+}
+
+// DeleteIngredient takes an ingredient ID, removes it from users pantries and shopping lists and soft deletes it.
+// It returns an *InUseError listing the recipes using the ingredient if there are some, or gorm.ErrRecordNotFound if it doesn't exist.
+func (is *IngredientService) DeleteIngredient(ingredientID uint) error {
+	return is.db.Transaction(func(tx *gorm.DB) error {
+		recipes, err := NewIngredientService(tx).GetRecipesUsingIngredient(ingredientID)
+		if err != nil {
+			return err
+		}
+
+		if len(recipes) > 0 {
+			return &InUseError{ErrorMessage: "ingredient is still used by some recipes", Recipes: recipes}
+		}
+
+		for _, reference := range ingredientReferences {
+			if !reference.personal {
+				continue
+			}
+
+			if err := tx.Exec("DELETE FROM "+reference.table+" WHERE ingredient_id = ?", ingredientID).Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Delete(&Ingredient{}, ingredientID)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// MergeIngredients takes the ID of a duplicated ingredient and the ID of the ingredient that should be kept.
+// Every reference to the duplicate is moved to the kept ingredient in one transaction, then the duplicate is soft deleted.
+// When a recipe or a pantry already holds both, the duplicate quantity is added to the kept one once converted to its unit, and recipe notes are joined.
+// If the units can't be converted into each other, nothing is merged and a *MergeConflictError listing these recipes and pantries is returned.
+// Shopping lists holding both get the quantities added up when they share the same unit, the other items being kept.
+func (is *IngredientService) MergeIngredients(duplicateID uint, keptID uint) error {
+	if duplicateID == keptID {
+		return ErrSelfMerge
+	}
+
+	return is.db.Transaction(func(tx *gorm.DB) error {
+		var ingredients []Ingredient
+		if err := tx.Where("id IN ?", []uint{duplicateID, keptID}).Find(&ingredients).Error; err != nil {
+			return err
+		}
+
+		if len(ingredients) != 2 {
+			return gorm.ErrRecordNotFound
+		}
+
+		// quantities are converted with the density of the kept ingredient, or the one of the duplicate when it's unknown
+		var density float64
+		for _, merged := range ingredients {
+			if merged.Density > 0 && (density == 0 || merged.ID == keptID) {
+				density = merged.Density
+			}
+		}
+
+		conflicts := map[string][]uint{}
+		for _, reference := range ingredientReferences {
+			if reference.perUnit {
+				if err := mergeSameUnitQuantities(tx, reference, duplicateID, keptID); err != nil {
+					return err
+				}
+			} else {
+				if reference.quantified {
+					conflicting, err := mergeQuantities(tx, reference, duplicateID, keptID, density)
+					if err != nil {
+						return err
+					}
+
+					if len(conflicting) > 0 {
+						conflicts[reference.owner] = conflicting
+						continue
+					}
+				}
+
+				err := tx.Exec("DELETE FROM "+reference.table+" WHERE ingredient_id = ? AND "+reference.owner+" IN (SELECT "+reference.owner+" FROM "+reference.table+" WHERE ingredient_id = ?)", duplicateID, keptID).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			err := tx.Exec("UPDATE "+reference.table+" SET ingredient_id = ? WHERE ingredient_id = ?", keptID, duplicateID).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(conflicts) > 0 {
+			return &MergeConflictError{ErrorMessage: "some recipes or pantries hold both ingredients in units that can't be added up", Conflicts: conflicts}
+		}
+
+		return tx.Delete(&Ingredient{}, duplicateID).Error
+	})
+}
+
+// mergedRow is a row holding the kept ingredient along with the row of the same owner holding the duplicate.
+type mergedRow struct {
+	Owner             uint
+	KeptQuantity      float64
+	KeptUnit          string
+	KeptNote          string
+	DuplicateQuantity float64
+	DuplicateUnit     string
+	DuplicateNote     string
+}
+
+// mergeQuantities adds the quantity of each row holding the duplicated ingredient to the row of the same owner holding the kept one.
+// It returns the owners whose rows have units that can't be converted into each other, which are left untouched.
+func mergeQuantities(tx *gorm.DB, reference ingredientReference, duplicateID uint, keptID uint, density float64) ([]uint, error) {
+	columns := "kept." + reference.owner + " AS owner, kept.quantity AS kept_quantity, kept.unit AS kept_unit, duplicate.quantity AS duplicate_quantity, duplicate.unit AS duplicate_unit"
+	if reference.noted {
+		columns += ", kept.note AS kept_note, duplicate.note AS duplicate_note"
+	}
+
+	query := "SELECT " + columns + " FROM " + reference.table + " AS kept JOIN " + reference.table + " AS duplicate ON duplicate." + reference.owner + " = kept." + reference.owner +
+		" WHERE kept.ingredient_id = ? AND duplicate.ingredient_id = ?"
+	if reference.personal {
+		query += " AND kept.deleted_at IS NULL AND duplicate.deleted_at IS NULL"
+	}
+
+	var rows []mergedRow
+	if err := tx.Raw(query, keptID, duplicateID).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	var conflicting []uint
+	for _, row := range rows {
+		quantity, unit, ok := addQuantities(row.KeptQuantity, row.KeptUnit, row.DuplicateQuantity, row.DuplicateUnit, density)
+		if !ok {
+			conflicting = append(conflicting, row.Owner)
+			continue
+		}
+
+		merged := map[string]interface{}{"quantity": quantity, "unit": unit}
+		if reference.noted {
+			merged["note"] = joinNotes(row.KeptNote, row.DuplicateNote)
+		}
+
+		err := tx.Table(reference.table).Where("ingredient_id = ? AND "+reference.owner+" = ?", keptID, row.Owner).Updates(merged).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return conflicting, nil
+}
+
+// mergeSameUnitQuantities adds the quantity of each row holding the duplicated ingredient to the row of the same owner holding the kept one
+// in the same unit, and removes it. Rows in other units are left to be moved to the kept ingredient.
+func mergeSameUnitQuantities(tx *gorm.DB, reference ingredientReference, duplicateID uint, keptID uint) error {
+	err := tx.Exec("UPDATE "+reference.table+" AS kept SET quantity = kept.quantity + duplicate.quantity FROM "+reference.table+" AS duplicate"+
+		" WHERE kept.ingredient_id = ? AND duplicate.ingredient_id = ? AND duplicate."+reference.owner+" = kept."+reference.owner+
+		" AND duplicate.unit = kept.unit AND kept.deleted_at IS NULL AND duplicate.deleted_at IS NULL", keptID, duplicateID).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec("DELETE FROM "+reference.table+" AS duplicate USING "+reference.table+" AS kept"+
+		" WHERE duplicate.ingredient_id = ? AND kept.ingredient_id = ? AND duplicate."+reference.owner+" = kept."+reference.owner+
+		" AND duplicate.unit = kept.unit AND kept.deleted_at IS NULL AND duplicate.deleted_at IS NULL", duplicateID, keptID).Error
+}
+
+// addQuantities adds a quantity to another one, converting it to the unit of the other one with the density of the ingredient when needed.
+// A quantity of 0 being unknown, the other quantity is returned with its unit. The boolean is false when the units can't be converted into each other.
+func addQuantities(quantity float64, unit string, added float64, addedUnit string, density float64) (float64, string, bool) {
+	switch {
+	case added == 0:
+		return quantity, unit, true
+	case quantity == 0:
+		return added, addedUnit, true
+	case strings.EqualFold(strings.TrimSpace(unit), strings.TrimSpace(addedUnit)):
+		return quantity + added, unit, true
+	}
+
+	converted, err := units.Convert(added, addedUnit, unit, density)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return quantity + converted, unit, true
+}
+
+// joinNotes returns both notes separated by a comma, or the only one set.
+func joinNotes(note string, added string) string {
+	if added == "" || strings.EqualFold(note, added) {
+		return note
+	}
+
+	if note == "" {
+		return added
+	}
+
+	return note + ", " + added
+}
@@ -2,7 +2,10 @@ package ingredient
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
 	"regexp"
 	"testing"
 
@@ -66,7 +69,7 @@ func TestCreateIngredientSucceed(t *testing.T) {
 	defer tearDown(t)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "ingredients" ("created_at","updated_at","deleted_at","name") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "brie").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "ingredients" ("created_at","updated_at","deleted_at","density","name") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 0.0, "brie").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectCommit()
 
 	_, err := ingredientService.CreateIngredient(Ingredient{Name: "brie"})
@@ -81,7 +84,7 @@ func TestCreateIngredientFail(t *testing.T) {
 	defer tearDown(t)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "ingredients" ("created_at","updated_at","deleted_at") VALUES ($1,$2,$3) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnError(fmt.Errorf("can't create ingredient without name"))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "ingredients" ("created_at","updated_at","deleted_at","density") VALUES ($1,$2,$3,$4) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 0.0).WillReturnError(fmt.Errorf("can't create ingredient without name"))
 	mock.ExpectRollback()
 
 	_, err := ingredientService.CreateIngredient(Ingredient{Name: ""})
@@ -116,3 +119,230 @@ func TestGetIngredientByNameFail(t *testing.T) {
 	}
 
 }
+
+func TestGetIngredientByIdSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE id = $1 AND "ingredients"."deleted_at" IS NULL ORDER BY "ingredients"."id" LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar"))
+
+	ingredient, err := ingredientService.GetIngredientById(1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if ingredient.Name != "cheddar" {
+		t.Errorf("expected cheddar, got %+v", ingredient)
+	}
+}
+
+func TestGetIngredientByIdFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE id = $1 AND "ingredients"."deleted_at" IS NULL ORDER BY "ingredients"."id" LIMIT 1`)).WithArgs(42).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	_, err := ingredientService.GetIngredientById(42)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestUpdateIngredientSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "ingredients" SET "updated_at"=$1,"name"=$2,"density"=$3 WHERE id = $4 AND "ingredients"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), "cheddar", 0.45, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := ingredientService.UpdateIngredient(Ingredient{Model: gorm.Model{ID: 1}, Name: "cheddar", Density: 0.45})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestUpdateIngredientFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "ingredients" SET "updated_at"=$1,"name"=$2,"density"=$3 WHERE id = $4 AND "ingredients"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), "cheddar", 0.0, 4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := ingredientService.UpdateIngredient(Ingredient{Model: gorm.Model{ID: 4}, Name: "cheddar"})
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestDeleteIngredientSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT recipes.id, recipes.name FROM "recipes" inner join recipe_ingredient ri on ri.recipe_id = recipes.id WHERE ri.ingredient_id = $1 AND recipes.deleted_at IS NULL ORDER BY recipes.id`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM pantry_items WHERE ingredient_id = $1`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM shopping_items WHERE ingredient_id = $1`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "ingredients" SET "deleted_at"=$1 WHERE "ingredients"."id" = $2 AND "ingredients"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := ingredientService.DeleteIngredient(1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestDeleteIngredientFailWhenUsed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT recipes.id, recipes.name FROM "recipes" inner join recipe_ingredient ri on ri.recipe_id = recipes.id WHERE ri.ingredient_id = $1 AND recipes.deleted_at IS NULL ORDER BY recipes.id`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh").AddRow(3, "croque-monsieur"))
+	mock.ExpectRollback()
+
+	err := ingredientService.DeleteIngredient(1)
+
+	var inUse *InUseError
+	if !errors.As(err, &inUse) {
+		t.Fatalf("expected an InUseError, got %v", err)
+	}
+
+	if len(inUse.Recipes) != 2 || inUse.Recipes[1].Name != "croque-monsieur" {
+		t.Errorf("expected welsh and croque-monsieur to be listed, got %v", inUse.Recipes)
+	}
+}
+
+const (
+	selectMergedIngredients = `SELECT * FROM "ingredients" WHERE id IN ($1,$2) AND "ingredients"."deleted_at" IS NULL`
+	selectMergedRecipes     = `SELECT kept.recipe_id AS owner, kept.quantity AS kept_quantity, kept.unit AS kept_unit, duplicate.quantity AS duplicate_quantity, duplicate.unit AS duplicate_unit, kept.note AS kept_note, duplicate.note AS duplicate_note FROM recipe_ingredient AS kept JOIN recipe_ingredient AS duplicate ON duplicate.recipe_id = kept.recipe_id WHERE kept.ingredient_id = $1 AND duplicate.ingredient_id = $2`
+	selectMergedPantries    = `SELECT kept.user_id AS owner, kept.quantity AS kept_quantity, kept.unit AS kept_unit, duplicate.quantity AS duplicate_quantity, duplicate.unit AS duplicate_unit FROM pantry_items AS kept JOIN pantry_items AS duplicate ON duplicate.user_id = kept.user_id WHERE kept.ingredient_id = $1 AND duplicate.ingredient_id = $2 AND kept.deleted_at IS NULL AND duplicate.deleted_at IS NULL`
+)
+
+var mergedColumns = []string{"owner", "kept_quantity", "kept_unit", "duplicate_quantity", "duplicate_unit"}
+
+func expectShoppingItemsMerge() {
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE shopping_items AS kept SET quantity = kept.quantity + duplicate.quantity FROM shopping_items AS duplicate WHERE kept.ingredient_id = $1 AND duplicate.ingredient_id = $2 AND duplicate.shopping_list_id = kept.shopping_list_id AND duplicate.unit = kept.unit AND kept.deleted_at IS NULL AND duplicate.deleted_at IS NULL`)).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM shopping_items AS duplicate USING shopping_items AS kept WHERE duplicate.ingredient_id = $1 AND kept.ingredient_id = $2 AND duplicate.shopping_list_id = kept.shopping_list_id AND duplicate.unit = kept.unit AND kept.deleted_at IS NULL AND duplicate.deleted_at IS NULL`)).WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE shopping_items SET ingredient_id = $1 WHERE ingredient_id = $2`)).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestMergeIngredientsSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectMergedIngredients)).WithArgs(2, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "density"}).AddRow(1, "cheddar", 0).AddRow(2, "chedar", 0))
+	mock.ExpectQuery(regexp.QuoteMeta(selectMergedRecipes)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(append(mergedColumns, "kept_note", "duplicate_note")).AddRow(3, 250, "g", 0.5, "kg", "grated", "sliced"))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "recipe_ingredient" SET "note"=$1,"quantity"=$2,"unit"=$3 WHERE ingredient_id = $4 AND recipe_id = $5`)).WithArgs("grated, sliced", 750.0, "g", 1, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM recipe_ingredient WHERE ingredient_id = $1 AND recipe_id IN (SELECT recipe_id FROM recipe_ingredient WHERE ingredient_id = $2)`)).WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE recipe_ingredient SET ingredient_id = $1 WHERE ingredient_id = $2`)).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM step_ingredient WHERE ingredient_id = $1 AND step_id IN (SELECT step_id FROM step_ingredient WHERE ingredient_id = $2)`)).WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE step_ingredient SET ingredient_id = $1 WHERE ingredient_id = $2`)).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(selectMergedPantries)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(mergedColumns).AddRow(4, 500, "ml", 1, "l"))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "pantry_items" SET "quantity"=$1,"unit"=$2 WHERE ingredient_id = $3 AND user_id = $4`)).WithArgs(1500.0, "ml", 1, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM pantry_items WHERE ingredient_id = $1 AND user_id IN (SELECT user_id FROM pantry_items WHERE ingredient_id = $2)`)).WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE pantry_items SET ingredient_id = $1 WHERE ingredient_id = $2`)).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectShoppingItemsMerge()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "ingredients" SET "deleted_at"=$1 WHERE "ingredients"."id" = $2 AND "ingredients"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := ingredientService.MergeIngredients(2, 1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestMergeIngredientsFailOnMismatchedUnits(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectMergedIngredients)).WithArgs(2, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "density"}).AddRow(1, "cheddar", 0).AddRow(2, "chedar", 0))
+	mock.ExpectQuery(regexp.QuoteMeta(selectMergedRecipes)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(append(mergedColumns, "kept_note", "duplicate_note")).AddRow(3, 2, "slices", 100, "g", "", ""))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM step_ingredient WHERE ingredient_id = $1 AND step_id IN (SELECT step_id FROM step_ingredient WHERE ingredient_id = $2)`)).WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE step_ingredient SET ingredient_id = $1 WHERE ingredient_id = $2`)).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(selectMergedPantries)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(mergedColumns).AddRow(4, 2, "cup", 100, "g"))
+	expectShoppingItemsMerge()
+	mock.ExpectRollback()
+
+	err := ingredientService.MergeIngredients(2, 1)
+
+	var conflict *MergeConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected a merge conflict error, got %v", err)
+	}
+
+	if !reflect.DeepEqual(conflict.Conflicts, map[string][]uint{"recipe_id": {3}, "user_id": {4}}) {
+		t.Errorf("expected recipe 3 and the pantry of user 4 to conflict, got %v", conflict.Conflicts)
+	}
+}
+
+func TestMergeIngredientsFailOnRollback(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectMergedIngredients)).WithArgs(2, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "chedar"))
+	mock.ExpectQuery(regexp.QuoteMeta(selectMergedRecipes)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(mergedColumns))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM recipe_ingredient WHERE ingredient_id = $1 AND recipe_id IN (SELECT recipe_id FROM recipe_ingredient WHERE ingredient_id = $2)`)).WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE recipe_ingredient SET ingredient_id = $1 WHERE ingredient_id = $2`)).WithArgs(1, 2).WillReturnError(fmt.Errorf("connection lost"))
+	mock.ExpectRollback()
+
+	err := ingredientService.MergeIngredients(2, 1)
+	if err == nil {
+		t.Error("error did not occured while it should have")
+	}
+}
+
+func TestMergeIngredientsFailOnUnknownIngredient(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectMergedIngredients)).WithArgs(2, 4).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(2, "chedar"))
+	mock.ExpectRollback()
+
+	err := ingredientService.MergeIngredients(2, 4)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestMergeIngredientsFailOnSelfMerge(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	err := ingredientService.MergeIngredients(1, 1)
+	if !errors.Is(err, ErrSelfMerge) {
+		t.Errorf("expected ErrSelfMerge, got %v", err)
+	}
+}
+
+func TestAddQuantities(t *testing.T) {
+	cases := []struct {
+		quantity     float64
+		unit         string
+		added        float64
+		addedUnit    string
+		density      float64
+		expected     float64
+		expectedUnit string
+		ok           bool
+	}{
+		{quantity: 500, unit: "ml", added: 1, addedUnit: "l", expected: 1500, expectedUnit: "ml", ok: true},
+		{quantity: 2, unit: "Slices", added: 1, addedUnit: "slices", expected: 3, expectedUnit: "Slices", ok: true},
+		{quantity: 0, unit: "", added: 2, addedUnit: "slices", expected: 2, expectedUnit: "slices", ok: true},
+		{quantity: 250, unit: "g", added: 0, addedUnit: "", expected: 250, expectedUnit: "g", ok: true},
+		{quantity: 100, unit: "g", added: 200, addedUnit: "ml", density: 0.5, expected: 200, expectedUnit: "g", ok: true},
+		{quantity: 2, unit: "cup", added: 100, addedUnit: "g", ok: false},
+		{quantity: 2, unit: "slices", added: 100, addedUnit: "g", ok: false},
+	}
+
+	for _, c := range cases {
+		quantity, unit, ok := addQuantities(c.quantity, c.unit, c.added, c.addedUnit, c.density)
+		if ok != c.ok || (ok && (math.Abs(quantity-c.expected) > 0.001 || unit != c.expectedUnit)) {
+			t.Errorf("expected %v %s + %v %s to be %v %s (%v), got %v %s (%v)", c.quantity, c.unit, c.added, c.addedUnit, c.expected, c.expectedUnit, c.ok, quantity, unit, ok)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package mail
+
+import (
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email sent to a user.
+type Message struct {
+	// The address the message is sent to
+	To string
+	// The subject of the message
+	Subject string
+	// The plain text body of the message
+	Body string
+}
+
+// Mailer sends emails, implementations decide how they are delivered.
+type Mailer interface {
+	Send(message Message) error
+}
+
+// NewLogMailer is the constructor for a LogMailer.
+func NewLogMailer(logger *log.Logger) *LogMailer {
+	return &LogMailer{
+		logger: logger,
+	}
+}
+
+// LogMailer writes messages to a logger instead of sending them, it's meant for development.
+type LogMailer struct {
+	logger *log.Logger
+}
+
+// Send writes the message to the logger.
+func (lm *LogMailer) Send(message Message) error {
+	lm.logger.Printf("mail to %s : %s\n%s", message.To, message.Subject, message.Body)
+
+	return nil
+}
+
+// format returns the message as an RFC 5322 email sent by from.
+func (m Message) format(from string) []byte {
+	var b strings.Builder
+
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + m.To + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
+
+// SMTPConfiguration describes the SMTP server emails are sent through.
+type SMTPConfiguration struct {
+	// The host of the server
+	Host string
+	// The port of the server, usually 587
+	Port string
+	// The username to authenticate with, no authentication is made when empty
+	Username string
+	// The password to authenticate with
+	Password string
+	// The address emails are sent from
+	From string
+}
+
+// NewSMTPMailer is the constructor for a SMTPMailer.
+func NewSMTPMailer(config SMTPConfiguration) *SMTPMailer {
+	return &SMTPMailer{
+		config: config,
+	}
+}
+
+// SMTPMailer sends messages through an SMTP server.
+type SMTPMailer struct {
+	config SMTPConfiguration
+}
+
+// Send sends the message through the SMTP server, using STARTTLS when the server supports it.
+func (sm *SMTPMailer) Send(message Message) error {
+	var auth smtp.Auth
+	if sm.config.Username != "" {
+		auth = smtp.PlainAuth("", sm.config.Username, sm.config.Password, sm.config.Host)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(sm.config.Host, sm.config.Port), auth, sm.config.From, []string{message.To}, message.format(sm.config.From))
+}
+
+// NewFileMailer is the constructor for a FileMailer.
+func NewFileMailer(path string) *FileMailer {
+	return &FileMailer{
+		path: path,
+	}
+}
+
+// FileMailer appends messages to a file instead of sending them, it's meant for local development.
+type FileMailer struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Send appends the message to the file.
+func (fm *FileMailer) Send(message Message) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	file, err := os.OpenFile(fm.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(message.format("welsh-academy"), "\r\n\r\n"...))
+
+	return err
+}
@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogMailerSendSucceed(t *testing.T) {
+	var output bytes.Buffer
+	mailer := NewLogMailer(log.New(&output, "", 0))
+
+	err := mailer.Send(Message{To: "cam-amber@welsh.academy", Subject: "Welcome", Body: "Say cheese"})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if !strings.Contains(output.String(), "cam-amber@welsh.academy") || !strings.Contains(output.String(), "Say cheese") {
+		t.Errorf("expected the message to be logged, got %s", output.String())
+	}
+}
+
+func TestFormatSucceed(t *testing.T) {
+	message := Message{To: "cam-amber@welsh.academy", Subject: "Réinitialisation", Body: "Say\ncheese"}
+
+	formatted := string(message.format("academy@welsh.academy"))
+	for _, expected := range []string{"From: academy@welsh.academy\r\n", "To: cam-amber@welsh.academy\r\n", "Subject: =?utf-8?q?R=C3=A9initialisation?=\r\n", "\r\n\r\nSay\r\ncheese"} {
+		if !strings.Contains(formatted, expected) {
+			t.Errorf("expected %q in the message, got %q", expected, formatted)
+		}
+	}
+}
+
+func TestFileMailerSendSucceed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mails.txt")
+	mailer := NewFileMailer(path)
+
+	for _, subject := range []string{"Welcome", "Password reset"} {
+		if err := mailer.Send(Message{To: "cam-amber@welsh.academy", Subject: subject, Body: "Say cheese"}); err != nil {
+			t.Errorf("error occured while it shouldn't have : %s", err.Error())
+		}
+	}
+
+	content, _ := os.ReadFile(path)
+	if !strings.Contains(string(content), "Subject: Welcome") || !strings.Contains(string(content), "Subject: Password reset") {
+		t.Errorf("expected both messages in the file, got %s", content)
+	}
+}
@@ -0,0 +1,482 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/jwt"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+)
+
+// ErrInvalidIDToken is returned when the ID token given by the provider isn't valid or wasn't issued for this login.
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// ErrProvider is returned when the identity provider answers with an error or something that can't be understood.
+var ErrProvider = errors.New("the identity provider answered with an error")
+
+// keysRefreshInterval is the minimum duration between two fetches of the keys of the provider,
+// so that tokens with unknown key IDs can't make us flood it.
+const keysRefreshInterval = time.Minute
+
+// GroupRole gives a role to the members of a group of the identity provider.
+type GroupRole struct {
+	Group string
+	Role  user.Role
+}
+
+// Configuration describes the identity provider and how its claims map to users.
+type Configuration struct {
+	// The issuer of the provider, its metadata is discovered from it
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// The URL of the callback the provider redirects the user to
+	RedirectURL string
+	// The scopes asked for, openid, profile and email when empty
+	Scopes []string
+	// The claims holding the username, email and groups of the user, preferred_username, email and groups when empty
+	UsernameClaim string
+	EmailClaim    string
+	GroupsClaim   string
+	// The roles given to the members of some groups, the first group the user is a member of wins
+	Roles []GroupRole
+}
+
+// Identity is what the provider tells about the user who logged in.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Username      string
+	Email         string
+	EmailVerified bool
+	Groups        []string
+	// Whether the provider authenticated the user with several factors, according to the amr claim
+	MultiFactor bool
+}
+
+// AuthorizationRequest is where to send the user to log in, along with what has to be kept until he comes back.
+type AuthorizationRequest struct {
+	URL      string
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// metadata is the part of the discovery document of the provider we use.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider is the constructor for a Provider, the provider is only contacted when a user logs in.
+func NewProvider(config Configuration, client *http.Client) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.EmailClaim == "" {
+		config.EmailClaim = "email"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Provider{
+		config: config,
+		client: client,
+	}
+}
+
+// Provider logs users in through an OpenID Connect identity provider with the authorization code flow and PKCE.
+type Provider struct {
+	config Configuration
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *metadata
+	keys          jwt.Keys
+	keysFetchedAt time.Time
+}
+
+// Issuer returns the issuer of the provider.
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// RoleFor returns the role of the first configured group the user is a member of, or an empty role when none matches
+// so that the role given to the user by an admin is kept.
+func (p *Provider) RoleFor(groups []string) user.Role {
+	for _, mapping := range p.config.Roles {
+		for _, group := range groups {
+			if group == mapping.Group {
+				return mapping.Role
+			}
+		}
+	}
+
+	return ""
+}
+
+// getJSON fetches a JSON document of the provider.
+func (p *Provider) getJSON(address string, v interface{}) error {
+	resp, err := p.client.Get(address)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w : %s returned %d", ErrProvider, address, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover returns the metadata of the provider, fetching it the first time.
+func (p *Provider) discover() (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var md metadata
+	if err := p.getJSON(strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &md); err != nil {
+		return nil, err
+	}
+
+	if md.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("%w : the provider's issuer is %s instead of %s", ErrProvider, md.Issuer, p.config.Issuer)
+	}
+
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, fmt.Errorf("%w : incomplete metadata", ErrProvider)
+	}
+
+	p.metadata = &md
+
+	return p.metadata, nil
+}
+
+// randomString returns a random URL safe string made of 32 bytes.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// challenge returns the S256 PKCE challenge of a verifier.
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthorizationURL returns a new request to log a user in, its state, nonce and verifier have to be given back to Exchange.
+func (p *Provider) AuthorizationURL() (AuthorizationRequest, error) {
+	md, err := p.discover()
+	if err != nil {
+		return AuthorizationRequest{}, err
+	}
+
+	var request AuthorizationRequest
+	for _, value := range []*string{&request.State, &request.Nonce, &request.Verifier} {
+		if *value, err = randomString(); err != nil {
+			return AuthorizationRequest{}, err
+		}
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {request.State},
+		"nonce":                 {request.Nonce},
+		"code_challenge":        {challenge(request.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	request.URL = md.AuthorizationEndpoint + separator + query.Encode()
+
+	return request, nil
+}
+
+// Exchange takes the authorization code the user came back with, along with the verifier and nonce of his request,
+// and returns his identity once the ID token given by the provider is verified.
+func (p *Provider) Exchange(code string, verifier string, nonce string) (Identity, error) {
+	md, err := p.discover()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("%w : the token endpoint returned %d", ErrProvider, resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || tokens.IDToken == "" {
+		return Identity{}, fmt.Errorf("%w : no ID token returned", ErrProvider)
+	}
+
+	return p.verify(md, tokens.IDToken, nonce)
+}
+
+// verify checks the signature, issuer, audience, expiry and nonce of an ID token and returns the identity it holds.
+func (p *Provider) verify(md *metadata, idToken string, nonce string) (Identity, error) {
+	keys, err := p.signingKeys(false)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	verified, err := jwt.VerifyWithHeaderValidator(nil, nil, []byte(idToken), keys.ValidateHeader)
+	if errors.Is(err, jwt.ErrUnknownKid) {
+		// the provider may have rotated its keys
+		if keys, err = p.signingKeys(true); err != nil {
+			return Identity{}, err
+		}
+		verified, err = jwt.VerifyWithHeaderValidator(nil, nil, []byte(idToken), keys.ValidateHeader)
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w : %s", ErrInvalidIDToken, err.Error())
+	}
+
+	standard := verified.StandardClaims
+	if standard.Issuer != md.Issuer || standard.Subject == "" || standard.Expiry == 0 || !contains(standard.Audience, p.config.ClientID) {
+		return Identity{}, ErrInvalidIDToken
+	}
+
+	var claims map[string]interface{}
+	if err := verified.Claims(&claims); err != nil {
+		return Identity{}, fmt.Errorf("%w : %s", ErrInvalidIDToken, err.Error())
+	}
+
+	if azp, ok := claims["azp"].(string); ok && azp != p.config.ClientID {
+		return Identity{}, ErrInvalidIDToken
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return Identity{}, ErrInvalidIDToken
+	}
+
+	identity := Identity{
+		Issuer:   standard.Issuer,
+		Subject:  standard.Subject,
+		Username: stringClaim(claims, p.config.UsernameClaim),
+		Email:    stringClaim(claims, p.config.EmailClaim),
+		Groups:   stringsClaim(claims, p.config.GroupsClaim),
+	}
+
+	switch verifiedEmail := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verifiedEmail
+	case string:
+		identity.EmailVerified = verifiedEmail == "true"
+	}
+
+	identity.MultiFactor = contains(stringsClaim(claims, "amr"), "mfa")
+
+	return identity, nil
+}
+
+// stringClaim returns a claim if it is a string.
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+
+	return value
+}
+
+// stringsClaim returns a claim holding a list of strings, or a single one.
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	default:
+		return nil
+	}
+}
+
+// contains tells if a value is in a list.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signingKeys returns the keys of the provider, fetching them the first time or when refresh is asked and they weren't fetched recently.
+func (p *Provider) signingKeys(refresh bool) (jwt.Keys, error) {
+	md, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && (!refresh || time.Since(p.keysFetchedAt) < keysRefreshInterval) {
+		return p.keys, nil
+	}
+
+	var set auth.JSONWebKeySet
+	if err := p.getJSON(md.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := jwt.Keys{}
+	for _, jwk := range set.Keys {
+		if key, err := parseJWK(jwk); err == nil {
+			keys[key.ID] = key
+		}
+	}
+
+	p.keys, p.keysFetchedAt = keys, time.Now()
+
+	return keys, nil
+}
+
+// algs are the algorithms of the keys we can verify tokens with.
+var algs = map[string]jwt.Alg{
+	"RS256": jwt.RS256, "RS384": jwt.RS384, "RS512": jwt.RS512,
+	"PS256": jwt.PS256, "PS384": jwt.PS384, "PS512": jwt.PS512,
+	"ES256": jwt.ES256, "ES384": jwt.ES384, "ES512": jwt.ES512,
+	"EdDSA": jwt.EdDSA,
+}
+
+// decode decodes a base64url encoded JWK field.
+func decode(field string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(field)
+}
+
+// parseJWK returns the key verifying signatures described by a JSON Web Key.
+func parseJWK(jwk auth.JSONWebKey) (*jwt.Key, error) {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, errors.New("not a signing key")
+	}
+
+	key := &jwt.Key{ID: jwk.Kid}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		key.Public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		key.Alg = jwt.RS256
+	case "EC":
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		curves := map[string]struct {
+			curve elliptic.Curve
+			alg   jwt.Alg
+		}{"P-256": {elliptic.P256(), jwt.ES256}, "P-384": {elliptic.P384(), jwt.ES384}, "P-521": {elliptic.P521(), jwt.ES512}}
+		curve, ok := curves[jwk.Crv]
+		if !ok {
+			return nil, errors.New("unsupported curve " + jwk.Crv)
+		}
+
+		key.Public = &ecdsa.PublicKey{Curve: curve.curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		key.Alg = curve.alg
+	case "OKP":
+		x, err := decode(jwk.X)
+		if err != nil || jwk.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("unsupported key")
+		}
+
+		key.Public = ed25519.PublicKey(x)
+		key.Alg = jwt.EdDSA
+	default:
+		return nil, errors.New("unsupported key type " + jwk.Kty)
+	}
+
+	if jwk.Alg != "" {
+		alg, ok := algs[jwk.Alg]
+		if !ok {
+			return nil, errors.New("unsupported algorithm " + jwk.Alg)
+		}
+		key.Alg = alg
+	}
+
+	return key, nil
+}
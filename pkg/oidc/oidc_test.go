@@ -0,0 +1,246 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kataras/jwt"
+	"github.com/mjehanno/welsh-academy/pkg/auth"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+)
+
+// grant is an authorization given by the fake provider, waiting to be exchanged.
+type grant struct {
+	challenge string
+	nonce     string
+}
+
+// fakeProvider is an in-process identity provider issuing ID tokens for cam-amber.
+type fakeProvider struct {
+	server *httptest.Server
+	keys   jwt.Keys
+	public *rsa.PublicKey
+	grants map[string]grant
+	// claims replacing the ones of the issued ID tokens
+	overrides jwt.Map
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error shouldn't have occured while generating the provider key")
+	}
+
+	fp := &fakeProvider{keys: jwt.Keys{}, public: &private.PublicKey, grants: map[string]grant{}, overrides: jwt.Map{}}
+	fp.keys.Register(jwt.RS256, "fake", &private.PublicKey, private)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(metadata{
+			Issuer:                fp.server.URL,
+			AuthorizationEndpoint: fp.server.URL + "/authorize",
+			TokenEndpoint:         fp.server.URL + "/token",
+			JWKSURI:               fp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(auth.JSONWebKeySet{Keys: []auth.JSONWebKey{{
+			Kty: "RSA", Use: "sig", Kid: "fake", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(fp.public.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(fp.public.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("client_id") != "welsh-academy" || query.Get("code_challenge_method") != "S256" || query.Get("response_type") != "code" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		fp.grants["code"] = grant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code=code&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		g, ok := fp.grants[r.PostFormValue("code")]
+		if id != "welsh-academy" || secret != "cheddar" || !ok || challenge(r.PostFormValue("code_verifier")) != g.challenge || r.PostFormValue("redirect_uri") != "https://welsh.academy/callback" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		delete(fp.grants, r.PostFormValue("code"))
+
+		claims := jwt.Map{
+			"iss":                fp.server.URL,
+			"sub":                "2f8e",
+			"aud":                "welsh-academy",
+			"nonce":              g.nonce,
+			"preferred_username": "cam-amber",
+			"email":              "cam-amber@welsh.academy",
+			"email_verified":     true,
+			"groups":             []string{"staff", "cheese-masters"},
+			"amr":                []string{"pwd", "mfa"},
+		}
+		for name, value := range fp.overrides {
+			claims[name] = value
+		}
+
+		token, err := fp.keys.SignToken("fake", claims, jwt.MaxAge(time.Minute))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": string(token)})
+	})
+	fp.server = httptest.NewServer(mux)
+
+	return fp
+}
+
+func (fp *fakeProvider) provider() *Provider {
+	return NewProvider(Configuration{
+		Issuer:       fp.server.URL,
+		ClientID:     "welsh-academy",
+		ClientSecret: "cheddar",
+		RedirectURL:  "https://welsh.academy/callback",
+		Roles:        []GroupRole{{Group: "cheese-masters", Role: user.CheddarExpert}, {Group: "staff", Role: user.Admin}},
+	}, fp.server.Client())
+}
+
+// authorize sends the user to the provider and returns the code and state he comes back with.
+func authorize(t *testing.T, fp *fakeProvider, request AuthorizationRequest) (string, string) {
+	client := fp.server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Get(request.URL)
+	if err != nil {
+		t.Fatalf("error shouldn't have occured while authorizing : %s", err.Error())
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirection to the callback, got %d", resp.StatusCode)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestLoginSucceed(t *testing.T) {
+	fp := newFakeProvider(t)
+	defer fp.server.Close()
+	provider := fp.provider()
+
+	request, err := provider.AuthorizationURL()
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	code, state := authorize(t, fp, request)
+	if state != request.State {
+		t.Errorf("expected the state to come back, got %s", state)
+	}
+
+	identity, err := provider.Exchange(code, request.Verifier, request.Nonce)
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if identity.Subject != "2f8e" || identity.Issuer != fp.server.URL || identity.Username != "cam-amber" || identity.Email != "cam-amber@welsh.academy" || !identity.EmailVerified || !identity.MultiFactor {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	if role := provider.RoleFor(identity.Groups); role != user.CheddarExpert {
+		t.Errorf("expected the first mapped group to give its role, got %s", role)
+	}
+}
+
+func TestExchangeFailOnWrongVerifier(t *testing.T) {
+	fp := newFakeProvider(t)
+	defer fp.server.Close()
+	provider := fp.provider()
+
+	request, _ := provider.AuthorizationURL()
+	code, _ := authorize(t, fp, request)
+
+	_, err := provider.Exchange(code, "stolen-code-without-verifier", request.Nonce)
+	if !errors.Is(err, ErrProvider) {
+		t.Errorf("expected a provider error, got %v", err)
+	}
+}
+
+func TestExchangeFailOnInvalidIDToken(t *testing.T) {
+	for name, overrides := range map[string]jwt.Map{
+		"other audience": {"aud": "another-client"},
+		"other issuer":   {"iss": "https://evil.example"},
+		"other nonce":    {"nonce": "replayed"},
+	} {
+		fp := newFakeProvider(t)
+		fp.overrides = overrides
+		provider := fp.provider()
+
+		request, _ := provider.AuthorizationURL()
+		code, _ := authorize(t, fp, request)
+
+		if _, err := provider.Exchange(code, request.Verifier, request.Nonce); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("expected an invalid ID token error for a token with an %s, got %v", name, err)
+		}
+
+		fp.server.Close()
+	}
+}
+
+func TestExchangeFailOnForgedIDToken(t *testing.T) {
+	fp := newFakeProvider(t)
+	defer fp.server.Close()
+	provider := fp.provider()
+
+	// the token is signed with a key the provider doesn't publish
+	forger, _ := rsa.GenerateKey(rand.Reader, 2048)
+	fp.keys.Register(jwt.RS256, "fake", fp.public, forger)
+
+	request, _ := provider.AuthorizationURL()
+	code, _ := authorize(t, fp, request)
+
+	if _, err := provider.Exchange(code, request.Verifier, request.Nonce); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("expected an invalid ID token error, got %v", err)
+	}
+}
+
+func TestAuthorizationURLFailOnIssuerMismatch(t *testing.T) {
+	fp := newFakeProvider(t)
+	defer fp.server.Close()
+
+	provider := NewProvider(Configuration{Issuer: fp.server.URL + "/", ClientID: "welsh-academy"}, fp.server.Client())
+
+	if _, err := provider.AuthorizationURL(); !errors.Is(err, ErrProvider) {
+		t.Errorf("expected a provider error, got %v", err)
+	}
+}
+
+func TestRoleFor(t *testing.T) {
+	provider := NewProvider(Configuration{Roles: []GroupRole{{Group: "staff", Role: user.Admin}}}, nil)
+
+	if role := provider.RoleFor([]string{"cheese-lovers", "staff"}); role != user.Admin {
+		t.Errorf("expected members of staff to be admins, got %s", role)
+	}
+
+	if role := provider.RoleFor([]string{"cheese-lovers"}); role != "" {
+		t.Errorf("expected no role for users without a mapped group, got %s", role)
+	}
+
+	if role := NewProvider(Configuration{}, nil).RoleFor([]string{"staff"}); role != "" {
+		t.Errorf("expected no role when no group is mapped, got %s", role)
+	}
+}
@@ -0,0 +1,103 @@
+package recipe
+
+import (
+	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+)
+
+// missingScore computes, for a recipe, the number of its mandatory ingredients that are not owned.
+const missingScore = "SUM(CASE WHEN recipe_ingredient.optional OR recipe_ingredient.ingredient_id IN ? THEN 0 ELSE 1 END)"
+
+// CookableQuery defines the ingredients a user owns and which page of cookable recipes should be returned.
+type CookableQuery struct {
+	// Owned lists the ingredients the user has at home.
+	Owned []ingredient.Ingredient
+	// MaxMissing filters out recipes missing more mandatory ingredients, a negative value disables the filter.
+	MaxMissing int
+	// Page starts at 1.
+	Page     int
+	PageSize int
+}
+
+// CookableResult is a recipe along with the mandatory ingredients missing to cook it.
+// @Description CookableResult is a recipe along with the mandatory ingredients missing to cook it.
+type CookableResult struct {
+	Recipe
+	// The number of mandatory ingredients missing
+	MissingCount int `example:"1"`
+	// The mandatory ingredients missing
+	MissingIngredients []string `example:"bière brune"`
+}
+
+type cookableScore struct {
+	RecipeID uint
+	Missing  int
+}
+
+// GetCookableRecipes takes a CookableQuery and returns a page of recipes ranked by how few mandatory ingredients are missing, along with the total number of matching recipes.
+// Optional ingredients are never considered missing.
+func (rs *RecipeService) GetCookableRecipes(query CookableQuery) ([]CookableResult, int64, error) {
+	var scores []cookableScore
+	var total int64
+
+	owned := ingredientIDs(query.Owned)
+
+	scoring := rs.db.Model(&RecipeIngredient{}).
+		Select("recipe_ingredient.recipe_id, "+missingScore+" AS missing", owned).
+		Joins("inner join recipes on recipes.id = recipe_ingredient.recipe_id AND recipes.deleted_at IS NULL").
+		Group("recipe_ingredient.recipe_id")
+	if query.MaxMissing >= 0 {
+		scoring = scoring.Having(missingScore+" <= ?", owned, query.MaxMissing)
+	}
+
+	if err := rs.db.Table("(?) AS scores", scoring).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := scoring.Order("missing, recipe_ingredient.recipe_id").Limit(query.PageSize).Offset((query.Page - 1) * query.PageSize).Scan(&scores).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(scores) == 0 {
+		return []CookableResult{}, total, nil
+	}
+
+	ids := make([]uint, len(scores))
+	for i, score := range scores {
+		ids[i] = score.RecipeID
+	}
+
+	var recipes []Recipe
+	if err := rs.db.Where("id IN ?", ids).Scopes(withDetails).Find(&recipes).Error; err != nil {
+		return nil, 0, err
+	}
+
+	byID := make(map[uint]Recipe, len(recipes))
+	for _, recipe := range recipes {
+		byID[recipe.ID] = recipe
+	}
+
+	ownedSet := make(map[uint]bool, len(owned))
+	for _, id := range owned {
+		ownedSet[id] = true
+	}
+
+	results := make([]CookableResult, 0, len(scores))
+	for _, score := range scores {
+		recipe, ok := byID[score.RecipeID]
+		if !ok {
+			continue
+		}
+
+		result := CookableResult{Recipe: recipe, MissingCount: score.Missing, MissingIngredients: []string{}}
+		for _, recipeIngredient := range recipe.Ingredients {
+			if !recipeIngredient.Optional && !ownedSet[recipeIngredient.IngredientID] {
+				result.MissingIngredients = append(result.MissingIngredients, recipeIngredient.Ingredient.Name)
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, total, nil
+}
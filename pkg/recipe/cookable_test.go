@@ -0,0 +1,68 @@
+package recipe
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+)
+
+func TestGetCookableRecipesSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM (SELECT recipe_ingredient.recipe_id, SUM(CASE WHEN recipe_ingredient.optional OR recipe_ingredient.ingredient_id IN ($1) THEN 0 ELSE 1 END) AS missing FROM "recipe_ingredient" inner join recipes on recipes.id = recipe_ingredient.recipe_id AND recipes.deleted_at IS NULL GROUP BY "recipe_ingredient"."recipe_id" HAVING SUM(CASE WHEN recipe_ingredient.optional OR recipe_ingredient.ingredient_id IN ($2) THEN 0 ELSE 1 END) <= $3) AS scores`)).WithArgs(1, 1, 2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT recipe_ingredient.recipe_id, SUM(CASE WHEN recipe_ingredient.optional OR recipe_ingredient.ingredient_id IN ($1) THEN 0 ELSE 1 END) AS missing FROM "recipe_ingredient" inner join recipes on recipes.id = recipe_ingredient.recipe_id AND recipes.deleted_at IS NULL GROUP BY "recipe_ingredient"."recipe_id" HAVING SUM(CASE WHEN recipe_ingredient.optional OR recipe_ingredient.ingredient_id IN ($2) THEN 0 ELSE 1 END) <= $3 ORDER BY missing, recipe_ingredient.recipe_id LIMIT 2`)).WithArgs(1, 1, 2).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "missing"}).AddRow(2, 1).AddRow(1, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE id IN ($1,$2) AND "recipes"."deleted_at" IS NULL`)).WithArgs(2, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "croque-monsieur").AddRow(2, "welsh"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" IN ($1,$2)`)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id", "optional"}).AddRow(1, 1, false).AddRow(1, 3, false).AddRow(1, 4, false).AddRow(2, 1, false).AddRow(2, 2, false).AddRow(2, 5, true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2,$3,$4,$5) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 3, 4, 2, 5).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune").AddRow(3, "pain").AddRow(4, "jambon").AddRow(5, "moutarde"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE "steps"."recipe_id" IN ($1,$2) AND "steps"."deleted_at" IS NULL ORDER BY position`)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "recipe_id", "position", "text"}))
+
+	results, total, err := recipeService.GetCookableRecipes(CookableQuery{Owned: []ingredient.Ingredient{cheddar}, MaxMissing: 2, Page: 1, PageSize: 2})
+	if err != nil {
+		t.Fatalf("an error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if total != 3 {
+		t.Errorf("expected 3 cookable recipes in total, got %d", total)
+	}
+
+	if len(results) != 2 || results[0].Name != "welsh" || results[1].Name != "croque-monsieur" {
+		t.Fatalf("expected welsh to be ranked before croque-monsieur, got %v", results)
+	}
+
+	if len(results[0].MissingIngredients) != 1 || results[0].MissingIngredients[0] != "bière brune" {
+		t.Errorf("expected only bière brune to be missing for welsh, optional moutarde shouldn't be listed, got %v", results[0].MissingIngredients)
+	}
+}
+
+func TestGetCookableRecipesSecondPage(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM (SELECT recipe_ingredient.recipe_id, SUM(CASE WHEN recipe_ingredient.optional OR recipe_ingredient.ingredient_id IN ($1) THEN 0 ELSE 1 END) AS missing FROM "recipe_ingredient" inner join recipes on recipes.id = recipe_ingredient.recipe_id AND recipes.deleted_at IS NULL GROUP BY "recipe_ingredient"."recipe_id") AS scores`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta(`GROUP BY "recipe_ingredient"."recipe_id" ORDER BY missing, recipe_ingredient.recipe_id LIMIT 2 OFFSET 2`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "missing"}))
+
+	results, total, err := recipeService.GetCookableRecipes(CookableQuery{Owned: []ingredient.Ingredient{cheddar}, MaxMissing: -1, Page: 2, PageSize: 2})
+	if err != nil {
+		t.Fatalf("an error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if total != 2 || len(results) != 0 {
+		t.Errorf("expected an empty page out of 2 recipes, got %d results out of %d", len(results), total)
+	}
+}
+
+func TestGetCookableRecipesFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM (SELECT recipe_ingredient.recipe_id`)).WillReturnError(fmt.Errorf("connection lost"))
+
+	_, _, err := recipeService.GetCookableRecipes(CookableQuery{Owned: []ingredient.Ingredient{cheddar}, MaxMissing: -1, Page: 1, PageSize: 20})
+	if err == nil {
+		t.Error("an error did not occured while it should have")
+	}
+}
@@ -1,18 +1,81 @@
 package recipe
 
 import (
+	"strings"
+	"time"
+
 	"github.com/mjehanno/welsh-academy/pkg/ingredient"
 	"gorm.io/gorm"
 )
 
+// DeletedAuthor is shown instead of the username of the authors who were anonymised when their account was deleted.
+const DeletedAuthor = "deleted user"
+
+// AnonymisedUsernamePrefix starts the username given to anonymised users, followed by their id.
+const AnonymisedUsernamePrefix = "deleted-user-"
+
 // swagger:model Recipe
 // Recipe define a meal made with ingredients.
 type Recipe struct {
 	gorm.Model
 	// The name of the Recipe
 	Name string `example:"welsh" gorm:"unique;not null; default:null"`
+	// The number of people the recipe feeds.
+	Servings uint `example:"4" gorm:"not null;default:4"`
 	// The list of ingredients in the recipe.
-	Ingredients []*ingredient.Ingredient `gorm:"many2many:recipe_ingredient;"`
+	Ingredients []RecipeIngredient
+	// The ordered list of steps to cook the recipe.
+	Steps []Step
+	// The user who created the recipe, set from the logged user when it's created
+	AuthorID uint    `gorm:"index;default:null" json:"-" swaggerignore:"true"`
+	Author   *Author `gorm:"constraint:OnUpdate:CASCADE,OnDelete:RESTRICT" json:",omitempty"`
+}
+
+// Author is the user who created a recipe, it's read from the users table.
+// @Description Author is the user who created a recipe.
+type Author struct {
+	ID uint `gorm:"primarykey" json:"-"`
+	// The username of the author, or "deleted user" if he was anonymised
+	Username string `gorm:"type:varchar(40)" example:"cam-amber"`
+	// When the author deleted his account
+	DeletedAt *time.Time `json:"-"`
+}
+
+// TableName makes Author read the users table.
+func (Author) TableName() string {
+	return "users"
+}
+
+// AfterFind hides the username of anonymised authors.
+func (a *Author) AfterFind(tx *gorm.DB) error {
+	if a.DeletedAt != nil && strings.HasPrefix(a.Username, AnonymisedUsernamePrefix) {
+		a.Username = DeletedAuthor
+	}
+
+	return nil
+}
+
+// RecipeIngredient defines how much of an ingredient is used in a recipe, it's stored in the recipe_ingredient join table.
+// @Description RecipeIngredient defines how much of an ingredient is used in a recipe.
+type RecipeIngredient struct {
+	// The recipe using the ingredient
+	RecipeID uint `gorm:"primaryKey" swaggerignore:"true"`
+	// The ingredient used in the recipe
+	IngredientID uint `gorm:"primaryKey" example:"1"`
+	Ingredient   ingredient.Ingredient
+	// The amount of ingredient needed
+	Quantity float64 `example:"250"`
+	// The unit in which the quantity is expressed
+	Unit string `gorm:"size:20" example:"g"`
+	// How the ingredient should be prepared
+	Note string `example:"grated"`
+	// Whether the recipe can be made without this ingredient
+	Optional bool `example:"false"`
+}
+
+// TableName overrides the table name used by RecipeIngredient so it keeps using the recipe_ingredient join table.
+func (RecipeIngredient) TableName() string {
+	return "recipe_ingredient"
 }
 
 // RecipeService define a service made to handle recipes.
@@ -27,52 +90,118 @@ func NewRecipeService(db *gorm.DB) *RecipeService {
 	}
 }
 
+// withDetails preloads everything a recipe is made of : its author, its ingredients and its ordered steps.
+func withDetails(db *gorm.DB) *gorm.DB {
+	return db.Preload("Author", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "username", "deleted_at")
+	}).Preload("Ingredients.Ingredient").Preload("Steps", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Preload("Steps.Ingredients")
+}
+
 // GetAllRecipe returns all recipe.
 func (rs *RecipeService) GetAllRecipe() ([]Recipe, error) {
 	var recipe []Recipe
 
-	result := rs.db.Model(&Recipe{}).Preload("Ingredients").Find(&recipe)
+	result := rs.db.Model(&Recipe{}).Scopes(withDetails).Find(&recipe)
+
+	return recipe, result.Error
+}
+
+// CreateRecipe takes a recipe object and insert it to DB, returning it's new ID or an error.
+// Ingredients are expected to already exist, only their quantities are inserted in the recipe_ingredient table along with the recipe steps.
+// The author is only referenced by AuthorID.
+func (rs *RecipeService) CreateRecipe(recipe Recipe) (uint, error) {
+	result := rs.db.Omit("Author", "Ingredients.Ingredient", "Steps.Ingredients.*").Create(&recipe)
+
+	return recipe.ID, result.Error
+}
+
+// GetRecipeById takes a recipe ID and returns the corresponding recipe with its ingredients and steps or an error.
+func (rs *RecipeService) GetRecipeById(recipeID uint) (Recipe, error) {
+	var recipe Recipe
+	result := rs.db.Where("id = ?", recipeID).Scopes(withDetails).First(&recipe)
 
 	return recipe, result.Error
 }
 
-// GetRecipeByIngredient takes a list of ingredients and returns only the recipe that contains ALL the listed ingredients or an error.
-func (rs *RecipeService) GetRecipeByIngredient(ingredients []ingredient.Ingredient) ([]Recipe, error) {
-	var recipes []Recipe
+// UpdateRecipe takes a recipe and replaces the name, ingredients and steps of the stored recipe with the same ID in one transaction.
+// Ingredients are expected to already exist.
+func (rs *RecipeService) UpdateRecipe(recipe Recipe) error {
+	return rs.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Recipe{}).Where("id = ?", recipe.ID).Updates(Recipe{Name: recipe.Name, Servings: recipe.Servings})
+		if result.Error != nil {
+			return result.Error
+		}
 
-	query := rs.db.Table("recipes")
-	for i := range ingredients {
-		tableAlias := ""
-		for j := 0; j < i+1; j++ {
-			tableAlias += "i"
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
 		}
-		query.Joins("inner join recipe_ingredient r" + tableAlias + " on r" + tableAlias + ".recipe_id = recipes.id")
-		query.Joins("inner join ingredients " + tableAlias + " on r" + tableAlias + ".ingredient_id = " + tableAlias + ".id")
-	}
 
-	for i, ing := range ingredients {
-		tableAlias := ""
-		for j := 0; j < i+1; j++ {
-			tableAlias += "i"
+		if err := tx.Where("recipe_id = ?", recipe.ID).Delete(&RecipeIngredient{}).Error; err != nil {
+			return err
+		}
+
+		for i := range recipe.Ingredients {
+			recipe.Ingredients[i].RecipeID = recipe.ID
+		}
+
+		if len(recipe.Ingredients) > 0 {
+			if err := tx.Omit("Ingredient").Create(&recipe.Ingredients).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("recipe_id = ?", recipe.ID).Delete(&Step{}).Error; err != nil {
+			return err
+		}
+
+		for i := range recipe.Steps {
+			recipe.Steps[i].ID = 0
+			recipe.Steps[i].RecipeID = recipe.ID
+		}
+
+		if len(recipe.Steps) > 0 {
+			return tx.Omit("Ingredients.*").Create(&recipe.Steps).Error
 		}
-		query.Where(tableAlias+".id=?", ing.ID)
-	}
 
-	result := query.Preload("Ingredients").Find(&recipes)
-	return recipes, result.Error
+		return nil
+	})
 }
 
-// CreateRecipe takes a recipe object and insert it to DB, returning it's new ID or an error.
-func (rs *RecipeService) CreateRecipe(recipe Recipe) (uint, error) {
-	result := rs.db.Create(&recipe)
+// DeleteRecipe takes a recipe ID and soft deletes the corresponding recipe, returning gorm.ErrRecordNotFound if it doesn't exist.
+func (rs *RecipeService) DeleteRecipe(recipeID uint) error {
+	result := rs.db.Delete(&Recipe{}, recipeID)
+	if result.Error != nil {
+		return result.Error
+	}
 
-	return recipe.ID, result.Error
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
 }
 
-// GetRecipeById takes a recipe ID and returns the corresponding recipe or an error.
-func (rs *RecipeService) GetRecipeById(recipeID uint) (Recipe, error) {
+// GetRecipeAuthor takes a recipe ID and returns the ID of its author, 0 if it has none, or gorm.ErrRecordNotFound if the recipe doesn't exist.
+func (rs *RecipeService) GetRecipeAuthor(recipeID uint) (uint, error) {
 	var recipe Recipe
-	result := rs.db.Where("id = ?", recipeID).Find(&recipe)
+	result := rs.db.Select("id", "author_id").Where("id = ?", recipeID).First(&recipe)
 
-	return recipe, result.Error
+	return recipe.AuthorID, result.Error
+}
+
+// SetMissingAuthor gives an author to every recipe created before authors were recorded, returning how many recipes were updated.
+func (rs *RecipeService) SetMissingAuthor(authorID uint) (int64, error) {
+	result := rs.db.Model(&Recipe{}).Unscoped().Where("author_id IS NULL").UpdateColumn("author_id", authorID)
+
+	return result.RowsAffected, result.Error
+}
+
+// GetRecipeIngredients takes a recipe ID and returns the ingredients it uses along with their quantities.
+func (rs *RecipeService) GetRecipeIngredients(recipeID uint) ([]RecipeIngredient, error) {
+	var recipeIngredients []RecipeIngredient
+	result := rs.db.Where("recipe_id = ?", recipeID).Preload("Ingredient").Find(&recipeIngredients)
+
+	return recipeIngredients, result.Error
 }
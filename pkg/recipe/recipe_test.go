@@ -2,12 +2,13 @@ package recipe
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/mjehanno/welsh-academy/pkg/ingredient"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -56,6 +57,9 @@ func TestGetAllRecipe(t *testing.T) {
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE "recipes"."deleted_at" IS NULL`)).WillReturnRows(rows)
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" IN ($1,$2,$3)`)).WithArgs(1, 2, 3).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id"}).AddRow(1, 1).AddRow(1, 2).AddRow(1, 3).AddRow(2, 4).AddRow(3, 4))
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2,$3,$4) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 2, 3, 4).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune").AddRow(3, "pain").AddRow(4, "reblochon"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE "steps"."recipe_id" IN ($1,$2,$3) AND "steps"."deleted_at" IS NULL ORDER BY position`)).WithArgs(1, 2, 3).WillReturnRows(sqlmock.NewRows([]string{"id", "recipe_id", "position", "text"}).AddRow(1, 1, 1, "Melt the cheddar in the beer.").AddRow(2, 1, 2, "Pour it on the bread."))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "step_ingredient" WHERE "step_ingredient"."step_id" IN ($1,$2)`)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"step_id", "ingredient_id"}).AddRow(1, 1).AddRow(1, 2).AddRow(2, 3))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2,$3) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 2, 3).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune").AddRow(3, "pain"))
 
 	_, err := recipeService.GetAllRecipe()
 	if err != nil {
@@ -64,98 +68,243 @@ func TestGetAllRecipe(t *testing.T) {
 
 }
 
-func TestGetRecipeByIngredientSucceed(t *testing.T) {
+func TestCreateRecipeSucceed(t *testing.T) {
 	tearDown := Setup(t)
 	defer tearDown(t)
 
-	rows := mock.NewRows([]string{"id", "name"}).AddRow(1, "welsh")
+	any := sqlmock.AnyArg()
 
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "recipes"."id","recipes"."created_at","recipes"."updated_at","recipes"."deleted_at","recipes"."name" FROM "recipes" inner join recipe_ingredient ri on ri.recipe_id = recipes.id inner join ingredients i on ri.ingredient_id = i.id inner join recipe_ingredient rii on rii.recipe_id = recipes.id inner join ingredients ii on rii.ingredient_id = ii.id WHERE i.id=$1 AND ii.id=$2 AND "recipes"."deleted_at" IS NULL`)).WithArgs(0, 0).WillReturnRows(rows)
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id"}).AddRow(1, 1).AddRow(1, 2).AddRow(1, 3))
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2,$3) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 2, 3).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune").AddRow(3, "pain"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "recipes" ("created_at","updated_at","deleted_at","servings","name") VALUES ($1,$2,$3,$4,$5) RETURNING "id","name"`)).WithArgs(any, any, any, 4, "welsh").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "recipe_ingredient" ("recipe_id","ingredient_id","quantity","unit","note","optional") VALUES ($1,$2,$3,$4,$5,$6),($7,$8,$9,$10,$11,$12),($13,$14,$15,$16,$17,$18) ON CONFLICT ("recipe_id","ingredient_id") DO UPDATE SET "recipe_id"="excluded"."recipe_id"`)).WithArgs(1, 1, 250.0, "g", "grated", false, 1, 2, 25.0, "cl", "", false, 1, 3, 4.0, "", "toasted", false).WillReturnResult(sqlmock.NewResult(1, 3))
+	mock.ExpectCommit()
+
+	_, err := recipeService.CreateRecipe(Recipe{Name: "welsh", Ingredients: []RecipeIngredient{
+		{IngredientID: 1, Quantity: 250, Unit: "g", Note: "grated"},
+		{IngredientID: 2, Quantity: 25, Unit: "cl"},
+		{IngredientID: 3, Quantity: 4, Note: "toasted"},
+	}})
 
-	_, err := recipeService.GetRecipeByIngredient([]ingredient.Ingredient{{Name: "cheddar"}, {Name: "bière brune"}})
 	if err != nil {
 		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
 	}
 }
 
-func TestGetRecipeByIngredientFail(t *testing.T) {
+func TestCreateRecipeFail(t *testing.T) {
 	tearDown := Setup(t)
 	defer tearDown(t)
 
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "recipes"."id","recipes"."created_at","recipes"."updated_at","recipes"."deleted_at","recipes"."name" FROM "recipes" inner join recipe_ingredient ri on ri.recipe_id = recipes.id inner join ingredients i on ri.ingredient_id = i.id inner join recipe_ingredient rii on rii.recipe_id = recipes.id inner join ingredients ii on rii.ingredient_id = ii.id WHERE i.id=$1 AND ii.id=$2 AND "recipes"."deleted_at" IS NULL`)).WithArgs(0, 0).WillReturnError(fmt.Errorf("no record found"))
+	any := sqlmock.AnyArg()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "recipes" ("created_at","updated_at","deleted_at","servings","name") VALUES ($1,$2,$3,$4,$5) RETURNING "id","name"`)).WithArgs(any, any, any, 4, "welsh").WillReturnError(fmt.Errorf("recipe already exist"))
+	mock.ExpectRollback()
+
+	_, err := recipeService.CreateRecipe(Recipe{Name: "welsh", Ingredients: []RecipeIngredient{
+		{IngredientID: 1, Quantity: 250, Unit: "g", Note: "grated"},
+		{IngredientID: 2, Quantity: 25, Unit: "cl"},
+		{IngredientID: 3, Quantity: 4, Note: "toasted"},
+	}})
 
-	_, err := recipeService.GetRecipeByIngredient([]ingredient.Ingredient{{Name: "cheddar"}, {Name: "bière brune"}})
 	if err == nil {
 		t.Error("an error did not occured while it should have")
 	}
 }
 
-func TestCreateRecipeSucceed(t *testing.T) {
+func TestGetRecipeByIdSucceed(t *testing.T) {
 	tearDown := Setup(t)
 	defer tearDown(t)
 
-	any := sqlmock.AnyArg()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE id = $1 AND "recipes"."deleted_at" IS NULL ORDER BY "recipes"."id" LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id", "quantity", "unit"}).AddRow(1, 1, 250, "g"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" = $1 AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE "steps"."recipe_id" = $1 AND "steps"."deleted_at" IS NULL ORDER BY position`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "recipe_id", "position", "text"}))
 
-	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "recipes" ("created_at","updated_at","deleted_at","name") VALUES ($1,$2,$3,$4) RETURNING "id","name"`)).WithArgs(any, any, any, "welsh").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "ingredients" ("created_at","updated_at","deleted_at","name") VALUES ($1,$2,$3,$4),($5,$6,$7,$8),($9,$10,$11,$12) ON CONFLICT DO NOTHING RETURNING "id","name"`)).WithArgs(any, any, any, "cheddar", any, any, any, "bière brune", any, any, any, "pain").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune").AddRow(3, "pain"))
-	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "recipe_ingredient" ("recipe_id","ingredient_id") VALUES ($1,$2),($3,$4),($5,$6) ON CONFLICT DO NOTHING`)).WithArgs(1, 0, 1, 0, 1, 0).WillReturnResult(sqlmock.NewResult(1, 3))
-	mock.ExpectCommit()
+	recipe, err := recipeService.GetRecipeById(1)
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
 
-	_, err := recipeService.CreateRecipe(Recipe{Name: "welsh", Ingredients: []*ingredient.Ingredient{
-		{Name: "cheddar"},
-		{Name: "bière brune"},
-		{Name: "pain"},
-	}})
+	if len(recipe.Ingredients) != 1 || recipe.Ingredients[0].Ingredient.Name != "cheddar" {
+		t.Errorf("expected the recipe ingredients to be loaded, got %v", recipe.Ingredients)
+	}
+}
+
+func TestGetRecipeByIdFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE id = $1 AND "recipes"."deleted_at" IS NULL ORDER BY "recipes"."id" LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	_, err := recipeService.GetRecipeById(1)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
 
+func TestGetRecipeIngredientsSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE recipe_id = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id", "quantity", "unit"}).AddRow(1, 1, 250, "g").AddRow(1, 2, 25, "cl"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune"))
+
+	recipeIngredients, err := recipeService.GetRecipeIngredients(1)
 	if err != nil {
 		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
 	}
+
+	if len(recipeIngredients) != 2 || recipeIngredients[0].Ingredient.Name != "cheddar" {
+		t.Errorf("expected cheddar and bière brune, got %v", recipeIngredients)
+	}
 }
 
-func TestCreateRecipeFail(t *testing.T) {
+func TestGetRecipeIngredientsFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE recipe_id = $1`)).WithArgs(1).WillReturnError(fmt.Errorf("connection lost"))
+
+	_, err := recipeService.GetRecipeIngredients(1)
+	if err == nil {
+		t.Error("an error did not occured while it should have")
+	}
+}
+
+func TestUpdateRecipeSucceed(t *testing.T) {
 	tearDown := Setup(t)
 	defer tearDown(t)
 
 	any := sqlmock.AnyArg()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "recipes" ("created_at","updated_at","deleted_at","name") VALUES ($1,$2,$3,$4) RETURNING "id","name"`)).WithArgs(any, any, any, "welsh").WillReturnError(fmt.Errorf("recipe already exist"))
-	mock.ExpectRollback()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "recipes" SET "updated_at"=$1,"name"=$2,"servings"=$3 WHERE id = $4 AND "recipes"."deleted_at" IS NULL`)).WithArgs(any, "welsh rarebit", 2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "recipe_ingredient" WHERE recipe_id = $1`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "recipe_ingredient" ("recipe_id","ingredient_id","quantity","unit","note","optional") VALUES ($1,$2,$3,$4,$5,$6),($7,$8,$9,$10,$11,$12)`)).WithArgs(1, 1, 300.0, "g", "", false, 1, 2, 25.0, "cl", "", false).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "steps" SET "deleted_at"=$1 WHERE recipe_id = $2 AND "steps"."deleted_at" IS NULL`)).WithArgs(any, 1).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "steps" ("created_at","updated_at","deleted_at","recipe_id","position","duration","text") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id","text"`)).WithArgs(any, any, any, 1, 1, 0, "Melt everything.").WillReturnRows(sqlmock.NewRows([]string{"id", "text"}).AddRow(3, "Melt everything."))
+	mock.ExpectCommit()
 
-	_, err := recipeService.CreateRecipe(Recipe{Name: "welsh", Ingredients: []*ingredient.Ingredient{
-		{Name: "cheddar"},
-		{Name: "bière brune"},
-		{Name: "pain"},
-	}})
+	err := recipeService.UpdateRecipe(Recipe{
+		Model:    gorm.Model{ID: 1},
+		Name:     "welsh rarebit",
+		Servings: 2,
+		Ingredients: []RecipeIngredient{
+			{IngredientID: 1, Quantity: 300, Unit: "g"},
+			{IngredientID: 2, Quantity: 25, Unit: "cl"},
+		},
+		Steps: []Step{{Model: gorm.Model{ID: 1}, Position: 1, Text: "Melt everything."}},
+	})
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+}
 
-	if err == nil {
-		t.Error("an error did not occured while it should have")
+func TestUpdateRecipeFailOnUnknownRecipe(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "recipes" SET "updated_at"=$1,"name"=$2 WHERE id = $3 AND "recipes"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), "raclette", 4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err := recipeService.UpdateRecipe(Recipe{Model: gorm.Model{ID: 4}, Name: "raclette"})
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
 	}
 }
 
-func TestGetRecipeByIdSucceed(t *testing.T) {
+func TestDeleteRecipeSucceed(t *testing.T) {
 	tearDown := Setup(t)
 	defer tearDown(t)
 
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE id = $1 AND "recipes"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "recipes" SET "deleted_at"=$1 WHERE "recipes"."id" = $2 AND "recipes"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
 
-	_, err := recipeService.GetRecipeById(1)
+	err := recipeService.DeleteRecipe(1)
 	if err != nil {
 		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
 	}
 }
 
-func TestGetRecipeByIdFail(t *testing.T) {
+func TestDeleteRecipeFail(t *testing.T) {
 	tearDown := Setup(t)
 	defer tearDown(t)
 
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE id = $1 AND "recipes"."deleted_at" IS NULL`)).WithArgs(1).WillReturnError(fmt.Errorf("record not found"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "recipes" SET "deleted_at"=$1 WHERE "recipes"."id" = $2 AND "recipes"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
 
-	_, err := recipeService.GetRecipeById(1)
-	if err == nil {
-		t.Error("an error did not occured while it should have")
+	err := recipeService.DeleteRecipe(4)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestGetRecipeByIdSucceedWithAuthors(t *testing.T) {
+	for username, expected := range map[string]string{"cam-amber": "cam-amber", "deleted-user-3": DeletedAuthor} {
+		tearDown := Setup(t)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE id = $1 AND "recipes"."deleted_at" IS NULL ORDER BY "recipes"."id" LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "author_id"}).AddRow(1, "welsh", 3))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","username","deleted_at" FROM "users" WHERE "users"."id" = $1`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "username", "deleted_at"}).AddRow(3, username, time.Now()))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE "steps"."recipe_id" = $1 AND "steps"."deleted_at" IS NULL ORDER BY position`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "recipe_id"}))
+
+		recipe, err := recipeService.GetRecipeById(1)
+		if err != nil {
+			t.Errorf("error occured while it shouldn't have : %s", err)
+		}
+
+		if recipe.Author == nil || recipe.Author.Username != expected {
+			t.Errorf("expected the recipe to be attributed to %s, got %+v", expected, recipe.Author)
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestGetRecipeAuthorSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","author_id" FROM "recipes" WHERE id = $1 AND "recipes"."deleted_at" IS NULL ORDER BY "recipes"."id" LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "author_id"}).AddRow(1, 3))
+
+	authorID, err := recipeService.GetRecipeAuthor(1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+
+	if authorID != 3 {
+		t.Errorf("expected the author 3, got %d", authorID)
+	}
+}
+
+func TestGetRecipeAuthorFailOnUnknownRecipe(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","author_id" FROM "recipes" WHERE id = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "author_id"}))
+
+	if _, err := recipeService.GetRecipeAuthor(1); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected %s, got %v", gorm.ErrRecordNotFound, err)
+	}
+}
+
+func TestSetMissingAuthorSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "recipes" SET "author_id"=$1 WHERE author_id IS NULL`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectCommit()
+
+	count, err := recipeService.SetMissingAuthor(1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+
+	if count != 12 {
+		t.Errorf("expected 12 recipes to be attributed, got %d", count)
 	}
 }
@@ -0,0 +1,102 @@
+package recipe
+
+import (
+	"math"
+	"strings"
+
+	"github.com/mjehanno/welsh-academy/pkg/units"
+)
+
+// roundingSteps defines, for grams, millilitres and smaller units, the precision of a rounded quantity depending on its size.
+var roundingSteps = []struct {
+	below float64
+	step  float64
+}{
+	{below: 10, step: 0.5},
+	{below: 100, step: 1},
+	{below: math.Inf(1), step: 5},
+}
+
+// roundTo rounds a quantity to a multiple of step, a quantity smaller than step keeping two significant digits instead.
+func roundTo(quantity float64, step float64) float64 {
+	if math.Abs(quantity) < step {
+		magnitude := math.Pow(10, 2-math.Ceil(math.Log10(math.Abs(quantity))))
+
+		return math.Round(quantity*magnitude) / magnitude
+	}
+
+	return math.Round(quantity/step) * step
+}
+
+// RoundQuantity takes a quantity and its unit and rounds it to a precision a cook can actually measure.
+// Countable ingredients (without unit, like eggs) are rounded to whole numbers, grams and millilitres depending on their size,
+// bigger units like kilograms, spoons or cups to the quarter and unknown units to the hundredth.
+// A quantity smaller than that precision keeps two significant digits, so that it's neither rounded to 0 nor overstated.
+func RoundQuantity(quantity float64, unit string) float64 {
+	if quantity == 0 {
+		return 0
+	}
+
+	if strings.TrimSpace(unit) == "" {
+		return roundTo(quantity, 1)
+	}
+
+	known, ok := units.Lookup(unit)
+	if !ok {
+		return roundTo(quantity, 0.01)
+	}
+
+	if known.Factor > 1 {
+		return roundTo(quantity, 0.25)
+	}
+
+	for _, rounding := range roundingSteps {
+		if quantity < rounding.below {
+			return roundTo(quantity, rounding.step)
+		}
+	}
+
+	return quantity
+}
+
+// Scale returns a copy of the recipe with every ingredient quantity adapted to feed the given number of servings.
+// The recipe is returned unchanged if servings or the recipe servings is 0.
+func (r Recipe) Scale(servings uint) Recipe {
+	if servings == 0 || r.Servings == 0 || servings == r.Servings {
+		return r
+	}
+
+	factor := float64(servings) / float64(r.Servings)
+
+	ingredients := make([]RecipeIngredient, len(r.Ingredients))
+	for i, recipeIngredient := range r.Ingredients {
+		recipeIngredient.Quantity = RoundQuantity(recipeIngredient.Quantity*factor, recipeIngredient.Unit)
+		ingredients[i] = recipeIngredient
+	}
+
+	r.Ingredients = ingredients
+	r.Servings = servings
+
+	return r
+}
+
+// ConvertUnits returns a copy of the recipe with every ingredient quantity expressed in the units of the given system.
+// Quantities in units that aren't known are kept as is, the recipe is returned unchanged if system is empty.
+func (r Recipe) ConvertUnits(system units.System) Recipe {
+	if system == "" {
+		return r
+	}
+
+	ingredients := make([]RecipeIngredient, len(r.Ingredients))
+	for i, recipeIngredient := range r.Ingredients {
+		if recipeIngredient.Quantity != 0 {
+			quantity, unit := units.ToSystem(recipeIngredient.Quantity, recipeIngredient.Unit, system)
+			recipeIngredient.Quantity, recipeIngredient.Unit = RoundQuantity(quantity, unit), unit
+		}
+		ingredients[i] = recipeIngredient
+	}
+
+	r.Ingredients = ingredients
+
+	return r
+}
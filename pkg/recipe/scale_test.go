@@ -0,0 +1,114 @@
+package recipe
+
+import (
+	"testing"
+
+	"github.com/mjehanno/welsh-academy/pkg/units"
+)
+
+func TestRoundQuantity(t *testing.T) {
+	cases := []struct {
+		quantity float64
+		unit     string
+		expected float64
+	}{
+		{quantity: 1.5, unit: "", expected: 2},
+		{quantity: 0.3, unit: "", expected: 0.3},
+		{quantity: 3.3, unit: "g", expected: 3.5},
+		{quantity: 62.4, unit: "g", expected: 62},
+		{quantity: 312.5, unit: "g", expected: 315},
+		{quantity: 0.1, unit: "ml", expected: 0.1},
+		{quantity: 0.0705479, unit: "oz", expected: 0.071},
+		{quantity: 1.1, unit: "tbsp", expected: 1},
+		{quantity: 0.6, unit: "kg", expected: 0.5},
+		{quantity: 1.234, unit: "handful", expected: 1.23},
+		{quantity: 0, unit: "g", expected: 0},
+	}
+
+	for _, c := range cases {
+		if rounded := RoundQuantity(c.quantity, c.unit); rounded != c.expected {
+			t.Errorf("expected %v %s to be rounded to %v, got %v", c.quantity, c.unit, c.expected, rounded)
+		}
+	}
+}
+
+func TestScaleSucceed(t *testing.T) {
+	original := Recipe{Name: "welsh", Servings: 4, Ingredients: []RecipeIngredient{
+		{IngredientID: 1, Quantity: 250, Unit: "g"},
+		{IngredientID: 2, Quantity: 25, Unit: "cl"},
+		{IngredientID: 3, Quantity: 3},
+	}}
+
+	scaled := original.Scale(2)
+
+	if scaled.Servings != 2 {
+		t.Errorf("expected 2 servings, got %d", scaled.Servings)
+	}
+
+	expected := []float64{125, 12.5, 2}
+	for i, recipeIngredient := range scaled.Ingredients {
+		if recipeIngredient.Quantity != expected[i] {
+			t.Errorf("expected quantity %v for ingredient %d, got %v", expected[i], recipeIngredient.IngredientID, recipeIngredient.Quantity)
+		}
+	}
+
+	if original.Ingredients[0].Quantity != 250 {
+		t.Error("scaling a recipe shouldn't modify the original one")
+	}
+}
+
+func TestScaleWithoutServingsFail(t *testing.T) {
+	original := Recipe{Name: "welsh", Ingredients: []RecipeIngredient{{IngredientID: 1, Quantity: 250, Unit: "g"}}}
+
+	scaled := original.Scale(2)
+
+	if scaled.Ingredients[0].Quantity != 250 || scaled.Servings != 0 {
+		t.Error("a recipe without servings shouldn't be scaled")
+	}
+}
+
+func TestConvertUnits(t *testing.T) {
+	original := Recipe{Name: "welsh", Servings: 4, Ingredients: []RecipeIngredient{
+		{IngredientID: 1, Quantity: 250, Unit: "g"},
+		{IngredientID: 2, Quantity: 25, Unit: "cl"},
+		{IngredientID: 3, Quantity: 4, Unit: "slices"},
+	}}
+
+	converted := original.ConvertUnits(units.Imperial)
+
+	expected := []RecipeIngredient{{Quantity: 8.75, Unit: "oz"}, {Quantity: 1, Unit: "cup"}, {Quantity: 4, Unit: "slices"}}
+	for i, recipeIngredient := range converted.Ingredients {
+		if recipeIngredient.Quantity != expected[i].Quantity || recipeIngredient.Unit != expected[i].Unit {
+			t.Errorf("expected %v %s, got %v %s", expected[i].Quantity, expected[i].Unit, recipeIngredient.Quantity, recipeIngredient.Unit)
+		}
+	}
+
+	if original.Ingredients[0].Unit != "g" {
+		t.Error("converting a recipe shouldn't modify the original one")
+	}
+}
+
+func TestConvertUnitsKeepsSmallQuantities(t *testing.T) {
+	cases := []struct {
+		grams    float64
+		system   units.System
+		expected float64
+		unit     string
+	}{
+		{grams: 1, system: units.Metric, expected: 1, unit: "g"},
+		{grams: 2, system: units.Metric, expected: 2, unit: "g"},
+		{grams: 5, system: units.Metric, expected: 5, unit: "g"},
+		{grams: 1, system: units.Imperial, expected: 0.035, unit: "oz"},
+		{grams: 2, system: units.Imperial, expected: 0.071, unit: "oz"},
+		{grams: 5, system: units.Imperial, expected: 0.18, unit: "oz"},
+	}
+
+	for _, c := range cases {
+		original := Recipe{Name: "welsh", Ingredients: []RecipeIngredient{{IngredientID: 4, Quantity: c.grams, Unit: "g"}}}
+
+		converted := original.ConvertUnits(c.system).Ingredients[0]
+		if converted.Quantity != c.expected || converted.Unit != c.unit {
+			t.Errorf("expected %v g to be %v %s in %s, got %v %s", c.grams, c.expected, c.unit, c.system, converted.Quantity, converted.Unit)
+		}
+	}
+}
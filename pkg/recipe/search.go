@@ -0,0 +1,139 @@
+package recipe
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+)
+
+// MatchMode defines how the ingredients of a search are matched against recipes.
+type MatchMode string
+
+const (
+	// MatchAll keeps recipes containing every searched ingredient.
+	MatchAll MatchMode = "all"
+	// MatchAny keeps recipes containing at least one of the searched ingredients.
+	MatchAny MatchMode = "any"
+	// MatchNone keeps recipes containing none of the searched ingredients.
+	MatchNone MatchMode = "none"
+)
+
+// ErrUnknownMatchMode is returned when a search uses a match mode that doesn't exist.
+var ErrUnknownMatchMode = errors.New("match mode must be one of all, any or none")
+
+// ParseMatchMode takes a match mode as sent by a user and returns the corresponding MatchMode, defaulting to MatchAll.
+func ParseMatchMode(mode string) (MatchMode, error) {
+	switch MatchMode(mode) {
+	case "":
+		return MatchAll, nil
+	case MatchAll, MatchAny, MatchNone:
+		return MatchMode(mode), nil
+	}
+
+	return "", ErrUnknownMatchMode
+}
+
+// SearchQuery defines which recipes a search should return.
+type SearchQuery struct {
+	// Ingredients are matched against the recipes according to Match.
+	Ingredients []ingredient.Ingredient
+	// Exclude lists ingredients that mustn't be in any returned recipe, whatever the match mode is.
+	Exclude []ingredient.Ingredient
+	Match   MatchMode
+	// Author only keeps the recipes created by the user having this username when it isn't empty.
+	Author string
+}
+
+// SearchResult is a recipe returned by a search along with the searched ingredients it contains.
+// @Description SearchResult is a recipe returned by a search along with the searched ingredients it contains.
+type SearchResult struct {
+	Recipe
+	// The searched ingredients used in the recipe
+	MatchedIngredients []string `json:",omitempty" example:"cheddar"`
+}
+
+// distinctIngredients returns the ingredients without the ones listed more than once, keeping their first occurrence.
+func distinctIngredients(ingredients []ingredient.Ingredient) []ingredient.Ingredient {
+	seen := make(map[uint]bool, len(ingredients))
+	distinct := make([]ingredient.Ingredient, 0, len(ingredients))
+	for _, ing := range ingredients {
+		if seen[ing.ID] {
+			continue
+		}
+
+		seen[ing.ID] = true
+		distinct = append(distinct, ing)
+	}
+
+	return distinct
+}
+
+func ingredientIDs(ingredients []ingredient.Ingredient) []uint {
+	ids := make([]uint, len(ingredients))
+	for i, ing := range ingredients {
+		ids[i] = ing.ID
+	}
+
+	return ids
+}
+
+// SearchRecipes takes a SearchQuery and returns the matching recipes, the ones matching the most ingredients first.
+// An ingredient searched several times counts once.
+// The filtering is done with one grouped subquery on recipe_ingredient per constraint instead of joining the table once per ingredient.
+func (rs *RecipeService) SearchRecipes(query SearchQuery) ([]SearchResult, error) {
+	var recipes []Recipe
+
+	query.Ingredients = distinctIngredients(query.Ingredients)
+	searched := ingredientIDs(query.Ingredients)
+	excluded := ingredientIDs(query.Exclude)
+
+	db := rs.db.Model(&Recipe{})
+	if len(searched) > 0 {
+		switch query.Match {
+		case MatchAny:
+			db = db.Where("recipes.id IN (?)", rs.db.Model(&RecipeIngredient{}).Select("recipe_id").Where("ingredient_id IN ?", searched))
+		case MatchNone:
+			excluded = append(excluded, searched...)
+		default:
+			db = db.Where("recipes.id IN (?)", rs.db.Model(&RecipeIngredient{}).Select("recipe_id").Where("ingredient_id IN ?", searched).Group("recipe_id").Having("COUNT(DISTINCT ingredient_id) = ?", len(searched)))
+		}
+	}
+
+	if len(excluded) > 0 {
+		db = db.Where("recipes.id NOT IN (?)", rs.db.Model(&RecipeIngredient{}).Select("recipe_id").Where("ingredient_id IN ?", excluded))
+	}
+
+	if query.Author != "" {
+		db = db.Where("recipes.author_id IN (?)", rs.db.Model(&Author{}).Select("id").Where("username = ?", query.Author))
+	}
+
+	result := db.Order("recipes.id").Scopes(withDetails).Find(&recipes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	results := make([]SearchResult, len(recipes))
+	for i, recipe := range recipes {
+		results[i].Recipe = recipe
+
+		if query.Match == MatchNone {
+			continue
+		}
+
+		for _, searchedIngredient := range query.Ingredients {
+			for _, recipeIngredient := range recipe.Ingredients {
+				if recipeIngredient.IngredientID == searchedIngredient.ID {
+					results[i].MatchedIngredients = append(results[i].MatchedIngredients, searchedIngredient.Name)
+					break
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return len(results[i].MatchedIngredients) > len(results[j].MatchedIngredients)
+	})
+
+	return results, nil
+}
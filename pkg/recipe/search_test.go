@@ -0,0 +1,144 @@
+package recipe
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+	"gorm.io/gorm"
+)
+
+var cheddar = ingredient.Ingredient{Model: gorm.Model{ID: 1}, Name: "cheddar"}
+var beer = ingredient.Ingredient{Model: gorm.Model{ID: 2}, Name: "bière brune"}
+
+func expectRecipeDetails(recipeIDs ...driver.Value) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" IN ($1,$2)`)).WithArgs(recipeIDs...).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id"}).AddRow(1, 1).AddRow(1, 3).AddRow(2, 1).AddRow(2, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2,$3) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 3, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune").AddRow(3, "pain"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE "steps"."recipe_id" IN ($1,$2) AND "steps"."deleted_at" IS NULL ORDER BY position`)).WithArgs(recipeIDs...).WillReturnRows(sqlmock.NewRows([]string{"id", "recipe_id", "position", "text"}))
+}
+
+func TestParseMatchMode(t *testing.T) {
+	for mode, expected := range map[string]MatchMode{"": MatchAll, "all": MatchAll, "any": MatchAny, "none": MatchNone} {
+		got, err := ParseMatchMode(mode)
+		if err != nil || got != expected {
+			t.Errorf("expected %s for %q, got %s (%v)", expected, mode, got, err)
+		}
+	}
+
+	if _, err := ParseMatchMode("some"); !errors.Is(err, ErrUnknownMatchMode) {
+		t.Errorf("expected ErrUnknownMatchMode, got %v", err)
+	}
+}
+
+func TestSearchRecipesMatchAll(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE recipes.id IN (SELECT "recipe_id" FROM "recipe_ingredient" WHERE ingredient_id IN ($1,$2) GROUP BY "recipe_id" HAVING COUNT(DISTINCT ingredient_id) = $3) AND "recipes"."deleted_at" IS NULL ORDER BY recipes.id`)).WithArgs(1, 2, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(2, "welsh"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" = $1`)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id"}).AddRow(2, 1).AddRow(2, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE "steps"."recipe_id" = $1 AND "steps"."deleted_at" IS NULL ORDER BY position`)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "recipe_id", "position", "text"}))
+
+	results, err := recipeService.SearchRecipes(SearchQuery{Ingredients: []ingredient.Ingredient{cheddar, beer}, Match: MatchAll})
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(results) != 1 || len(results[0].MatchedIngredients) != 2 {
+		t.Errorf("expected welsh to match both ingredients, got %v", results)
+	}
+}
+
+func TestSearchRecipesMatchAllWithRepeatedIngredient(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE recipes.id IN (SELECT "recipe_id" FROM "recipe_ingredient" WHERE ingredient_id IN ($1) GROUP BY "recipe_id" HAVING COUNT(DISTINCT ingredient_id) = $2) AND "recipes"."deleted_at" IS NULL ORDER BY recipes.id`)).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(2, "welsh"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" = $1`)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id"}).AddRow(2, 1).AddRow(2, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE "steps"."recipe_id" = $1 AND "steps"."deleted_at" IS NULL ORDER BY position`)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "recipe_id", "position", "text"}))
+
+	results, err := recipeService.SearchRecipes(SearchQuery{Ingredients: []ingredient.Ingredient{cheddar, cheddar}, Match: MatchAll})
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(results) != 1 || len(results[0].MatchedIngredients) != 1 {
+		t.Errorf("expected welsh to match cheddar once, got %v", results)
+	}
+}
+
+func TestSearchRecipesMatchAnyWithExclusion(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE recipes.id IN (SELECT "recipe_id" FROM "recipe_ingredient" WHERE ingredient_id IN ($1,$2)) AND recipes.id NOT IN (SELECT "recipe_id" FROM "recipe_ingredient" WHERE ingredient_id IN ($3)) AND "recipes"."deleted_at" IS NULL ORDER BY recipes.id`)).WithArgs(1, 2, 4).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "croque-monsieur").AddRow(2, "welsh"))
+	expectRecipeDetails(1, 2)
+
+	results, err := recipeService.SearchRecipes(SearchQuery{
+		Ingredients: []ingredient.Ingredient{cheddar, beer},
+		Exclude:     []ingredient.Ingredient{{Model: gorm.Model{ID: 4}, Name: "jambon"}},
+		Match:       MatchAny,
+	})
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(results) != 2 || results[0].Name != "welsh" || len(results[1].MatchedIngredients) != 1 || results[1].MatchedIngredients[0] != "cheddar" {
+		t.Errorf("expected welsh to be ranked before croque-monsieur which only matches cheddar, got %v", results)
+	}
+}
+
+func TestSearchRecipesMatchNone(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE recipes.id NOT IN (SELECT "recipe_id" FROM "recipe_ingredient" WHERE ingredient_id IN ($1)) AND "recipes"."deleted_at" IS NULL ORDER BY recipes.id`)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "croque-monsieur").AddRow(2, "welsh"))
+	expectRecipeDetails(1, 2)
+
+	results, err := recipeService.SearchRecipes(SearchQuery{Ingredients: []ingredient.Ingredient{beer}, Match: MatchNone})
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+
+	for _, result := range results {
+		if len(result.MatchedIngredients) != 0 {
+			t.Errorf("no ingredient should be reported as matched when excluding them, got %v", result.MatchedIngredients)
+		}
+	}
+}
+
+func TestSearchRecipesFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE recipes.id IN (SELECT "recipe_id" FROM "recipe_ingredient" WHERE ingredient_id IN ($1) GROUP BY "recipe_id" HAVING COUNT(DISTINCT ingredient_id) = $2) AND "recipes"."deleted_at" IS NULL ORDER BY recipes.id`)).WithArgs(1, 1).WillReturnError(fmt.Errorf("connection lost"))
+
+	_, err := recipeService.SearchRecipes(SearchQuery{Ingredients: []ingredient.Ingredient{cheddar}})
+	if err == nil {
+		t.Error("an error did not occured while it should have")
+	}
+}
+
+func TestSearchRecipesByAuthor(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE recipes.author_id IN (SELECT "id" FROM "users" WHERE username = $1) AND "recipes"."deleted_at" IS NULL ORDER BY recipes.id`)).WithArgs("cam-amber").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "author_id"}).AddRow(2, "welsh", 3))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","username","deleted_at" FROM "users" WHERE "users"."id" = $1`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(3, "cam-amber"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" = $1`)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE "steps"."recipe_id" = $1 AND "steps"."deleted_at" IS NULL ORDER BY position`)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "recipe_id"}))
+
+	results, err := recipeService.SearchRecipes(SearchQuery{Author: "cam-amber"})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+
+	if len(results) != 1 || results[0].Author == nil || results[0].Author.Username != "cam-amber" {
+		t.Errorf("expected the recipe of cam-amber, got %+v", results)
+	}
+}
@@ -0,0 +1,129 @@
+package recipe
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+	"gorm.io/gorm"
+)
+
+// ErrStepOrderMismatch is returned when a new step order doesn't contain exactly the steps of the recipe.
+var ErrStepOrderMismatch = errors.New("the new order must contain every step of the recipe exactly once")
+
+// ErrIngredientNotInRecipe is returned when a step uses an ingredient that isn't listed in its recipe.
+var ErrIngredientNotInRecipe = errors.New("step uses an ingredient that isn't part of the recipe")
+
+// Step defines one instruction of a recipe.
+// @Description Step defines one instruction of a recipe.
+type Step struct {
+	gorm.Model
+	// The recipe the step belongs to
+	RecipeID uint `gorm:"not null;index" swaggerignore:"true"`
+	// The position of the step in the recipe, starting at 1
+	Position uint `gorm:"not null" example:"1"`
+	// What the cook has to do
+	Text string `gorm:"not null;default:null" example:"Melt the cheddar in the beer."`
+	// How long the step takes, in minutes
+	Duration uint `example:"10"`
+	// The ingredients used during this step
+	Ingredients []*ingredient.Ingredient `gorm:"many2many:step_ingredient;"`
+}
+
+// ResolveIngredients checks that every ingredient used in the step, referenced by ID or by name, is one of recipeIngredients and replaces them by their IDs.
+func (s *Step) ResolveIngredients(recipeIngredients []RecipeIngredient) error {
+	for i, stepIngredient := range s.Ingredients {
+		found := false
+		for _, recipeIngredient := range recipeIngredients {
+			if (stepIngredient.ID != 0 && stepIngredient.ID == recipeIngredient.IngredientID) ||
+				(stepIngredient.ID == 0 && stepIngredient.Name != "" && stepIngredient.Name == recipeIngredient.Ingredient.Name) {
+				s.Ingredients[i] = &ingredient.Ingredient{Model: gorm.Model{ID: recipeIngredient.IngredientID}}
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%w : %s", ErrIngredientNotInRecipe, s.Text)
+		}
+	}
+
+	return nil
+}
+
+// GetSteps takes a recipe ID and returns its steps in order or an error.
+func (rs *RecipeService) GetSteps(recipeID uint) ([]Step, error) {
+	var steps []Step
+
+	result := rs.db.Where("recipe_id = ?", recipeID).Order("position").Preload("Ingredients").Find(&steps)
+
+	return steps, result.Error
+}
+
+// AddStep takes a step and append it at the end of its recipe, returning its new ID or an error.
+// Ingredients of the step are expected to already exist.
+func (rs *RecipeService) AddStep(step Step) (uint, error) {
+	err := rs.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Step{}).Where("recipe_id = ?", step.RecipeID).Count(&count).Error; err != nil {
+			return err
+		}
+
+		step.Position = uint(count) + 1
+
+		return tx.Omit("Ingredients.*").Create(&step).Error
+	})
+
+	return step.ID, err
+}
+
+// ReorderSteps takes a recipe ID and the IDs of its steps in their new order and update their positions.
+// It returns ErrStepOrderMismatch if stepIDs isn't a permutation of the recipe steps.
+func (rs *RecipeService) ReorderSteps(recipeID uint, stepIDs []uint) error {
+	return rs.db.Transaction(func(tx *gorm.DB) error {
+		var existingIDs []uint
+		if err := tx.Model(&Step{}).Where("recipe_id = ?", recipeID).Pluck("id", &existingIDs).Error; err != nil {
+			return err
+		}
+
+		if len(existingIDs) != len(stepIDs) {
+			return ErrStepOrderMismatch
+		}
+
+		remaining := make(map[uint]bool, len(existingIDs))
+		for _, id := range existingIDs {
+			remaining[id] = true
+		}
+
+		for _, id := range stepIDs {
+			if !remaining[id] {
+				return ErrStepOrderMismatch
+			}
+			delete(remaining, id)
+		}
+
+		for i, id := range stepIDs {
+			if err := tx.Model(&Step{}).Where("id = ?", id).Update("position", i+1).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DeleteStep takes a recipe ID and a step ID, removes the step and shift the following ones.
+func (rs *RecipeService) DeleteStep(recipeID uint, stepID uint) error {
+	return rs.db.Transaction(func(tx *gorm.DB) error {
+		var step Step
+		if err := tx.Where("recipe_id = ?", recipeID).Where("id = ?", stepID).First(&step).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&step).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&Step{}).Where("recipe_id = ?", recipeID).Where("position > ?", step.Position).Update("position", gorm.Expr("position - 1")).Error
+	})
+}
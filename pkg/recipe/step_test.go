@@ -0,0 +1,173 @@
+package recipe
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+	"gorm.io/gorm"
+)
+
+func TestCreateRecipeWithStepsSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	any := sqlmock.AnyArg()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "recipes" ("created_at","updated_at","deleted_at","servings","name") VALUES ($1,$2,$3,$4,$5) RETURNING "id","name"`)).WithArgs(any, any, any, 4, "welsh").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "recipe_ingredient" ("recipe_id","ingredient_id","quantity","unit","note","optional") VALUES ($1,$2,$3,$4,$5,$6) ON CONFLICT ("recipe_id","ingredient_id") DO UPDATE SET "recipe_id"="excluded"."recipe_id"`)).WithArgs(1, 1, 250.0, "g", "", false).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "steps" ("created_at","updated_at","deleted_at","recipe_id","position","duration","text") VALUES ($1,$2,$3,$4,$5,$6,$7) ON CONFLICT ("id") DO UPDATE SET "recipe_id"="excluded"."recipe_id" RETURNING "id","text"`)).WithArgs(any, any, any, 1, 1, 10, "Melt the cheddar.").WillReturnRows(sqlmock.NewRows([]string{"id", "text"}).AddRow(1, "Melt the cheddar."))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "step_ingredient" ("step_id","ingredient_id") VALUES ($1,$2) ON CONFLICT DO NOTHING`)).WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	_, err := recipeService.CreateRecipe(Recipe{
+		Name:        "welsh",
+		Ingredients: []RecipeIngredient{{IngredientID: 1, Quantity: 250, Unit: "g"}},
+		Steps: []Step{
+			{Position: 1, Text: "Melt the cheddar.", Duration: 10, Ingredients: []*ingredient.Ingredient{{Model: gorm.Model{ID: 1}}}},
+		},
+	})
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestResolveIngredients(t *testing.T) {
+	recipeIngredients := []RecipeIngredient{
+		{IngredientID: 1, Ingredient: ingredient.Ingredient{Model: gorm.Model{ID: 1}, Name: "cheddar"}},
+		{IngredientID: 2, Ingredient: ingredient.Ingredient{Model: gorm.Model{ID: 2}, Name: "bière brune"}},
+	}
+
+	step := Step{Text: "Melt the cheddar in the beer.", Ingredients: []*ingredient.Ingredient{{Name: "cheddar"}, {Model: gorm.Model{ID: 2}}}}
+	if err := step.ResolveIngredients(recipeIngredients); err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if step.Ingredients[0].ID != 1 || step.Ingredients[1].ID != 2 {
+		t.Errorf("expected ingredients 1 and 2, got %d and %d", step.Ingredients[0].ID, step.Ingredients[1].ID)
+	}
+
+	step = Step{Text: "Toast the bread.", Ingredients: []*ingredient.Ingredient{{Name: "pain"}}}
+	if err := step.ResolveIngredients(recipeIngredients); !errors.Is(err, ErrIngredientNotInRecipe) {
+		t.Errorf("expected ErrIngredientNotInRecipe, got %v", err)
+	}
+}
+
+func TestGetStepsSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE recipe_id = $1 AND "steps"."deleted_at" IS NULL ORDER BY position`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "recipe_id", "position", "text"}).AddRow(1, 1, 1, "Melt the cheddar."))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "step_ingredient" WHERE "step_ingredient"."step_id" = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"step_id", "ingredient_id"}).AddRow(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" = $1 AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar"))
+
+	steps, err := recipeService.GetSteps(1)
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(steps) != 1 || len(steps[0].Ingredients) != 1 {
+		t.Errorf("expected 1 step using 1 ingredient, got %v", steps)
+	}
+}
+
+func TestGetStepsFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE recipe_id = $1 AND "steps"."deleted_at" IS NULL ORDER BY position`)).WithArgs(1).WillReturnError(fmt.Errorf("connection lost"))
+
+	_, err := recipeService.GetSteps(1)
+	if err == nil {
+		t.Error("an error did not occured while it should have")
+	}
+}
+
+func TestAddStepSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	any := sqlmock.AnyArg()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "steps" WHERE recipe_id = $1 AND "steps"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "steps" ("created_at","updated_at","deleted_at","recipe_id","position","duration","text") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id","text"`)).WithArgs(any, any, any, 1, 3, 0, "Serve hot.").WillReturnRows(sqlmock.NewRows([]string{"id", "text"}).AddRow(3, "Serve hot."))
+	mock.ExpectCommit()
+
+	id, err := recipeService.AddStep(Step{RecipeID: 1, Text: "Serve hot."})
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if id != 3 {
+		t.Errorf("expected step id 3, got %d", id)
+	}
+}
+
+func TestReorderStepsSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	any := sqlmock.AnyArg()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "steps" WHERE recipe_id = $1 AND "steps"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "steps" SET "position"=$1,"updated_at"=$2 WHERE id = $3 AND "steps"."deleted_at" IS NULL`)).WithArgs(1, any, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "steps" SET "position"=$1,"updated_at"=$2 WHERE id = $3 AND "steps"."deleted_at" IS NULL`)).WithArgs(2, any, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := recipeService.ReorderSteps(1, []uint{2, 1})
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestReorderStepsFailOnMismatch(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "steps" WHERE recipe_id = $1 AND "steps"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectRollback()
+
+	err := recipeService.ReorderSteps(1, []uint{2, 2})
+	if !errors.Is(err, ErrStepOrderMismatch) {
+		t.Errorf("expected ErrStepOrderMismatch, got %v", err)
+	}
+}
+
+func TestDeleteStepSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	any := sqlmock.AnyArg()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE recipe_id = $1 AND id = $2 AND "steps"."deleted_at" IS NULL ORDER BY "steps"."id" LIMIT 1`)).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "recipe_id", "position"}).AddRow(1, 1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "steps" SET "deleted_at"=$1 WHERE "steps"."id" = $2 AND "steps"."deleted_at" IS NULL`)).WithArgs(any, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "steps" SET "position"=position - 1,"updated_at"=$1 WHERE recipe_id = $2 AND position > $3 AND "steps"."deleted_at" IS NULL`)).WithArgs(any, 1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := recipeService.DeleteStep(1, 1)
+	if err != nil {
+		t.Errorf("an error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestDeleteStepFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "steps" WHERE recipe_id = $1 AND id = $2 AND "steps"."deleted_at" IS NULL ORDER BY "steps"."id" LIMIT 1`)).WithArgs(1, 4).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	err := recipeService.DeleteStep(1, 4)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
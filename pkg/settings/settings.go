@@ -0,0 +1,45 @@
+package settings
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Setting is an application setting admins can change at runtime.
+type Setting struct {
+	// The name of the setting
+	Key string `gorm:"primaryKey;size:60"`
+	// The value of the setting
+	Value string `gorm:"size:255;not null"`
+}
+
+// NewSettingsService is the constructor for a SettingsService.
+func NewSettingsService(db *gorm.DB) *SettingsService {
+	return &SettingsService{
+		db: db,
+	}
+}
+
+// SettingsService is a service made to read and change application settings.
+type SettingsService struct {
+	db *gorm.DB
+}
+
+// Get takes the key of a setting and returns its value, or fallback if it has never been set.
+func (ss *SettingsService) Get(key string, fallback string) (string, error) {
+	var setting Setting
+
+	err := ss.db.Where("key = ?", key).First(&setting).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fallback, nil
+	}
+
+	return setting.Value, err
+}
+
+// Set takes the key of a setting and its new value and saves it.
+func (ss *SettingsService) Set(key string, value string) error {
+	return ss.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&Setting{Key: key, Value: value}).Error
+}
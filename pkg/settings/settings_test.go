@@ -0,0 +1,92 @@
+package settings
+
+import (
+	"database/sql"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var mock sqlmock.Sqlmock
+var db *sql.DB
+var settingsService *SettingsService
+
+func Setup(t *testing.T) func(t *testing.T) {
+	var err error
+
+	db, mock, err = sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp)) // mock sql.DB
+	if err != nil {
+		t.Fatalf("error shouldn't have occured while mocking db")
+	}
+
+	dialector := postgres.New(postgres.Config{
+		DSN:                  "sqlmock_db_0",
+		DriverName:           "postgres",
+		Conn:                 db,
+		PreferSimpleProtocol: true,
+	})
+
+	gdb, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("error shouldn't have occured while opening the mocked db")
+	}
+
+	settingsService = NewSettingsService(gdb)
+
+	return func(t *testing.T) {
+		defer db.Close()
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	}
+}
+
+func TestGetSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "settings" WHERE key = $1 ORDER BY "settings"."key" LIMIT 1`)).WithArgs("registration_mode").WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("registration_mode", "open"))
+
+	value, err := settingsService.Get("registration_mode", "closed")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if value != "open" {
+		t.Errorf("expected open, got %s", value)
+	}
+}
+
+func TestGetSucceedWithFallback(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "settings" WHERE key = $1`)).WithArgs("registration_mode").WillReturnError(gorm.ErrRecordNotFound)
+
+	value, err := settingsService.Get("registration_mode", "closed")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if value != "closed" {
+		t.Errorf("expected the closed fallback, got %s", value)
+	}
+}
+
+func TestSetSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "settings" ("key","value") VALUES ($1,$2) ON CONFLICT ("key") DO UPDATE SET "value"="excluded"."value"`)).WithArgs("registration_mode", "open").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := settingsService.Set("registration_mode", "open")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
@@ -0,0 +1,282 @@
+package shopping
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+	"github.com/mjehanno/welsh-academy/pkg/recipe"
+	"github.com/mjehanno/welsh-academy/pkg/units"
+	"github.com/mjehanno/welsh-academy/pkg/user"
+	"gorm.io/gorm"
+)
+
+// ShoppingList is a list of ingredients to buy in order to cook some recipes.
+// @Description ShoppingList is a list of ingredients to buy in order to cook some recipes.
+type ShoppingList struct {
+	gorm.Model
+	// The owner of the list
+	UserID uint `gorm:"not null;index" swaggerignore:"true"`
+	// The name of the list
+	Name string `gorm:"not null;default:null" example:"sunday welsh party"`
+	// The ingredients to buy
+	Items []ShoppingItem
+}
+
+// ShoppingItem is an ingredient to buy.
+// @Description ShoppingItem is an ingredient to buy.
+type ShoppingItem struct {
+	gorm.Model
+	// The list the item belongs to
+	ShoppingListID uint `gorm:"not null;index" swaggerignore:"true"`
+	// The ingredient to buy
+	IngredientID uint `gorm:"not null" example:"1"`
+	Ingredient   ingredient.Ingredient
+	// The amount to buy, 0 when the recipes don't specify it
+	Quantity float64 `example:"500"`
+	// The unit in which the quantity is expressed
+	Unit string `gorm:"size:20" example:"g"`
+	// Whether the item has already been bought
+	Checked bool `example:"false"`
+}
+
+// GenerateRequest defines which recipes a shopping list is generated from.
+type GenerateRequest struct {
+	// The name of the list
+	Name string `example:"sunday welsh party"`
+	// The recipes to cook
+	RecipeIDs []uint `example:"1,2"`
+	// Also use every favorite recipe of the user
+	FromFavorites bool `example:"false"`
+}
+
+// CheckRequest defines the checked state of a shopping item.
+type CheckRequest struct {
+	// Whether the item has already been bought
+	Checked bool `example:"true"`
+}
+
+// BuildItems takes the ingredients of several recipes and sums the quantities of the same ingredient, once normalized to the same unit.
+// Volumes of an ingredient also needed by mass are converted to mass when its density is known.
+// Ingredients used without quantity are only listed once, unless another recipe gives a quantity for them.
+func BuildItems(recipeIngredients []recipe.RecipeIngredient) []ShoppingItem {
+	type itemKey struct {
+		ingredientID uint
+		unit         string
+	}
+
+	items := []ShoppingItem{}
+	indexes := map[itemKey]int{}
+	quantified := map[uint]bool{}
+
+	for _, recipeIngredient := range recipeIngredients {
+		quantity, unit := units.Normalize(recipeIngredient.Quantity, recipeIngredient.Unit)
+		if quantity == 0 {
+			unit = ""
+		} else {
+			quantified[recipeIngredient.IngredientID] = true
+		}
+
+		key := itemKey{ingredientID: recipeIngredient.IngredientID, unit: unit}
+		if i, ok := indexes[key]; ok {
+			items[i].Quantity += quantity
+			continue
+		}
+
+		indexes[key] = len(items)
+		items = append(items, ShoppingItem{IngredientID: recipeIngredient.IngredientID, Ingredient: recipeIngredient.Ingredient, Quantity: quantity, Unit: unit})
+	}
+
+	for i, item := range items {
+		if massIndex, ok := indexes[itemKey{ingredientID: item.IngredientID, unit: "g"}]; ok && item.Unit == "ml" && item.Ingredient.Density > 0 {
+			grams, _ := units.Convert(item.Quantity, "ml", "g", item.Ingredient.Density)
+			items[massIndex].Quantity += grams
+			items[i].Quantity = 0
+		}
+	}
+
+	result := make([]ShoppingItem, 0, len(items))
+	for _, item := range items {
+		if item.Quantity == 0 && quantified[item.IngredientID] {
+			continue
+		}
+
+		result = append(result, item)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Ingredient.Name < result[j].Ingredient.Name
+	})
+
+	return result
+}
+
+// Amount returns a human readable amount of the item, using bigger units for big quantities
+// and rounding it the way recipe quantities are.
+func (si ShoppingItem) Amount() string {
+	quantity, unit := si.Quantity, si.Unit
+	if quantity == 0 {
+		return ""
+	}
+
+	quantity, unit = units.ToSystem(quantity, unit, units.Metric)
+	quantity = recipe.RoundQuantity(quantity, unit)
+
+	amount := strconv.FormatFloat(quantity, 'f', -1, 64)
+	if unit != "" {
+		amount += " " + unit
+	}
+
+	return amount
+}
+
+func (si ShoppingItem) line() string {
+	if amount := si.Amount(); amount != "" {
+		return si.Ingredient.Name + " : " + amount
+	}
+
+	return si.Ingredient.Name
+}
+
+// Text exports the list as plain text, one item per line.
+func (sl ShoppingList) Text() string {
+	var b strings.Builder
+
+	b.WriteString(sl.Name + "\n\n")
+	for _, item := range sl.Items {
+		box := "[ ]"
+		if item.Checked {
+			box = "[x]"
+		}
+		fmt.Fprintf(&b, "%s %s\n", box, item.line())
+	}
+
+	return b.String()
+}
+
+// Markdown exports the list as a Markdown task list.
+func (sl ShoppingList) Markdown() string {
+	var b strings.Builder
+
+	b.WriteString("# " + sl.Name + "\n\n")
+	for _, item := range sl.Items {
+		box := "[ ]"
+		if item.Checked {
+			box = "[x]"
+		}
+		fmt.Fprintf(&b, "- %s %s\n", box, item.line())
+	}
+
+	return b.String()
+}
+
+// NewShoppingService is the ShoppingService constructor.
+func NewShoppingService(db *gorm.DB) *ShoppingService {
+	return &ShoppingService{
+		db: db,
+	}
+}
+
+// ShoppingService is a service made to manage shopping lists.
+type ShoppingService struct {
+	db *gorm.DB
+}
+
+// GenerateShoppingList takes a userID, a name and recipe IDs and creates a shopping list containing every ingredient of these recipes, returning its ID or an error.
+func (ss *ShoppingService) GenerateShoppingList(userID uint, name string, recipeIDs []uint) (uint, error) {
+	var recipeIngredients []recipe.RecipeIngredient
+
+	existingRecipes := ss.db.Model(&recipe.Recipe{}).Select("id").Where("id IN ?", recipeIDs)
+	err := ss.db.Where("recipe_id IN (?)", existingRecipes).Preload("Ingredient").Find(&recipeIngredients).Error
+	if err != nil {
+		return 0, err
+	}
+
+	list := ShoppingList{UserID: userID, Name: name, Items: BuildItems(recipeIngredients)}
+	result := ss.db.Omit("Items.Ingredient").Create(&list)
+
+	return list.ID, result.Error
+}
+
+// GetShoppingLists takes a userID and returns all of his shopping lists with their items.
+func (ss *ShoppingService) GetShoppingLists(userID uint) ([]ShoppingList, error) {
+	var lists []ShoppingList
+
+	result := ss.db.Where("user_id = ?", userID).Order("id").Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Items.Ingredient").Find(&lists)
+
+	return lists, result.Error
+}
+
+// GetShoppingList takes a userID and a list ID and returns the corresponding list if the user owns it or gorm.ErrRecordNotFound.
+func (ss *ShoppingService) GetShoppingList(userID uint, listID uint) (ShoppingList, error) {
+	var list ShoppingList
+
+	result := ss.db.Where("user_id = ?", userID).Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Items.Ingredient").First(&list, listID)
+
+	return list, result.Error
+}
+
+// SetItemChecked takes a userID, a list ID and an item ID and sets the checked state of the item if the user owns the list.
+// It returns gorm.ErrRecordNotFound otherwise.
+func (ss *ShoppingService) SetItemChecked(userID uint, listID uint, itemID uint, checked bool) error {
+	result := ss.db.Model(&ShoppingItem{}).
+		Where("id = ?", itemID).
+		Where("shopping_list_id IN (?)", ss.db.Model(&ShoppingList{}).Select("id").Where("id = ?", listID).Where("user_id = ?", userID)).
+		Update("checked", checked)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteShoppingList takes a userID and a list ID and deletes the list if the user owns it, returning gorm.ErrRecordNotFound otherwise.
+func (ss *ShoppingService) DeleteShoppingList(userID uint, listID uint) error {
+	return ss.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ?", userID).Delete(&ShoppingList{}, listID)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Where("shopping_list_id = ?", listID).Delete(&ShoppingItem{}).Error
+	})
+}
+
+// PersonalData returns the data linked to users stored by this package : their shopping lists.
+func PersonalData() []user.PersonalData {
+	return []user.PersonalData{
+		{
+			Name: "shopping-lists",
+			Export: func(tx *gorm.DB, u user.User) (interface{}, error) {
+				lists := []ShoppingList{}
+				err := tx.Where("user_id = ?", u.ID).Order("id").Preload("Items", func(db *gorm.DB) *gorm.DB {
+					return db.Order("id")
+				}).Preload("Items.Ingredient").Find(&lists).Error
+
+				return lists, err
+			},
+			Erase: func(tx *gorm.DB, u user.User) error {
+				lists := tx.Model(&ShoppingList{}).Unscoped().Select("id").Where("user_id = ?", u.ID)
+				if err := tx.Unscoped().Where("shopping_list_id IN (?)", lists).Delete(&ShoppingItem{}).Error; err != nil {
+					return err
+				}
+
+				return tx.Unscoped().Where("user_id = ?", u.ID).Delete(&ShoppingList{}).Error
+			},
+		},
+	}
+}
@@ -0,0 +1,272 @@
+package shopping
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+	"github.com/mjehanno/welsh-academy/pkg/recipe"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var mock sqlmock.Sqlmock
+var db *sql.DB
+var shoppingService *ShoppingService
+
+func Setup(t *testing.T) func(t *testing.T) {
+	var err error
+
+	db, mock, err = sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp)) // mock sql.DB
+	if err != nil {
+		t.Fatalf("error shouldn't have occured while mocking db")
+	}
+
+	dialector := postgres.New(postgres.Config{
+		DSN:                  "sqlmock_db_0",
+		DriverName:           "postgres",
+		Conn:                 db,
+		PreferSimpleProtocol: true,
+	})
+
+	gdb, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("error shouldn't have occured while opening the mocked db")
+	}
+
+	shoppingService = NewShoppingService(gdb)
+
+	return func(t *testing.T) {
+		defer db.Close()
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	}
+}
+
+var cheddar = ingredient.Ingredient{Model: gorm.Model{ID: 1}, Name: "cheddar"}
+var beer = ingredient.Ingredient{Model: gorm.Model{ID: 2}, Name: "bière brune"}
+var pepper = ingredient.Ingredient{Model: gorm.Model{ID: 3}, Name: "poivre"}
+
+func TestBuildItems(t *testing.T) {
+	items := BuildItems([]recipe.RecipeIngredient{
+		{RecipeID: 1, IngredientID: 1, Ingredient: cheddar, Quantity: 250, Unit: "g"},
+		{RecipeID: 1, IngredientID: 2, Ingredient: beer, Quantity: 25, Unit: "cl"},
+		{RecipeID: 1, IngredientID: 3, Ingredient: pepper},
+		{RecipeID: 2, IngredientID: 1, Ingredient: cheddar, Quantity: 1, Unit: "KG"},
+		{RecipeID: 2, IngredientID: 2, Ingredient: beer, Quantity: 0.5, Unit: "l"},
+		{RecipeID: 2, IngredientID: 3, Ingredient: pepper},
+	})
+
+	if len(items) != 3 {
+		t.Fatalf("expected 3 items, got %v", items)
+	}
+
+	expected := map[string]string{"cheddar": "1.25 kg", "bière brune": "750 ml", "poivre": ""}
+	for _, item := range items {
+		if item.Amount() != expected[item.Ingredient.Name] {
+			t.Errorf("expected %q of %s, got %q", expected[item.Ingredient.Name], item.Ingredient.Name, item.Amount())
+		}
+	}
+}
+
+func TestBuildItemsDropsUnquantifiedDuplicates(t *testing.T) {
+	items := BuildItems([]recipe.RecipeIngredient{
+		{RecipeID: 1, IngredientID: 1, Ingredient: cheddar},
+		{RecipeID: 2, IngredientID: 1, Ingredient: cheddar, Quantity: 200, Unit: "g"},
+		{RecipeID: 3, IngredientID: 1, Ingredient: cheddar, Quantity: 2, Unit: "slices"},
+	})
+
+	if len(items) != 2 || items[0].Amount() != "200 g" || items[1].Amount() != "2 slices" {
+		t.Errorf("expected 200 g and 2 slices of cheddar, got %v", items)
+	}
+}
+
+func TestBuildItemsConvertsVolumesWithDensity(t *testing.T) {
+	flour := ingredient.Ingredient{Model: gorm.Model{ID: 4}, Name: "farine", Density: 0.5}
+	items := BuildItems([]recipe.RecipeIngredient{
+		{RecipeID: 1, IngredientID: 4, Ingredient: flour, Quantity: 200, Unit: "g"},
+		{RecipeID: 2, IngredientID: 4, Ingredient: flour, Quantity: 2, Unit: "dl"},
+		{RecipeID: 2, IngredientID: 2, Ingredient: beer, Quantity: 25, Unit: "cl"},
+	})
+
+	if len(items) != 2 || items[1].Amount() != "300 g" || items[0].Amount() != "250 ml" {
+		t.Errorf("expected 250 ml of beer and 300 g of flour, got %v", items)
+	}
+}
+
+func TestExport(t *testing.T) {
+	list := ShoppingList{Name: "welsh party", Items: []ShoppingItem{
+		{Ingredient: cheddar, Quantity: 500, Unit: "g", Checked: true},
+		{Ingredient: pepper},
+	}}
+
+	text := "welsh party\n\n[x] cheddar : 500 g\n[ ] poivre\n"
+	if list.Text() != text {
+		t.Errorf("expected %q, got %q", text, list.Text())
+	}
+
+	markdown := "# welsh party\n\n- [x] cheddar : 500 g\n- [ ] poivre\n"
+	if list.Markdown() != markdown {
+		t.Errorf("expected %q, got %q", markdown, list.Markdown())
+	}
+}
+
+func TestExportRoundsConvertedQuantities(t *testing.T) {
+	flour := ingredient.Ingredient{Model: gorm.Model{ID: 4}, Name: "farine", Density: 0.53}
+	list := ShoppingList{Name: "crumble", Items: BuildItems([]recipe.RecipeIngredient{
+		{RecipeID: 1, IngredientID: 4, Ingredient: flour, Quantity: 150, Unit: "g"},
+		{RecipeID: 2, IngredientID: 4, Ingredient: flour, Quantity: 1, Unit: "cup"},
+	})}
+
+	text := "crumble\n\n[ ] farine : 275 g\n"
+	if list.Text() != text {
+		t.Errorf("expected %q, got %q", text, list.Text())
+	}
+}
+
+func TestGenerateShoppingListSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	any := sqlmock.AnyArg()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE recipe_id IN (SELECT "id" FROM "recipes" WHERE id IN ($1,$2) AND "recipes"."deleted_at" IS NULL)`)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id", "quantity", "unit"}).AddRow(1, 1, 250, "g").AddRow(2, 1, 250, "g"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" = $1 AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "shopping_lists" ("created_at","updated_at","deleted_at","user_id","name") VALUES ($1,$2,$3,$4,$5) RETURNING "id","name"`)).WithArgs(any, any, any, 1, "welsh party").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh party"))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "shopping_items" ("created_at","updated_at","deleted_at","shopping_list_id","ingredient_id","quantity","unit","checked") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) ON CONFLICT ("id") DO UPDATE SET "shopping_list_id"="excluded"."shopping_list_id" RETURNING "id"`)).WithArgs(any, any, any, 1, 1, 500.0, "g", false).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	id, err := shoppingService.GenerateShoppingList(1, "welsh party", []uint{1, 2})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if id != 1 {
+		t.Errorf("expected list id 1, got %d", id)
+	}
+}
+
+func TestGenerateShoppingListFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE recipe_id IN (SELECT "id" FROM "recipes" WHERE id IN ($1) AND "recipes"."deleted_at" IS NULL)`)).WithArgs(1).WillReturnError(fmt.Errorf("connection lost"))
+
+	_, err := shoppingService.GenerateShoppingList(1, "welsh party", []uint{1})
+	if err == nil {
+		t.Error("error did not occured while it should have")
+	}
+}
+
+func TestGetShoppingListSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "shopping_lists" WHERE user_id = $1 AND "shopping_lists"."id" = $2 AND "shopping_lists"."deleted_at" IS NULL ORDER BY "shopping_lists"."id" LIMIT 1`)).WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name"}).AddRow(1, 1, "welsh party"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "shopping_items" WHERE "shopping_items"."shopping_list_id" = $1 AND "shopping_items"."deleted_at" IS NULL ORDER BY id`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "shopping_list_id", "ingredient_id", "quantity", "unit"}).AddRow(1, 1, 1, 500, "g"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" = $1 AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar"))
+
+	list, err := shoppingService.GetShoppingList(1, 1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(list.Items) != 1 || list.Items[0].Ingredient.Name != "cheddar" {
+		t.Errorf("expected the list to contain cheddar, got %v", list.Items)
+	}
+}
+
+func TestGetShoppingListFailOnOtherUserList(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "shopping_lists" WHERE user_id = $1 AND "shopping_lists"."id" = $2 AND "shopping_lists"."deleted_at" IS NULL ORDER BY "shopping_lists"."id" LIMIT 1`)).WithArgs(2, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := shoppingService.GetShoppingList(2, 1)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestGetShoppingListsSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "shopping_lists" WHERE user_id = $1 AND "shopping_lists"."deleted_at" IS NULL ORDER BY id`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name"}).AddRow(1, 1, "welsh party"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "shopping_items" WHERE "shopping_items"."shopping_list_id" = $1 AND "shopping_items"."deleted_at" IS NULL ORDER BY id`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "shopping_list_id", "ingredient_id"}))
+
+	lists, err := shoppingService.GetShoppingLists(1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(lists) != 1 {
+		t.Errorf("expected 1 list, got %d", len(lists))
+	}
+}
+
+func TestSetItemCheckedSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "shopping_items" SET "checked"=$1,"updated_at"=$2 WHERE id = $3 AND shopping_list_id IN (SELECT "id" FROM "shopping_lists" WHERE id = $4 AND user_id = $5 AND "shopping_lists"."deleted_at" IS NULL) AND "shopping_items"."deleted_at" IS NULL`)).WithArgs(true, sqlmock.AnyArg(), 3, 1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := shoppingService.SetItemChecked(1, 1, 3, true)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestSetItemCheckedFailOnOtherUserList(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "shopping_items" SET "checked"=$1,"updated_at"=$2 WHERE id = $3 AND shopping_list_id IN (SELECT "id" FROM "shopping_lists" WHERE id = $4 AND user_id = $5 AND "shopping_lists"."deleted_at" IS NULL) AND "shopping_items"."deleted_at" IS NULL`)).WithArgs(true, sqlmock.AnyArg(), 3, 1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := shoppingService.SetItemChecked(2, 1, 3, true)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestDeleteShoppingListSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	any := sqlmock.AnyArg()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "shopping_lists" SET "deleted_at"=$1 WHERE user_id = $2 AND "shopping_lists"."id" = $3 AND "shopping_lists"."deleted_at" IS NULL`)).WithArgs(any, 1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "shopping_items" SET "deleted_at"=$1 WHERE shopping_list_id = $2 AND "shopping_items"."deleted_at" IS NULL`)).WithArgs(any, 1).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	err := shoppingService.DeleteShoppingList(1, 1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestDeleteShoppingListFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "shopping_lists" SET "deleted_at"=$1 WHERE user_id = $2 AND "shopping_lists"."id" = $3 AND "shopping_lists"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 2, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err := shoppingService.DeleteShoppingList(2, 1)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
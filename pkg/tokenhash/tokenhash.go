@@ -0,0 +1,14 @@
+// Package tokenhash hashes the random tokens stored in the database, such as refresh tokens, API keys and password reset tokens.
+package tokenhash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Sum returns the hex encoded SHA-256 of a token, tokens being random a slow hash isn't needed.
+func Sum(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,11 @@
+package tokenhash
+
+import "testing"
+
+func TestSum(t *testing.T) {
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	if got := Sum("hello"); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
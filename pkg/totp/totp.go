@@ -0,0 +1,107 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Period is the duration a code is valid for, in seconds.
+const Period = 30
+
+// Digits is the number of digits of a code.
+const Digits = 6
+
+// skew is the number of periods before and after the current one whose codes are still accepted, to allow for clock drift.
+const skew = 1
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, base32 encoded as authenticator applications expect it.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the time step a moment belongs to.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// code computes the HOTP value (RFC 4226) of a counter, truncated to the given number of digits.
+func code(key []byte, counter int64, digits int) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	return encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+// Code returns the code of a secret at a given time.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return code(key, Step(t), Digits), nil
+}
+
+// Validate tells if a code is valid for a secret at a given time, and returns the time step it belongs to so that
+// callers can refuse a code that has already been used. Codes of the adjacent steps are accepted too.
+func Validate(secret string, candidate string, t time.Time) (bool, int64) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(candidate) != Digits {
+		return false, 0
+	}
+
+	current := Step(t)
+	for step := current - skew; step <= current+skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, step, Digits)), []byte(candidate)) == 1 {
+			return true, step
+		}
+	}
+
+	return false, 0
+}
+
+// ProvisioningURI returns the otpauth URI authenticator applications read, usually from a QR code, to register a secret.
+func ProvisioningURI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	// some applications don't decode + as a space
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+}
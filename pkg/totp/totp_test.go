@@ -0,0 +1,78 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeSucceed(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for timestamp, expected := range vectors {
+		code, err := Code(rfcSecret, time.Unix(timestamp, 0))
+		if err != nil {
+			t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+		}
+
+		if code != expected {
+			t.Errorf("expected %s at %d, got %s", expected, timestamp, code)
+		}
+	}
+}
+
+func TestValidateSucceed(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	for _, offset := range []time.Duration{-Period * time.Second, 0, Period * time.Second} {
+		code, _ := Code(rfcSecret, now.Add(offset))
+
+		valid, step := Validate(rfcSecret, code, now)
+		if !valid || step != Step(now.Add(offset)) {
+			t.Errorf("expected the code of offset %s to be valid, got %t %d", offset, valid, step)
+		}
+	}
+}
+
+func TestValidateFail(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	old, _ := Code(rfcSecret, now.Add(-2*Period*time.Second))
+
+	for _, code := range []string{old, "000000", "12345", "abcdef"} {
+		if valid, _ := Validate(rfcSecret, code, now); valid {
+			t.Errorf("expected %s to be refused", code)
+		}
+	}
+}
+
+func TestGenerateSecretSucceed(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	code, _ := Code(secret, time.Now())
+	if valid, _ := Validate(secret, code, time.Now()); !valid || len(secret) != 32 {
+		t.Errorf("expected a usable 32 characters secret, got %s", secret)
+	}
+}
+
+func TestProvisioningURISucceed(t *testing.T) {
+	uri := ProvisioningURI("Welsh Academy", "cam-amber", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Welsh%20Academy:cam-amber?") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=Welsh%20Academy") {
+		t.Errorf("unexpected provisioning URI %s", uri)
+	}
+}
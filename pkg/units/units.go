@@ -0,0 +1,194 @@
+package units
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrUnknownUnit is returned when converting a quantity expressed in a unit this package doesn't know.
+var ErrUnknownUnit = errors.New("unknown unit")
+
+// ErrMissingDensity is returned when converting between a mass and a volume for an ingredient without density.
+var ErrMissingDensity = errors.New("can't convert between mass and volume without the ingredient density")
+
+// ErrUnknownSystem is returned when parsing a unit system that doesn't exist.
+var ErrUnknownSystem = errors.New("units must be one of metric or imperial")
+
+// Dimension is the physical quantity measured by a unit.
+type Dimension int
+
+const (
+	// Mass units are expressed in grams.
+	Mass Dimension = iota
+	// Volume units are expressed in millilitres.
+	Volume
+)
+
+// System is a set of units used together.
+type System string
+
+const (
+	// Metric is the international system, grams and litres.
+	Metric System = "metric"
+	// Imperial is the US customary system, ounces, pounds, spoons and cups.
+	Imperial System = "imperial"
+)
+
+// ParseSystem takes the value of a units parameter and returns the corresponding system, an empty value means no conversion.
+func ParseSystem(value string) (System, error) {
+	switch System(value) {
+	case "", Metric, Imperial:
+		return System(value), nil
+	default:
+		return "", ErrUnknownSystem
+	}
+}
+
+// Unit defines a measuring unit.
+type Unit struct {
+	// The symbol used to display quantities in this unit
+	Symbol string
+	// What the unit measures
+	Dimension Dimension
+	// The system the unit belongs to
+	System System
+	// How many grams or millilitres a unit is worth
+	Factor float64
+}
+
+var knownUnits = []Unit{
+	{Symbol: "mg", Dimension: Mass, System: Metric, Factor: 0.001},
+	{Symbol: "g", Dimension: Mass, System: Metric, Factor: 1},
+	{Symbol: "kg", Dimension: Mass, System: Metric, Factor: 1000},
+	{Symbol: "oz", Dimension: Mass, System: Imperial, Factor: 28.349523125},
+	{Symbol: "lb", Dimension: Mass, System: Imperial, Factor: 453.59237},
+	{Symbol: "ml", Dimension: Volume, System: Metric, Factor: 1},
+	{Symbol: "cl", Dimension: Volume, System: Metric, Factor: 10},
+	{Symbol: "dl", Dimension: Volume, System: Metric, Factor: 100},
+	{Symbol: "l", Dimension: Volume, System: Metric, Factor: 1000},
+	{Symbol: "tsp", Dimension: Volume, System: Imperial, Factor: 4.92892159375},
+	{Symbol: "tbsp", Dimension: Volume, System: Imperial, Factor: 14.78676478125},
+	{Symbol: "fl oz", Dimension: Volume, System: Imperial, Factor: 29.5735295625},
+	{Symbol: "cup", Dimension: Volume, System: Imperial, Factor: 236.5882365},
+	{Symbol: "pint", Dimension: Volume, System: Imperial, Factor: 473.176473},
+	{Symbol: "quart", Dimension: Volume, System: Imperial, Factor: 946.352946},
+	{Symbol: "gallon", Dimension: Volume, System: Imperial, Factor: 3785.411784},
+}
+
+// aliases maps the other ways contributors write a unit to its symbol.
+var aliases = map[string]string{
+	"milligram": "mg", "milligrams": "mg",
+	"gr": "g", "gram": "g", "grams": "g",
+	"kilogram": "kg", "kilograms": "kg",
+	"ounce": "oz", "ounces": "oz",
+	"lbs": "lb", "pound": "lb", "pounds": "lb",
+	"millilitre": "ml", "millilitres": "ml", "milliliter": "ml", "milliliters": "ml",
+	"centilitre": "cl", "centilitres": "cl", "centiliter": "cl", "centiliters": "cl",
+	"decilitre": "dl", "decilitres": "dl", "deciliter": "dl", "deciliters": "dl",
+	"litre": "l", "litres": "l", "liter": "l", "liters": "l",
+	"teaspoon": "tsp", "teaspoons": "tsp",
+	"tablespoon": "tbsp", "tablespoons": "tbsp",
+	"floz": "fl oz", "fluid ounce": "fl oz", "fluid ounces": "fl oz",
+	"cups":    "cup",
+	"pints":   "pint",
+	"quarts":  "quart",
+	"gallons": "gallon",
+}
+
+// displayUnits lists, for each system and dimension, the units quantities are displayed in along with the smallest quantity
+// worth using them, from the smallest unit to the biggest one.
+var displayUnits = map[System]map[Dimension][]struct {
+	symbol  string
+	minimum float64
+}{
+	Metric: {
+		Mass:   {{symbol: "mg"}, {symbol: "g", minimum: 1}, {symbol: "kg", minimum: 1}},
+		Volume: {{symbol: "ml"}, {symbol: "l", minimum: 1}},
+	},
+	Imperial: {
+		Mass:   {{symbol: "oz"}, {symbol: "lb", minimum: 1}},
+		Volume: {{symbol: "tsp"}, {symbol: "tbsp", minimum: 1}, {symbol: "cup", minimum: 0.25}, {symbol: "quart", minimum: 1}},
+	},
+}
+
+// Lookup takes a unit as written in a recipe and returns the corresponding unit, the boolean is false if the unit isn't known.
+func Lookup(unit string) (Unit, bool) {
+	symbol := strings.ToLower(strings.TrimSpace(unit))
+	if alias, ok := aliases[symbol]; ok {
+		symbol = alias
+	}
+
+	for _, known := range knownUnits {
+		if known.Symbol == symbol {
+			return known, true
+		}
+	}
+
+	return Unit{}, false
+}
+
+// Convert takes a quantity and converts it from a unit to another one.
+// Converting between a mass and a volume uses the density of the ingredient, in grams per millilitre, 0 meaning it's unknown.
+func Convert(quantity float64, from string, to string, density float64) (float64, error) {
+	fromUnit, ok := Lookup(from)
+	if !ok {
+		return 0, ErrUnknownUnit
+	}
+
+	toUnit, ok := Lookup(to)
+	if !ok {
+		return 0, ErrUnknownUnit
+	}
+
+	base := quantity * fromUnit.Factor
+	if fromUnit.Dimension != toUnit.Dimension {
+		if density <= 0 {
+			return 0, ErrMissingDensity
+		}
+
+		if fromUnit.Dimension == Volume {
+			base *= density
+		} else {
+			base /= density
+		}
+	}
+
+	return base / toUnit.Factor, nil
+}
+
+// Normalize takes a quantity and its unit and returns them expressed in grams or millilitres, unknown units are only lowercased.
+func Normalize(quantity float64, unit string) (float64, string) {
+	known, ok := Lookup(unit)
+	if !ok {
+		return quantity, strings.ToLower(strings.TrimSpace(unit))
+	}
+
+	if known.Dimension == Mass {
+		return quantity * known.Factor, "g"
+	}
+
+	return quantity * known.Factor, "ml"
+}
+
+// ToSystem takes a quantity and its unit and expresses it in the most readable unit of the given system, keeping its dimension.
+// Quantities in unknown units, or when system is empty, are returned unchanged.
+func ToSystem(quantity float64, unit string, system System) (float64, string) {
+	known, ok := Lookup(unit)
+	candidates := displayUnits[system][known.Dimension]
+	if !ok || len(candidates) == 0 {
+		return quantity, unit
+	}
+
+	base := quantity * known.Factor
+	best, _ := Lookup(candidates[0].symbol)
+	for _, candidate := range candidates[1:] {
+		candidateUnit, _ := Lookup(candidate.symbol)
+		if base/candidateUnit.Factor < candidate.minimum {
+			break
+		}
+
+		best = candidateUnit
+	}
+
+	return base / best.Factor, best.Symbol
+}
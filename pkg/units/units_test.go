@@ -0,0 +1,107 @@
+package units
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func almostEqual(a float64, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
+
+func TestLookup(t *testing.T) {
+	for _, written := range []string{"g", "G", " grams ", "gr"} {
+		if unit, ok := Lookup(written); !ok || unit.Symbol != "g" {
+			t.Errorf("expected %q to be grams, got %v", written, unit)
+		}
+	}
+
+	if _, ok := Lookup("slices"); ok {
+		t.Error("slices shouldn't be a known unit")
+	}
+}
+
+func TestParseSystem(t *testing.T) {
+	if system, err := ParseSystem("imperial"); err != nil || system != Imperial {
+		t.Errorf("expected imperial system, got %v %v", system, err)
+	}
+
+	if _, err := ParseSystem("nautical"); !errors.Is(err, ErrUnknownSystem) {
+		t.Errorf("expected an unknown system error, got %v", err)
+	}
+}
+
+func TestConvertSucceed(t *testing.T) {
+	cases := []struct {
+		quantity float64
+		from     string
+		to       string
+		density  float64
+		expected float64
+	}{
+		{quantity: 1, from: "lb", to: "g", expected: 453.592},
+		{quantity: 3, from: "tsp", to: "tbsp", expected: 1},
+		{quantity: 25, from: "cl", to: "ml", expected: 250},
+		{quantity: 1, from: "cup", to: "g", density: 0.53, expected: 125.392},
+		{quantity: 100, from: "g", to: "ml", density: 1.03, expected: 97.087},
+	}
+
+	for _, c := range cases {
+		converted, err := Convert(c.quantity, c.from, c.to, c.density)
+		if err != nil {
+			t.Errorf("error occured while it shouldn't have : %s", err.Error())
+			continue
+		}
+
+		if !almostEqual(converted, c.expected) {
+			t.Errorf("expected %v %s to be %v %s, got %v", c.quantity, c.from, c.expected, c.to, converted)
+		}
+	}
+}
+
+func TestConvertFail(t *testing.T) {
+	if _, err := Convert(1, "cup", "g", 0); !errors.Is(err, ErrMissingDensity) {
+		t.Errorf("expected a missing density error, got %v", err)
+	}
+
+	if _, err := Convert(2, "slices", "g", 0); !errors.Is(err, ErrUnknownUnit) {
+		t.Errorf("expected an unknown unit error, got %v", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if quantity, unit := Normalize(1.5, "KG"); quantity != 1500 || unit != "g" {
+		t.Errorf("expected 1500 g, got %v %s", quantity, unit)
+	}
+
+	if quantity, unit := Normalize(2, "Slices"); quantity != 2 || unit != "slices" {
+		t.Errorf("expected 2 slices, got %v %s", quantity, unit)
+	}
+}
+
+func TestToSystem(t *testing.T) {
+	cases := []struct {
+		quantity     float64
+		unit         string
+		system       System
+		expected     float64
+		expectedUnit string
+	}{
+		{quantity: 250, unit: "g", system: Imperial, expected: 8.818, expectedUnit: "oz"},
+		{quantity: 1, unit: "kg", system: Imperial, expected: 2.205, expectedUnit: "lb"},
+		{quantity: 10, unit: "ml", system: Imperial, expected: 2.029, expectedUnit: "tsp"},
+		{quantity: 25, unit: "cl", system: Imperial, expected: 1.057, expectedUnit: "cup"},
+		{quantity: 8, unit: "oz", system: Metric, expected: 226.796, expectedUnit: "g"},
+		{quantity: 1500, unit: "ml", system: Metric, expected: 1.5, expectedUnit: "l"},
+		{quantity: 2, unit: "slices", system: Metric, expected: 2, expectedUnit: "slices"},
+		{quantity: 250, unit: "g", system: "", expected: 250, expectedUnit: "g"},
+	}
+
+	for _, c := range cases {
+		quantity, unit := ToSystem(c.quantity, c.unit, c.system)
+		if !almostEqual(quantity, c.expected) || unit != c.expectedUnit {
+			t.Errorf("expected %v %s to be %v %s, got %v %s", c.quantity, c.unit, c.expected, c.expectedUnit, quantity, unit)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package user
+
+import (
+	"gorm.io/gorm"
+)
+
+// UserQuery defines which users should be listed and which page of them should be returned.
+type UserQuery struct {
+	// Role only keeps users having this role when it isn't empty.
+	Role Role
+	// Status only keeps users having this status when it isn't empty.
+	Status Status
+	// Page starts at 1.
+	Page     int
+	PageSize int
+}
+
+// RoleChange is the role an admin gives to a user.
+// @Description RoleChange is the role an admin gives to a user.
+type RoleChange struct {
+	Role Role `example:"cheddarexpert"`
+}
+
+// GetUsers takes a UserQuery and returns a page of users without their password, along with the total number of matching users.
+func (us *UserService) GetUsers(query UserQuery) ([]User, int64, error) {
+	users := []User{}
+	var total int64
+
+	filtered := us.db.Model(&User{})
+	if query.Role != "" {
+		filtered = filtered.Where("role = ?", query.Role)
+	}
+	if query.Status != "" {
+		filtered = filtered.Where("status = ?", query.Status)
+	}
+
+	if err := filtered.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := filtered.Omit("password").Order("id").Limit(query.PageSize).Offset((query.Page - 1) * query.PageSize).Find(&users).Error
+
+	return users, total, err
+}
+
+// updateUser updates a column of a user matching a condition, returning gorm.ErrRecordNotFound if there is none.
+func (us *UserService) updateUser(userID uint, condition string, column string, value interface{}) error {
+	result := us.db.Model(&User{}).Where("id = ?", userID)
+	if condition != "" {
+		result = result.Where(condition)
+	}
+
+	result = result.Update(column, value)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// SetRole takes the id of a user and gives him a role, returning gorm.ErrRecordNotFound if the user doesn't exist.
+func (us *UserService) SetRole(userID uint, role Role) error {
+	return us.updateUser(userID, "", "role", role)
+}
+
+// Suspend takes the id of a user who isn't suspended and suspends him, returning gorm.ErrRecordNotFound if there is no such user.
+func (us *UserService) Suspend(userID uint) error {
+	return us.updateUser(userID, "status <> 'suspended'", "status", Suspended)
+}
+
+// Reactivate takes the id of a suspended user and activates him again, returning gorm.ErrRecordNotFound if there is no such user.
+func (us *UserService) Reactivate(userID uint) error {
+	return us.updateUser(userID, "status = 'suspended'", "status", Active)
+}
+
+// DeleteUser takes the id of a user and soft deletes him, returning gorm.ErrRecordNotFound if he doesn't exist.
+// When anonymise is true, his username, email and password are erased along with the links to his identity providers,
+// so that what he authored is attributed to a deleted user instead of him.
+func (us *UserService) DeleteUser(userID uint, anonymise bool) error {
+	return us.db.Transaction(func(tx *gorm.DB) error {
+		if anonymise {
+			if err := anonymiseUser(tx, userID); err != nil {
+				return err
+			}
+
+			if err := tx.Where("user_id = ?", userID).Delete(&ExternalIdentity{}).Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Where("id = ?", userID).Delete(&User{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// GetSeededAdmin returns the first admin, the one created on the first start of the application, without his password.
+func (us *UserService) GetSeededAdmin() (User, error) {
+	var admin User
+
+	err := us.db.Omit("password").Where("role = ?", Admin).Order("id").First(&admin).Error
+
+	return admin, err
+}
@@ -0,0 +1,159 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/gorm"
+)
+
+func TestGetUsersSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE role = $1 AND status = $2 AND "users"."deleted_at" IS NULL`)).WithArgs("basicuser", "suspended").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(21))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE role = $1 AND status = $2 AND "users"."deleted_at" IS NULL ORDER BY id LIMIT 20 OFFSET 20`)).WithArgs("basicuser", "suspended").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "status"}).AddRow(21, "cam-amber", "basicuser", "suspended"))
+
+	users, total, err := userService.GetUsers(UserQuery{Role: BasicUser, Status: Suspended, Page: 2, PageSize: 20})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+
+	if total != 21 || len(users) != 1 || users[0].Username != "cam-amber" {
+		t.Errorf("expected the 21st of 21 users, got %d of %d", len(users), total)
+	}
+}
+
+func TestGetUsersSucceedWithoutFilter(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE "users"."deleted_at" IS NULL`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE "users"."deleted_at" IS NULL ORDER BY id LIMIT 20`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	users, total, err := userService.GetUsers(UserQuery{Page: 1, PageSize: 20})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+
+	if total != 0 || len(users) != 0 {
+		t.Errorf("expected no user, got %d of %d", len(users), total)
+	}
+}
+
+func TestStatusValidateFailOnUnknownStatus(t *testing.T) {
+	if err := Status("banned").Validate(); !errors.Is(err, ErrUnknownStatus) {
+		t.Errorf("expected %s, got %v", ErrUnknownStatus, err)
+	}
+}
+
+func TestSetRoleSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "role"=$1,"updated_at"=$2 WHERE id = $3 AND "users"."deleted_at" IS NULL`)).WithArgs("cheddarexpert", sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := userService.SetRole(3, CheddarExpert); err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+}
+
+func TestSetRoleFailOnUnknownUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "role"=$1,"updated_at"=$2 WHERE id = $3 AND "users"."deleted_at" IS NULL`)).WithArgs("cheddarexpert", sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := userService.SetRole(3, CheddarExpert); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected %s, got %v", gorm.ErrRecordNotFound, err)
+	}
+}
+
+func TestSuspendSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "status"=$1,"updated_at"=$2 WHERE id = $3 AND status <> 'suspended' AND "users"."deleted_at" IS NULL`)).WithArgs("suspended", sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := userService.Suspend(3); err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+}
+
+func TestReactivateFailOnActiveUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "status"=$1,"updated_at"=$2 WHERE id = $3 AND status = 'suspended' AND "users"."deleted_at" IS NULL`)).WithArgs("active", sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := userService.Reactivate(3); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected %s, got %v", gorm.ErrRecordNotFound, err)
+	}
+}
+
+func TestDeleteUserSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "deleted_at"=$1 WHERE id = $2 AND "users"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := userService.DeleteUser(3, false); err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+}
+
+func TestDeleteUserSucceedWithAnonymisation(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "email"=NULL,"password"=$1,"username"=$2,"updated_at"=$3 WHERE id = $4 AND "users"."deleted_at" IS NULL`)).WithArgs("", "deleted-user-3", sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "external_identities" WHERE user_id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "deleted_at"=$1 WHERE id = $2 AND "users"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := userService.DeleteUser(3, true); err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+}
+
+func TestDeleteUserFailOnUnknownUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "deleted_at"=$1 WHERE id = $2 AND "users"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if err := userService.DeleteUser(3, false); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected %s, got %v", gorm.ErrRecordNotFound, err)
+	}
+}
+
+func TestGetSeededAdminSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE role = $1 AND "users"."deleted_at" IS NULL ORDER BY id,"users"."id" LIMIT 1`)).WithArgs("admin").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "admin", "admin"))
+
+	admin, err := userService.GetSeededAdmin()
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+
+	if admin.ID != 1 {
+		t.Errorf("expected the admin 1, got %d", admin.ID)
+	}
+}
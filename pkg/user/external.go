@@ -0,0 +1,191 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjehanno/welsh-academy/pkg/recipe"
+	"gorm.io/gorm"
+)
+
+// ExternalIdentity links a user to his account at an identity provider.
+type ExternalIdentity struct {
+	// The issuer of the identity provider
+	Issuer string `gorm:"primaryKey;size:255"`
+	// The identifier of the account at the provider
+	Subject   string `gorm:"primaryKey;size:255"`
+	UserID    uint   `gorm:"not null;index"`
+	CreatedAt time.Time
+}
+
+// ExternalUser is a user authenticated by an identity provider.
+type ExternalUser struct {
+	Issuer  string
+	Subject string
+	// The username the provider knows the user by, used when creating him
+	Username string
+	Email    string
+	// Whether the provider verified the email, it is only trusted when it did
+	EmailVerified bool
+	// The role given by the groups of the user at the provider, the role of the user is kept when it's empty
+	Role Role
+}
+
+// availableUsername returns the username if it's free and isn't reserved, or the username followed by the first number making it so.
+func availableUsername(tx *gorm.DB, username string) (string, error) {
+	if runes := []rune(username); len(runes) > 34 {
+		username = string(runes[:34])
+	}
+
+	// a number wouldn't make a username looking like an anonymised one available
+	if strings.HasPrefix(strings.ToLower(username), recipe.AnonymisedUsernamePrefix) {
+		username = "user"
+	}
+
+	candidate := username
+	for i := 2; ; i++ {
+		if ValidateUsername(candidate) == nil {
+			var count int64
+			if err := tx.Model(&User{}).Unscoped().Where("username = ?", candidate).Count(&count).Error; err != nil {
+				return "", err
+			}
+
+			if count == 0 {
+				return candidate, nil
+			}
+		}
+
+		candidate = username + "-" + strconv.Itoa(i)
+	}
+}
+
+// createExternalUser creates an active user for an identity, with a random password since he logs in through the provider.
+func createExternalUser(tx *gorm.DB, external ExternalUser) (User, error) {
+	username := external.Username
+	if username == "" {
+		username, _, _ = strings.Cut(external.Email, "@")
+	}
+	if username == "" {
+		username = "user"
+	}
+
+	username, err := availableUsername(tx, username)
+	if err != nil {
+		return User{}, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return User{}, err
+	}
+
+	hash, err := HashPassword(base64.RawURLEncoding.EncodeToString(b))
+	if err != nil {
+		return User{}, err
+	}
+
+	role := external.Role
+	if role == "" {
+		role = BasicUser
+	}
+
+	created := User{Username: username, Password: hash, Role: role, Status: Active}
+
+	if external.EmailVerified && external.Email != "" {
+		var count int64
+		if err := tx.Model(&User{}).Unscoped().Where("email = ?", external.Email).Count(&count).Error; err != nil {
+			return User{}, err
+		}
+
+		if count == 0 {
+			created.Email = external.Email
+		}
+	}
+
+	if err := tx.Create(&created).Error; err != nil {
+		return User{}, err
+	}
+	created.Password = ""
+
+	return created, nil
+}
+
+// LoginExternal takes a user authenticated by an identity provider and returns the user linked to his identity.
+// On his first login, the identity is linked to the active user having the same verified email, or to a new user
+// replacing the pending user having this email if there is one. The role of the user is replaced by the one given by the provider when there is one.
+// It returns ErrAccountNotActive if the linked user isn't active.
+func (us *UserService) LoginExternal(external ExternalUser) (User, error) {
+	var linked User
+
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		var identity ExternalIdentity
+		err := tx.Where("issuer = ? AND subject = ?", external.Issuer, external.Subject).First(&identity).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		switch {
+		case err == nil:
+			err = tx.Omit("password").Where("id = ?", identity.UserID).First(&linked).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrAccountNotActive
+			}
+			if err != nil {
+				return err
+			}
+		case external.EmailVerified && external.Email != "":
+			err = tx.Omit("password").Where("email = ?", external.Email).First(&linked).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				if linked, err = createExternalUser(tx, external); err != nil {
+					return err
+				}
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			// a pending account proves nothing since anyone can register one with this email and his own password,
+			// so it is dropped and a new user is created for the owner of the email
+			if linked.Status == Pending {
+				if err := tx.Unscoped().Where("id = ?", linked.ID).Delete(&User{}).Error; err != nil {
+					return err
+				}
+
+				if linked, err = createExternalUser(tx, external); err != nil {
+					return err
+				}
+			}
+		default:
+			if linked, err = createExternalUser(tx, external); err != nil {
+				return err
+			}
+		}
+
+		if linked.Status != Active {
+			return ErrAccountNotActive
+		}
+
+		if identity.UserID == 0 {
+			err := tx.Create(&ExternalIdentity{Issuer: external.Issuer, Subject: external.Subject, UserID: linked.ID}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if external.Role != "" && external.Role != linked.Role {
+			if err := tx.Model(&User{}).Where("id = ?", linked.ID).Update("role", external.Role).Error; err != nil {
+				return err
+			}
+			linked.Role = external.Role
+		}
+
+		return nil
+	})
+
+	return linked, err
+}
@@ -0,0 +1,160 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/gorm"
+)
+
+const selectIdentity = `SELECT * FROM "external_identities" WHERE issuer = $1 AND subject = $2 ORDER BY "external_identities"."issuer" LIMIT 1`
+
+func TestLoginExternalSucceedWithLinkedIdentity(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectIdentity)).WithArgs("https://id.welsh.academy", "2f8e").WillReturnRows(sqlmock.NewRows([]string{"issuer", "subject", "user_id"}).AddRow("https://id.welsh.academy", "2f8e", 3))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE id = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "status"}).AddRow(3, "cam-amber", "basicuser", "active"))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "role"=$1,"updated_at"=$2 WHERE id = $3 AND "users"."deleted_at" IS NULL`)).WithArgs("cheddarexpert", sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	linked, err := userService.LoginExternal(ExternalUser{Issuer: "https://id.welsh.academy", Subject: "2f8e", Username: "cam", Role: CheddarExpert})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if linked.ID != 3 || linked.Role != CheddarExpert {
+		t.Errorf("expected user 3 to become a cheddar expert, got %+v", linked)
+	}
+}
+
+func TestLoginExternalSucceedLinkingVerifiedEmail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectIdentity)).WithArgs("https://id.welsh.academy", "2f8e").WillReturnRows(sqlmock.NewRows([]string{"issuer", "subject", "user_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "users" WHERE email = $1`)).WithArgs("cam-amber@welsh.academy").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "status"}).AddRow(3, "cam-amber", "basicuser", "active"))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "external_identities" ("issuer","subject","user_id","created_at") VALUES ($1,$2,$3,$4)`)).WithArgs("https://id.welsh.academy", "2f8e", 3, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	linked, err := userService.LoginExternal(ExternalUser{Issuer: "https://id.welsh.academy", Subject: "2f8e", Email: "cam-amber@welsh.academy", EmailVerified: true})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if linked.ID != 3 || linked.Status != Active || linked.Role != BasicUser {
+		t.Errorf("expected user 3 to be linked, got %+v", linked)
+	}
+}
+
+func TestLoginExternalSucceedReplacingPendingUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectIdentity)).WithArgs("https://id.welsh.academy", "2f8e").WillReturnRows(sqlmock.NewRows([]string{"issuer", "subject", "user_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "users" WHERE email = $1`)).WithArgs("cam-amber@welsh.academy").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "status"}).AddRow(3, "cam-amber", "basicuser", "pending"))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "users" WHERE id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE username = $1`)).WithArgs("cam-amber").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE email = $1`)).WithArgs("cam-amber@welsh.academy").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users" ("created_at","updated_at","deleted_at","role","status","username","password","email") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "basicuser", "active", "cam-amber", sqlmock.AnyArg(), "cam-amber@welsh.academy").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "external_identities"`)).WithArgs("https://id.welsh.academy", "2f8e", 8, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	linked, err := userService.LoginExternal(ExternalUser{Issuer: "https://id.welsh.academy", Subject: "2f8e", Username: "cam-amber", Email: "cam-amber@welsh.academy", EmailVerified: true})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if linked.ID != 8 || linked.Status != Active || linked.Email != "cam-amber@welsh.academy" {
+		t.Errorf("expected the pending user to be replaced by user 8, got %+v", linked)
+	}
+}
+
+func TestLoginExternalSucceedCreatingUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectIdentity)).WithArgs("https://id.welsh.academy", "2f8e").WillReturnRows(sqlmock.NewRows([]string{"issuer", "subject", "user_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE username = $1`)).WithArgs("cam-amber").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE username = $1`)).WithArgs("cam-amber-2").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users" ("created_at","updated_at","deleted_at","role","status","username","password") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id","username","password","email"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "admin", "active", "cam-amber-2", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "external_identities"`)).WithArgs("https://id.welsh.academy", "2f8e", 7, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	linked, err := userService.LoginExternal(ExternalUser{Issuer: "https://id.welsh.academy", Subject: "2f8e", Username: "cam-amber", Email: "cam-amber@welsh.academy", Role: Admin})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if linked.ID != 7 || linked.Username != "cam-amber-2" || linked.Email != "" || linked.Password != "" {
+		t.Errorf("expected cam-amber-2 to be created without the unverified email, got %+v", linked)
+	}
+}
+
+func TestLoginExternalSucceedAvoidingReservedUsername(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectIdentity)).WithArgs("https://id.welsh.academy", "2f8e").WillReturnRows(sqlmock.NewRows([]string{"issuer", "subject", "user_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE username = $1`)).WithArgs("me-2").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "basicuser", "active", "me-2", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "external_identities"`)).WithArgs("https://id.welsh.academy", "2f8e", 7, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	linked, err := userService.LoginExternal(ExternalUser{Issuer: "https://id.welsh.academy", Subject: "2f8e", Username: "me"})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if linked.Username != "me-2" {
+		t.Errorf("expected me-2 to be created, got %+v", linked)
+	}
+}
+
+func TestLoginExternalSucceedTruncatingLongUsername(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	username := "fromager-" + strings.Repeat("é", 30)
+	truncated := "fromager-" + strings.Repeat("é", 25)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectIdentity)).WithArgs("https://id.welsh.academy", "2f8e").WillReturnRows(sqlmock.NewRows([]string{"issuer", "subject", "user_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE username = $1`)).WithArgs(truncated).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "basicuser", "active", truncated, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "external_identities"`)).WithArgs("https://id.welsh.academy", "2f8e", 7, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	linked, err := userService.LoginExternal(ExternalUser{Issuer: "https://id.welsh.academy", Subject: "2f8e", Username: username})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if linked.Username != truncated || !utf8.ValidString(linked.Username) {
+		t.Errorf("expected the username to be truncated to 34 characters, got %q", linked.Username)
+	}
+}
+
+func TestLoginExternalFailOnDeletedUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectIdentity)).WithArgs("https://id.welsh.academy", "2f8e").WillReturnRows(sqlmock.NewRows([]string{"issuer", "subject", "user_id"}).AddRow("https://id.welsh.academy", "2f8e", 3))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "users" WHERE id = $1`)).WithArgs(3).WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	_, err := userService.LoginExternal(ExternalUser{Issuer: "https://id.welsh.academy", Subject: "2f8e"})
+	if !errors.Is(err, ErrAccountNotActive) {
+		t.Errorf("expected an account not active error, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package user
+
+import (
+	"time"
+
+	"github.com/mjehanno/welsh-academy/pkg/ingredient"
+	"gorm.io/gorm"
+)
+
+// PantryItem is an ingredient a user has at home.
+// @Description PantryItem is an ingredient a user has at home.
+type PantryItem struct {
+	gorm.Model
+	// The owner of the item
+	UserID uint `gorm:"not null;uniqueIndex:idx_pantry_user_ingredient" swaggerignore:"true"`
+	// The ingredient in the pantry
+	IngredientID uint `gorm:"not null;uniqueIndex:idx_pantry_user_ingredient" example:"1"`
+	Ingredient   ingredient.Ingredient
+	// The amount of ingredient left, 0 when unknown
+	Quantity float64 `example:"400"`
+	// The unit in which the quantity is expressed
+	Unit string `gorm:"size:20" example:"g"`
+	// When the ingredient expires
+	ExpiresAt *time.Time `example:"2022-11-05T00:00:00Z"`
+	// Whether the ingredient expires soon (or is already expired)
+	ExpiringSoon bool `gorm:"-"`
+}
+
+// Expired tells if the item is expired at the given time.
+func (p *PantryItem) Expired(now time.Time) bool {
+	return p.ExpiresAt != nil && p.ExpiresAt.Before(now)
+}
+
+// GetPantry takes a userID and returns his pantry, the items expiring first coming first.
+// Items expiring before now + expiringWithin are flagged as expiring soon.
+func (us *UserService) GetPantry(userID uint, expiringWithin time.Duration) ([]PantryItem, error) {
+	var items []PantryItem
+
+	result := us.db.Where("user_id = ?", userID).Order("expires_at ASC NULLS LAST, id").Preload("Ingredient").Find(&items)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	limit := time.Now().Add(expiringWithin)
+	for i := range items {
+		items[i].ExpiringSoon = items[i].ExpiresAt != nil && items[i].ExpiresAt.Before(limit)
+	}
+
+	return items, nil
+}
+
+// GetPantryIngredients takes a userID and returns the ingredients of his pantry that aren't expired.
+func (us *UserService) GetPantryIngredients(userID uint) ([]ingredient.Ingredient, error) {
+	items, err := us.GetPantry(userID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ingredients := make([]ingredient.Ingredient, 0, len(items))
+	for _, item := range items {
+		if !item.Expired(now) {
+			ingredients = append(ingredients, item.Ingredient)
+		}
+	}
+
+	return ingredients, nil
+}
+
+// AddPantryItem takes a pantry item and insert it in database, it returns the id of inserted item or an error.
+func (us *UserService) AddPantryItem(item PantryItem) (uint, error) {
+	result := us.db.Omit("Ingredient").Create(&item)
+
+	return item.ID, result.Error
+}
+
+// UpdatePantryItem takes a pantry item and updates the quantity, unit and expiry date of the item with the same ID owned by the same user.
+// It returns gorm.ErrRecordNotFound if the user doesn't own such an item.
+func (us *UserService) UpdatePantryItem(item PantryItem) error {
+	result := us.db.Model(&PantryItem{}).Where("id = ?", item.ID).Where("user_id = ?", item.UserID).Select("quantity", "unit", "expires_at").Updates(&item)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeletePantryItem takes a userID and an item ID and removes the item from the user's pantry.
+// It returns gorm.ErrRecordNotFound if the user doesn't own such an item.
+func (us *UserService) DeletePantryItem(userID uint, itemID uint) error {
+	result := us.db.Unscoped().Where("user_id = ?", userID).Delete(&PantryItem{}, itemID)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
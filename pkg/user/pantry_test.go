@@ -0,0 +1,155 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/gorm"
+)
+
+func TestGetPantrySucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	tomorrow := time.Now().Add(24 * time.Hour)
+	nextMonth := time.Now().Add(30 * 24 * time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "pantry_items" WHERE user_id = $1 AND "pantry_items"."deleted_at" IS NULL ORDER BY expires_at ASC NULLS LAST, id`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "ingredient_id", "expires_at"}).AddRow(1, 1, 1, tomorrow).AddRow(2, 1, 2, nextMonth).AddRow(3, 1, 3, nil))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2,$3) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 2, 3).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune").AddRow(3, "pain"))
+
+	items, err := userService.GetPantry(1, 72*time.Hour)
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(items) != 3 || !items[0].ExpiringSoon || items[1].ExpiringSoon || items[2].ExpiringSoon {
+		t.Errorf("expected only cheddar to expire soon, got %v", items)
+	}
+}
+
+func TestGetPantryIngredientsSkipsExpiredItems(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	yesterday := time.Now().Add(-24 * time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "pantry_items" WHERE user_id = $1 AND "pantry_items"."deleted_at" IS NULL ORDER BY expires_at ASC NULLS LAST, id`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "ingredient_id", "expires_at"}).AddRow(1, 1, 1, yesterday).AddRow(2, 1, 2, nil))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune"))
+
+	ingredients, err := userService.GetPantryIngredients(1)
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(ingredients) != 1 || ingredients[0].Name != "bière brune" {
+		t.Errorf("expected expired cheddar to be skipped, got %v", ingredients)
+	}
+}
+
+func TestGetPantryFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "pantry_items" WHERE user_id = $1 AND "pantry_items"."deleted_at" IS NULL ORDER BY expires_at ASC NULLS LAST, id`)).WithArgs(1).WillReturnError(fmt.Errorf("connection lost"))
+
+	_, err := userService.GetPantry(1, 72*time.Hour)
+	if err == nil {
+		t.Error("error did not occured while it should have")
+	}
+}
+
+func TestAddPantryItemSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	any := sqlmock.AnyArg()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "pantry_items" ("created_at","updated_at","deleted_at","user_id","ingredient_id","quantity","unit","expires_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(any, any, any, 1, 1, 400.0, "g", nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	id, err := userService.AddPantryItem(PantryItem{UserID: 1, IngredientID: 1, Quantity: 400, Unit: "g"})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if id != 1 {
+		t.Errorf("expected item id 1, got %d", id)
+	}
+}
+
+func TestAddPantryItemFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	any := sqlmock.AnyArg()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "pantry_items" ("created_at","updated_at","deleted_at","user_id","ingredient_id","quantity","unit","expires_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(any, any, any, 1, 1, 0.0, "", nil).WillReturnError(fmt.Errorf("duplicate key value violates unique constraint"))
+	mock.ExpectRollback()
+
+	_, err := userService.AddPantryItem(PantryItem{UserID: 1, IngredientID: 1})
+	if err == nil {
+		t.Error("error did not occured while it should have")
+	}
+}
+
+func TestUpdatePantryItemSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "pantry_items" SET "updated_at"=$1,"quantity"=$2,"unit"=$3,"expires_at"=$4 WHERE id = $5 AND user_id = $6 AND "pantry_items"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 200.0, "g", nil, 1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := userService.UpdatePantryItem(PantryItem{Model: gorm.Model{ID: 1}, UserID: 1, Quantity: 200, Unit: "g"})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestUpdatePantryItemFailOnOtherUserItem(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "pantry_items" SET "updated_at"=$1,"quantity"=$2,"unit"=$3,"expires_at"=$4 WHERE id = $5 AND user_id = $6 AND "pantry_items"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 200.0, "g", nil, 1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := userService.UpdatePantryItem(PantryItem{Model: gorm.Model{ID: 1}, UserID: 2, Quantity: 200, Unit: "g"})
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestDeletePantryItemSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "pantry_items" WHERE user_id = $1 AND "pantry_items"."id" = $2`)).WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := userService.DeletePantryItem(1, 1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestDeletePantryItemFail(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "pantry_items" WHERE user_id = $1 AND "pantry_items"."id" = $2`)).WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := userService.DeletePantryItem(2, 1)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
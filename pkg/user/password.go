@@ -0,0 +1,89 @@
+package user
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the minimum length of the passwords users choose.
+const MinPasswordLength = 8
+
+// ErrWrongPassword is returned when the current password given to change it doesn't match.
+var ErrWrongPassword = errors.New("the current password is wrong")
+
+// passwordCost is the bcrypt cost used to hash new passwords, stored hashes with a lower cost are upgraded on login.
+var passwordCost = bcrypt.DefaultCost
+
+// dummyHash is compared when a user doesn't exist so that a login takes the same time whether the username exists or not.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("welsh-academy"), bcrypt.DefaultCost)
+
+// HashPassword takes a plain text password and returns its salted bcrypt hash.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+
+	return string(hash), err
+}
+
+// isLegacyHash tells if a stored hash is an unsalted SHA-256 hex digest, the way passwords used to be stored.
+func isLegacyHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+
+	return err == nil
+}
+
+// CheckPassword takes a stored hash and a plain text password and tells if they match, and if so if the hash should be
+// replaced because it was made with a legacy algorithm or a lower cost.
+func CheckPassword(hash string, password string) (match bool, needsRehash bool) {
+	if isLegacyHash(hash) {
+		digest := sha256.Sum256([]byte(password))
+		match = subtle.ConstantTimeCompare([]byte(hex.EncodeToString(digest[:])), []byte(hash)) == 1
+
+		return match, match
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return false, false
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+
+	return true, err != nil || cost < passwordCost
+}
+
+// PasswordChange is what a user gives to change his password.
+// @Description PasswordChange is what a user gives to change his password.
+type PasswordChange struct {
+	// The password the user currently has
+	CurrentPassword string `example:"mytopsecretpassword"`
+	// The password the user wants, at least 8 characters long
+	NewPassword string `example:"myevenmoresecretpassword"`
+}
+
+// ChangePassword takes the id of a user, his current password and the new one, and replaces his password.
+// It returns ErrWrongPassword if the current password doesn't match.
+func (us *UserService) ChangePassword(userID uint, current string, newPassword string) error {
+	var dbUser User
+
+	if err := us.db.Select("id", "password").Where("id = ?", userID).First(&dbUser).Error; err != nil {
+		return err
+	}
+
+	if match, _ := CheckPassword(dbUser.Password, current); !match {
+		return ErrWrongPassword
+	}
+
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	return us.db.Model(&User{}).Where("id = ?", userID).Update("password", hash).Error
+}
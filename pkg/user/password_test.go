@@ -0,0 +1,44 @@
+package user
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPasswordSucceed(t *testing.T) {
+	hash, err := HashPassword("mytopsecretpassword")
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if match, needsRehash := CheckPassword(hash, "mytopsecretpassword"); !match || needsRehash {
+		t.Errorf("expected the password to match without rehash, got %v %v", match, needsRehash)
+	}
+}
+
+func TestCheckPasswordSucceedWithLegacyHash(t *testing.T) {
+	if match, needsRehash := CheckPassword("5de4c437b552985b0fa4a9566a60d767ab89310343e4c5e3d7a373bc1b68747b", "mytopsecretpassword"); !match || !needsRehash {
+		t.Errorf("expected the legacy password to match and to need a rehash, got %v %v", match, needsRehash)
+	}
+}
+
+func TestCheckPasswordSucceedWithLowerCost(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("mytopsecretpassword"), bcrypt.MinCost)
+
+	if match, needsRehash := CheckPassword(string(hash), "mytopsecretpassword"); !match || !needsRehash {
+		t.Errorf("expected the password to match and to need a rehash, got %v %v", match, needsRehash)
+	}
+}
+
+func TestCheckPasswordFail(t *testing.T) {
+	hash, _ := HashPassword("mytopsecretpassword")
+
+	if match, _ := CheckPassword(hash, "mynotsosecretpassword"); match {
+		t.Error("expected the password not to match")
+	}
+
+	if match, _ := CheckPassword("5de4c437b552985b0fa4a9566a60d767ab89310343e4c5e3d7a373bc1b68747b", "mynotsosecretpassword"); match {
+		t.Error("expected the legacy password not to match")
+	}
+}
@@ -0,0 +1,221 @@
+package user
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/mjehanno/welsh-academy/pkg/recipe"
+	"gorm.io/gorm"
+)
+
+// PersonalData is a kind of data linked to users, exported when they ask for their data and erased along with their account.
+// Packages storing data linked to users register it with UserService.RegisterPersonalData.
+type PersonalData struct {
+	// The name of the JSON file the data is exported to, without extension
+	Name string
+	// Export returns the data of a user, the data is erased without being exported when it's nil
+	Export func(tx *gorm.DB, u User) (interface{}, error)
+	// Erase deletes the data of a user, the data is kept when it's nil like the recipes shared with everyone
+	Erase func(tx *gorm.DB, u User) error
+}
+
+// ExportFile is a file of the data exported for a user.
+type ExportFile struct {
+	// The name of the file, without extension
+	Name string
+	Data interface{}
+}
+
+// twoFactorExport is what is exported of the second factor of a user, his secret and recovery codes being left out.
+type twoFactorExport struct {
+	Enabled            bool
+	RecoveryCodesCount int64
+}
+
+// passwordResetExport is a password reset a user asked for.
+type passwordResetExport struct {
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// eraseWhereUser returns an Erase function hard deleting the rows of a model linked to a user by a user_id column.
+func eraseWhereUser(model interface{}) func(tx *gorm.DB, u User) error {
+	return func(tx *gorm.DB, u User) error {
+		return tx.Unscoped().Where("user_id = ?", u.ID).Delete(model).Error
+	}
+}
+
+// userPersonalData returns the personal data stored by this package, the account itself excepted.
+func userPersonalData() []PersonalData {
+	return []PersonalData{
+		{
+			Name: "profile",
+			Export: func(tx *gorm.DB, u User) (interface{}, error) {
+				profile, err := getProfile(tx, u.ID)
+
+				return Account{User: u, Profile: profile}, err
+			},
+			Erase: eraseWhereUser(&Profile{}),
+		},
+		{
+			Name: "favorites",
+			Export: func(tx *gorm.DB, u User) (interface{}, error) {
+				recipes := []recipe.Recipe{}
+				err := tx.Preload("Ingredients.Ingredient").Model(&u).Association("FavoritesRecipes").Find(&recipes)
+
+				return recipes, err
+			},
+			Erase: func(tx *gorm.DB, u User) error {
+				return tx.Exec("DELETE FROM favorite_recipe WHERE user_id = ?", u.ID).Error
+			},
+		},
+		{
+			Name: "recipes",
+			Export: func(tx *gorm.DB, u User) (interface{}, error) {
+				recipes := []recipe.Recipe{}
+				err := tx.Preload("Ingredients.Ingredient").Preload("Steps", func(db *gorm.DB) *gorm.DB {
+					return db.Order("position")
+				}).Where("author_id = ?", u.ID).Order("id").Find(&recipes).Error
+
+				return recipes, err
+			},
+		},
+		{
+			Name: "pantry",
+			Export: func(tx *gorm.DB, u User) (interface{}, error) {
+				items := []PantryItem{}
+				err := tx.Preload("Ingredient").Where("user_id = ?", u.ID).Order("id").Find(&items).Error
+
+				return items, err
+			},
+			Erase: eraseWhereUser(&PantryItem{}),
+		},
+		{
+			Name: "two-factor",
+			Export: func(tx *gorm.DB, u User) (interface{}, error) {
+				var export twoFactorExport
+				var enabled int64
+
+				if err := tx.Model(&TwoFactor{}).Where("user_id = ? AND enabled", u.ID).Count(&enabled).Error; err != nil {
+					return nil, err
+				}
+				export.Enabled = enabled > 0
+
+				err := tx.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", u.ID).Count(&export.RecoveryCodesCount).Error
+
+				return export, err
+			},
+			Erase: func(tx *gorm.DB, u User) error {
+				if err := tx.Where("user_id = ?", u.ID).Delete(&RecoveryCode{}).Error; err != nil {
+					return err
+				}
+
+				return tx.Where("user_id = ?", u.ID).Delete(&TwoFactor{}).Error
+			},
+		},
+		{
+			Name: "password-resets",
+			Export: func(tx *gorm.DB, u User) (interface{}, error) {
+				resets := []passwordResetExport{}
+				err := tx.Model(&PasswordResetToken{}).Where("user_id = ?", u.ID).Order("id").Find(&resets).Error
+
+				return resets, err
+			},
+			Erase: eraseWhereUser(&PasswordResetToken{}),
+		},
+		{
+			Name: "external-identities",
+			Export: func(tx *gorm.DB, u User) (interface{}, error) {
+				identities := []ExternalIdentity{}
+				err := tx.Where("user_id = ?", u.ID).Order("created_at").Find(&identities).Error
+
+				return identities, err
+			},
+			Erase: eraseWhereUser(&ExternalIdentity{}),
+		},
+	}
+}
+
+// RegisterPersonalData adds kinds of data linked to users to the ones exported and erased with them.
+// It must be called before the service is used.
+func (us *UserService) RegisterPersonalData(data ...PersonalData) {
+	us.personalData = append(us.personalData, data...)
+}
+
+// ExportPersonalData takes the id of a user and returns every data linked to him, one file per kind of data.
+// It returns gorm.ErrRecordNotFound if the user doesn't exist.
+func (us *UserService) ExportPersonalData(userID uint) ([]ExportFile, error) {
+	var files []ExportFile
+
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		var u User
+		if err := tx.Omit("password").Where("id = ?", userID).First(&u).Error; err != nil {
+			return err
+		}
+
+		for _, data := range us.personalData {
+			if data.Export == nil {
+				continue
+			}
+
+			exported, err := data.Export(tx, u)
+			if err != nil {
+				return err
+			}
+
+			files = append(files, ExportFile{Name: data.Name, Data: exported})
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+// anonymiseUser erases the username, email and password of a user, so that the recipes he shared are attributed to a deleted user.
+// It returns gorm.ErrRecordNotFound if the user doesn't exist.
+func anonymiseUser(tx *gorm.DB, userID uint) error {
+	result := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"username": recipe.AnonymisedUsernamePrefix + strconv.FormatUint(uint64(userID), 10),
+		"email":    gorm.Expr("NULL"),
+		"password": "",
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ErasePersonalData takes the id of a user and deletes his account along with every data linked to him in a single transaction.
+// The account is anonymised and soft deleted so that the recipes he shared stay attributed to a deleted user.
+// It returns gorm.ErrRecordNotFound if the user doesn't exist.
+func (us *UserService) ErasePersonalData(userID uint) error {
+	return us.db.Transaction(func(tx *gorm.DB) error {
+		var u User
+		if err := tx.Omit("password").Where("id = ?", userID).First(&u).Error; err != nil {
+			return err
+		}
+
+		for _, data := range us.personalData {
+			if data.Erase == nil {
+				continue
+			}
+
+			if err := data.Erase(tx, u); err != nil {
+				return err
+			}
+		}
+
+		if err := anonymiseUser(tx, userID); err != nil {
+			return err
+		}
+
+		return tx.Where("id = ?", userID).Delete(&User{}).Error
+	})
+}
@@ -0,0 +1,92 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/gorm"
+)
+
+func TestExportPersonalDataSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	userService.personalData = nil
+	userService.RegisterPersonalData(PersonalData{
+		Name: "notes",
+		Export: func(tx *gorm.DB, u User) (interface{}, error) {
+			return []string{"notes of " + u.Username}, nil
+		},
+	}, PersonalData{Name: "secrets"})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE id = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(3, "cam-amber"))
+	mock.ExpectCommit()
+
+	files, err := userService.ExportPersonalData(3)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+
+	if len(files) != 1 || files[0].Name != "notes" || files[0].Data.([]string)[0] != "notes of cam-amber" {
+		t.Errorf("expected only the notes of cam-amber to be exported, got %+v", files)
+	}
+}
+
+func TestExportPersonalDataFailOnUnknownUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "users" WHERE id = $1`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	if _, err := userService.ExportPersonalData(3); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected %s, got %v", gorm.ErrRecordNotFound, err)
+	}
+}
+
+func TestErasePersonalDataSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "users" WHERE id = $1`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(3, "cam-amber"))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "profiles" WHERE user_id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM favorite_recipe WHERE user_id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "pantry_items" WHERE user_id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "recovery_codes" WHERE user_id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "two_factors" WHERE user_id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "password_reset_tokens" WHERE user_id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "external_identities" WHERE user_id = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "email"=NULL,"password"=$1,"username"=$2,"updated_at"=$3 WHERE id = $4 AND "users"."deleted_at" IS NULL`)).WithArgs("", "deleted-user-3", sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "deleted_at"=$1 WHERE id = $2 AND "users"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := userService.ErasePersonalData(3); err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+}
+
+func TestErasePersonalDataFailOnError(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	userService.personalData = nil
+	userService.RegisterPersonalData(PersonalData{
+		Name: "notes",
+		Erase: func(tx *gorm.DB, u User) error {
+			return errors.New("can't erase notes")
+		},
+	})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "users" WHERE id = $1`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(3, "cam-amber"))
+	mock.ExpectRollback()
+
+	if err := userService.ErasePersonalData(3); err == nil {
+		t.Errorf("expected the erasure to fail and be rolled back")
+	}
+}
@@ -0,0 +1,215 @@
+package user
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mjehanno/welsh-academy/pkg/recipe"
+	"github.com/mjehanno/welsh-academy/pkg/units"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrDisplayNameTooLong is returned when a display name is longer than 60 characters.
+var ErrDisplayNameTooLong = errors.New("display name can't be longer than 60 characters")
+
+// ErrBioTooLong is returned when a bio is longer than 500 characters.
+var ErrBioTooLong = errors.New("bio can't be longer than 500 characters")
+
+// ErrInvalidAvatar is returned when an avatar isn't an http or https URL.
+var ErrInvalidAvatar = errors.New("avatar must be an http or https URL of at most 2048 characters")
+
+// ErrInvalidLanguage is returned when a language isn't a language tag like en or cy-GB.
+var ErrInvalidLanguage = errors.New("language must be a language tag, e.g. en or cy-GB")
+
+var languageTag = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8}){0,3}$`)
+
+// Profile holds what a user tells about himself and his preferences.
+// @Description Profile holds what a user tells about himself and his preferences.
+type Profile struct {
+	UserID uint `gorm:"primaryKey" json:"-"`
+	// The name shown instead of the username when it isn't empty
+	DisplayName string `gorm:"size:60" example:"Cam Amber"`
+	// A few words about the user
+	Bio string `gorm:"size:500" example:"Rarebit enthusiast from Cardiff"`
+	// The URL of the picture of the user
+	Avatar string `gorm:"size:2048" example:"https://welsh.academy/avatars/cam-amber.png"`
+	// The units recipes are shown in, empty to keep the units of the recipes
+	Units units.System `gorm:"size:20" example:"metric"`
+	// The language the user prefers
+	Language string `gorm:"size:35" example:"cy-GB"`
+	// Whether the favorites of the user are shown on his public profile
+	PublicFavorites bool      `example:"true"`
+	UpdatedAt       time.Time `json:"-"`
+}
+
+// ProfileUpdate holds the fields of a profile to change, fields left out are kept.
+// @Description ProfileUpdate holds the fields of a profile to change, fields left out are kept.
+type ProfileUpdate struct {
+	DisplayName     *string       `example:"Cam Amber"`
+	Bio             *string       `example:"Rarebit enthusiast from Cardiff"`
+	Avatar          *string       `example:"https://welsh.academy/avatars/cam-amber.png"`
+	Units           *units.System `example:"metric"`
+	Language        *string       `example:"cy-GB"`
+	PublicFavorites *bool         `example:"true"`
+}
+
+// Account is the logged user along with his profile.
+// @Description Account is the logged user along with his profile.
+type Account struct {
+	User
+	Profile Profile
+}
+
+// PublicProfile is what anyone can see of a user, along with the recipes he created.
+// @Description PublicProfile is what anyone can see of a user, along with the recipes he created.
+type PublicProfile struct {
+	Username    string    `example:"cam-amber"`
+	DisplayName string    `json:",omitempty" example:"Cam Amber"`
+	Bio         string    `json:",omitempty" example:"Rarebit enthusiast from Cardiff"`
+	Avatar      string    `json:",omitempty" example:"https://welsh.academy/avatars/cam-amber.png"`
+	MemberSince time.Time `example:"2022-11-02T10:00:00Z"`
+	// The recipes the user created
+	Recipes []recipe.Recipe
+	// The favorites of the user, only listed when he made them public
+	Favorites []recipe.Recipe `json:",omitempty"`
+}
+
+// Validate checks every field of the profile.
+func (p Profile) Validate() error {
+	if utf8.RuneCountInString(p.DisplayName) > 60 {
+		return ErrDisplayNameTooLong
+	}
+
+	if utf8.RuneCountInString(p.Bio) > 500 {
+		return ErrBioTooLong
+	}
+
+	if p.Avatar != "" {
+		avatar, err := url.Parse(p.Avatar)
+		if err != nil || (avatar.Scheme != "http" && avatar.Scheme != "https") || avatar.Host == "" || len(p.Avatar) > 2048 {
+			return ErrInvalidAvatar
+		}
+	}
+
+	if _, err := units.ParseSystem(string(p.Units)); err != nil {
+		return err
+	}
+
+	if p.Language != "" && !languageTag.MatchString(p.Language) {
+		return ErrInvalidLanguage
+	}
+
+	return nil
+}
+
+// apply changes the fields of the profile given by the update.
+func (p *Profile) apply(update ProfileUpdate) {
+	if update.DisplayName != nil {
+		p.DisplayName = *update.DisplayName
+	}
+	if update.Bio != nil {
+		p.Bio = *update.Bio
+	}
+	if update.Avatar != nil {
+		p.Avatar = *update.Avatar
+	}
+	if update.Units != nil {
+		p.Units = *update.Units
+	}
+	if update.Language != nil {
+		p.Language = *update.Language
+	}
+	if update.PublicFavorites != nil {
+		p.PublicFavorites = *update.PublicFavorites
+	}
+}
+
+// getProfile returns the profile of a user, or an empty one if he never filled it.
+func getProfile(tx *gorm.DB, userID uint) (Profile, error) {
+	var profile Profile
+
+	err := tx.Where("user_id = ?", userID).First(&profile).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Profile{UserID: userID}, nil
+	}
+
+	return profile, err
+}
+
+// GetAccount takes the id of a user and returns him without his password along with his profile.
+func (us *UserService) GetAccount(userID uint) (Account, error) {
+	var account Account
+	var err error
+
+	if account.User, err = us.GetUser(userID); err != nil {
+		return account, err
+	}
+
+	account.Profile, err = getProfile(us.db, userID)
+
+	return account, err
+}
+
+// UpdateProfile takes the id of a user and changes the fields of his profile given by the update, returning the updated profile.
+// It returns a validation error such as ErrInvalidAvatar if the updated profile isn't valid.
+func (us *UserService) UpdateProfile(userID uint, update ProfileUpdate) (Profile, error) {
+	var profile Profile
+
+	err := us.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if profile, err = getProfile(tx, userID); err != nil {
+			return err
+		}
+
+		profile.apply(update)
+		if err := profile.Validate(); err != nil {
+			return err
+		}
+
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&profile).Error
+	})
+
+	return profile, err
+}
+
+// GetPublicProfile takes a username and returns the public profile of the active user having it, or gorm.ErrRecordNotFound.
+func (us *UserService) GetPublicProfile(username string) (PublicProfile, error) {
+	var public PublicProfile
+	var owner User
+
+	err := us.db.Omit("password").Where("username = ? AND status = ?", username, Active).First(&owner).Error
+	if err != nil {
+		return public, err
+	}
+
+	profile, err := getProfile(us.db, owner.ID)
+	if err != nil {
+		return public, err
+	}
+
+	public = PublicProfile{
+		Username:    owner.Username,
+		DisplayName: profile.DisplayName,
+		Bio:         profile.Bio,
+		Avatar:      profile.Avatar,
+		MemberSince: owner.CreatedAt,
+		Recipes:     []recipe.Recipe{},
+	}
+
+	err = us.db.Preload("Ingredients.Ingredient").Where("author_id = ?", owner.ID).Order("id").Find(&public.Recipes).Error
+	if err != nil {
+		return public, err
+	}
+
+	if profile.PublicFavorites {
+		if public.Favorites, err = us.GetFavoriteRecipe(owner.ID); err != nil {
+			return public, err
+		}
+	}
+
+	return public, nil
+}
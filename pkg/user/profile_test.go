@@ -0,0 +1,107 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mjehanno/welsh-academy/pkg/units"
+	"gorm.io/gorm"
+)
+
+func TestProfileValidateSucceed(t *testing.T) {
+	profile := Profile{DisplayName: "Cam Amber", Avatar: "https://welsh.academy/avatars/cam-amber.png", Units: units.Imperial, Language: "cy-GB"}
+
+	if err := profile.Validate(); err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+}
+
+func TestProfileValidateFail(t *testing.T) {
+	cases := map[string]struct {
+		profile  Profile
+		expected error
+	}{
+		"long display name": {Profile{DisplayName: strings.Repeat("a", 61)}, ErrDisplayNameTooLong},
+		"long bio":          {Profile{Bio: strings.Repeat("a", 501)}, ErrBioTooLong},
+		"relative avatar":   {Profile{Avatar: "/avatars/cam-amber.png"}, ErrInvalidAvatar},
+		"javascript avatar": {Profile{Avatar: "javascript:alert(1)"}, ErrInvalidAvatar},
+		"unknown units":     {Profile{Units: "nautical"}, units.ErrUnknownSystem},
+		"invalid language":  {Profile{Language: "welsh language"}, ErrInvalidLanguage},
+	}
+
+	for name, c := range cases {
+		if err := c.profile.Validate(); !errors.Is(err, c.expected) {
+			t.Errorf("%s : expected %s, got %v", name, c.expected, err)
+		}
+	}
+}
+
+func TestUpdateProfileSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "profiles" WHERE user_id = $1 ORDER BY "profiles"."user_id" LIMIT 1`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"user_id", "display_name", "bio"}).AddRow(3, "Cam", "Rarebit enthusiast"))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "profiles" ("display_name","bio","avatar","units","language","public_favorites","updated_at","user_id") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) ON CONFLICT ("user_id") DO UPDATE SET "updated_at"=$9,"display_name"="excluded"."display_name","bio"="excluded"."bio","avatar"="excluded"."avatar","units"="excluded"."units","language"="excluded"."language","public_favorites"="excluded"."public_favorites" RETURNING "user_id"`)).WithArgs("Cam Amber", "Rarebit enthusiast", "", "", "", true, sqlmock.AnyArg(), 3, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectCommit()
+
+	displayName := "Cam Amber"
+	public := true
+
+	profile, err := userService.UpdateProfile(3, ProfileUpdate{DisplayName: &displayName, PublicFavorites: &public})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+
+	if profile.DisplayName != "Cam Amber" || profile.Bio != "Rarebit enthusiast" || !profile.PublicFavorites {
+		t.Errorf("expected the display name and favorites to change and the bio to be kept, got %+v", profile)
+	}
+}
+
+func TestUpdateProfileFailOnInvalidProfile(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "profiles" WHERE user_id = $1`)).WithArgs(3).WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	language := "welsh language"
+
+	if _, err := userService.UpdateProfile(3, ProfileUpdate{Language: &language}); !errors.Is(err, ErrInvalidLanguage) {
+		t.Errorf("expected %s, got %v", ErrInvalidLanguage, err)
+	}
+}
+
+func TestGetPublicProfileSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE (username = $1 AND status = $2) AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs("cam-amber", "active").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "status"}).AddRow(3, "cam-amber", "cam-amber@welsh.academy", "active"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "profiles" WHERE user_id = $1`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"user_id", "display_name", "public_favorites"}).AddRow(3, "Cam Amber", false))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipes" WHERE author_id = $1 AND "recipes"."deleted_at" IS NULL ORDER BY id`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "author_id"}).AddRow(1, "welsh", 3))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id"}))
+
+	profile, err := userService.GetPublicProfile("cam-amber")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err)
+	}
+
+	if profile.Username != "cam-amber" || profile.DisplayName != "Cam Amber" || len(profile.Recipes) != 1 || profile.Favorites != nil {
+		t.Errorf("expected the profile of cam-amber with his recipe but without his private favorites, got %+v", profile)
+	}
+}
+
+func TestGetPublicProfileFailOnUnknownUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "users" WHERE (username = $1 AND status = $2)`)).WithArgs("cam-amber", "active").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	if _, err := userService.GetPublicProfile("cam-amber"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected %s, got %v", gorm.ErrRecordNotFound, err)
+	}
+}
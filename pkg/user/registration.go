@@ -0,0 +1,65 @@
+package user
+
+import "errors"
+
+// ErrUnknownRegistrationMode is returned when setting a registration mode that doesn't exist.
+var ErrUnknownRegistrationMode = errors.New("registration mode must be open, invite-only or closed")
+
+// RegistrationModeSetting is the key of the setting holding the registration mode.
+const RegistrationModeSetting = "registration_mode"
+
+// RegistrationMode tells who can register by himself.
+type RegistrationMode string
+
+const (
+	// Anyone can register
+	RegistrationOpen RegistrationMode = "open"
+	// Only people invited by an admin can register
+	RegistrationInviteOnly RegistrationMode = "invite-only"
+	// Users can only be created by admins
+	RegistrationClosed RegistrationMode = "closed"
+)
+
+// Validate checks the registration mode exists.
+func (rm RegistrationMode) Validate() error {
+	switch rm {
+	case RegistrationOpen, RegistrationInviteOnly, RegistrationClosed:
+		return nil
+	default:
+		return ErrUnknownRegistrationMode
+	}
+}
+
+// Registration is what a user gives to register by himself.
+// @Description Registration is what a user gives to register by himself.
+type Registration struct {
+	// The name of the user
+	Username string `example:"cam-amber"`
+	// The password of the user, at least 8 characters long
+	Password string `example:"mytopsecretpassword"`
+	// The email address the verification email is sent to
+	Email string `example:"cam-amber@welsh.academy"`
+	// The invitation token received by email, only needed when registration is invite-only
+	Invitation string `json:",omitempty" example:"eyJhbGciOiJFZERTQSIsImtpZCI6ImdlbmVyYXRlZCIsInR5cCI6IkpXVCJ9..."`
+}
+
+// VerificationRequest is the email address of a registered user who needs a new verification link.
+// @Description VerificationRequest is the email address of a registered user who needs a new verification link.
+type VerificationRequest struct {
+	// The email address given when registering
+	Email string `example:"cam-amber@welsh.academy"`
+}
+
+// RegistrationSettings are the registration settings admins can change.
+// @Description RegistrationSettings are the registration settings admins can change.
+type RegistrationSettings struct {
+	// Who can register : open, invite-only or closed
+	Mode RegistrationMode `example:"invite-only"`
+}
+
+// Invitation is the email address an admin invites to register.
+// @Description Invitation is the email address an admin invites to register.
+type Invitation struct {
+	// The address the invitation is sent to
+	Email string `example:"cam-amber@welsh.academy"`
+}
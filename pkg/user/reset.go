@@ -0,0 +1,110 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/mjehanno/welsh-academy/pkg/tokenhash"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidResetToken is returned when a password reset token doesn't exist, has expired or has already been used.
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// ResetTokenLifetime is the duration a password reset token is valid for.
+const ResetTokenLifetime = time.Hour
+
+// PasswordResetRequest is the email address of a user who forgot his password.
+// @Description PasswordResetRequest is the email address of a user who forgot his password.
+type PasswordResetRequest struct {
+	// The email address of the user
+	Email string `example:"cam-amber@welsh.academy"`
+}
+
+// PasswordReset is what a user gives to choose a new password after forgetting it.
+// @Description PasswordReset is what a user gives to choose a new password after forgetting it.
+type PasswordReset struct {
+	// The token received by email
+	Token string `example:"kq1vJ2d1c3n9Yw0tX8lO4u2sPz7bR5eA6mQhGf0iWcE"`
+	// The password the user wants, at least 8 characters long
+	NewPassword string `example:"myevenmoresecretpassword"`
+}
+
+// PasswordResetToken allows a user to choose a new password once, until it expires.
+type PasswordResetToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	// The user who asked for the reset
+	UserID uint `gorm:"not null;index"`
+	// The SHA-256 hash of the token, the token itself is only sent by email
+	Hash      string `gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt time.Time
+	// When the token was used, a token can't be used twice
+	UsedAt *time.Time
+}
+
+// CreatePasswordReset takes the email of an active user and returns him along with a new password reset token,
+// replacing the tokens he didn't use. It returns gorm.ErrRecordNotFound if there is no active user with this email.
+func (us *UserService) CreatePasswordReset(email string) (User, string, error) {
+	var dbUser User
+
+	err := us.db.Omit("password").Where("email = ? AND status = ?", email, Active).First(&dbUser).Error
+	if err != nil {
+		return dbUser, "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return dbUser, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", dbUser.ID).Delete(&PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&PasswordResetToken{UserID: dbUser.ID, Hash: tokenhash.Sum(token), ExpiresAt: time.Now().Add(ResetTokenLifetime)}).Error
+	})
+
+	return dbUser, token, err
+}
+
+// SessionRevoker revokes every session of a user within a transaction.
+type SessionRevoker func(tx *gorm.DB, userID uint) error
+
+// ResetPassword takes a password reset token and the new password, and replaces the password of the user who asked for the token.
+// His sessions are revoked in the same transaction, so that the token is only used if they are.
+// It returns the id of the user, or ErrInvalidResetToken if the token isn't valid.
+func (us *UserService) ResetPassword(token string, newPassword string, revokeSessions SessionRevoker) (uint, error) {
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	var resetToken PasswordResetToken
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("hash = ? AND used_at IS NULL AND expires_at > ?", tokenhash.Sum(token), time.Now()).First(&resetToken).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&PasswordResetToken{}).Where("id = ?", resetToken.ID).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&User{}).Where("id = ?", resetToken.UserID).Update("password", hash).Error; err != nil {
+			return err
+		}
+
+		return revokeSessions(tx, resetToken.UserID)
+	})
+
+	return resetToken.UserID, err
+}
@@ -0,0 +1,118 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mjehanno/welsh-academy/pkg/tokenhash"
+	"gorm.io/gorm"
+)
+
+func TestChangePasswordSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	hash, _ := HashPassword("mytopsecretpassword")
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","password" FROM "users" WHERE id = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "password"}).AddRow(1, hash))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "password"=$1,"updated_at"=$2 WHERE id = $3 AND "users"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := userService.ChangePassword(1, "mytopsecretpassword", "myevenmoresecretpassword")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestChangePasswordFailOnWrongPassword(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	hash, _ := HashPassword("mytopsecretpassword")
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","password" FROM "users" WHERE id = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "password"}).AddRow(1, hash))
+
+	err := userService.ChangePassword(1, "mynotsosecretpassword", "myevenmoresecretpassword")
+	if !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("expected a wrong password error, got %v", err)
+	}
+}
+
+func TestCreatePasswordResetSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE (email = $1 AND status = $2) AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs("cam-amber@welsh.academy", "active").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email"}).AddRow(1, "cam-amber", "cam-amber@welsh.academy"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "password_reset_tokens" WHERE user_id = $1 AND used_at IS NULL`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "password_reset_tokens" ("created_at","user_id","hash","expires_at","used_at") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	user, token, err := userService.CreatePasswordReset("cam-amber@welsh.academy")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if user.Username != "cam-amber" || token == "" {
+		t.Errorf("expected a token for cam-amber, got %q for %s", token, user.Username)
+	}
+}
+
+func TestResetPasswordSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "password_reset_tokens" WHERE hash = $1 AND used_at IS NULL AND expires_at > $2 ORDER BY "password_reset_tokens"."id" LIMIT 1 FOR UPDATE`)).WithArgs(tokenhash.Sum("token"), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "expires_at"}).AddRow(2, 1, tokenhash.Sum("token"), time.Now().Add(time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "password_reset_tokens" SET "used_at"=$1 WHERE id = $2`)).WithArgs(sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "password"=$1,"updated_at"=$2 WHERE id = $3 AND "users"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	revoked := uint(0)
+	userID, err := userService.ResetPassword("token", "myevenmoresecretpassword", func(tx *gorm.DB, userID uint) error {
+		revoked = userID
+		return nil
+	})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if userID != 1 || revoked != 1 {
+		t.Errorf("expected the password of user 1 to be reset and his sessions revoked, got %d and %d", userID, revoked)
+	}
+}
+
+func TestResetPasswordFailOnRevocation(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "password_reset_tokens" WHERE hash = $1 AND used_at IS NULL AND expires_at > $2`)).WithArgs(tokenhash.Sum("token"), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "hash", "expires_at"}).AddRow(2, 1, tokenhash.Sum("token"), time.Now().Add(time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "password_reset_tokens" SET "used_at"=$1 WHERE id = $2`)).WithArgs(sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "password"=$1`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	_, err := userService.ResetPassword("token", "myevenmoresecretpassword", func(tx *gorm.DB, userID uint) error {
+		return fmt.Errorf("connection lost")
+	})
+	if err == nil {
+		t.Error("error did not occured while it should have")
+	}
+}
+
+func TestResetPasswordFailOnUsedToken(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "password_reset_tokens" WHERE hash = $1 AND used_at IS NULL AND expires_at > $2`)).WithArgs(tokenhash.Sum("token"), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	_, err := userService.ResetPassword("token", "myevenmoresecretpassword", nil)
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("expected an invalid reset token error, got %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package user
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrUnknownPermission is returned when a role is given a permission that doesn't exist.
+var ErrUnknownPermission = errors.New("unknown permission")
+
+// ErrProtectedRole is returned when trying to edit or delete the admin role, so that admins can't lock themselves out.
+var ErrProtectedRole = errors.New("the admin role can't be modified")
+
+// ErrRoleInUse is returned when trying to delete a role that is still given to some users.
+var ErrRoleInUse = errors.New("the role is still given to some users")
+
+// Permission is an action a role allows.
+type Permission string
+
+const (
+	RecipeCreate     Permission = "recipe:create"
+	RecipeUpdate     Permission = "recipe:update"
+	RecipeDelete     Permission = "recipe:delete"
+	IngredientCreate Permission = "ingredient:create"
+	IngredientUpdate Permission = "ingredient:update"
+	IngredientDelete Permission = "ingredient:delete"
+	UserManage       Permission = "user:manage"
+	RoleManage       Permission = "role:manage"
+)
+
+// Permissions lists every existing permission.
+var Permissions = []Permission{RecipeCreate, RecipeUpdate, RecipeDelete, IngredientCreate, IngredientUpdate, IngredientDelete, UserManage, RoleManage}
+
+// defaultRoles are the roles created on the first start of the application.
+var defaultRoles = []RoleDefinition{
+	{Name: BasicUser, Permissions: []Permission{}},
+	{Name: CheddarExpert, Permissions: []Permission{RecipeCreate, RecipeUpdate, RecipeDelete, IngredientCreate, IngredientUpdate, IngredientDelete}},
+	{Name: Admin, Permissions: Permissions},
+}
+
+// RoleDefinition is a role along with the permissions it grants.
+// @Description RoleDefinition is a role along with the permissions it grants.
+type RoleDefinition struct {
+	// The name of the role
+	Name Role `gorm:"primaryKey;size:40" example:"cheddarexpert"`
+	// The permissions granted to users having this role
+	Permissions []Permission     `gorm:"-" example:"recipe:create,recipe:update"`
+	Grants      []RolePermission `gorm:"foreignKey:RoleName" json:"-" swaggerignore:"true"`
+	// Whether users having this role must log in with a second factor to use its permissions, set through the two-factor policy
+	RequireTwoFactor bool `gorm:"not null;default:false" example:"true"`
+}
+
+// TableName overrides the table name used by RoleDefinition.
+func (RoleDefinition) TableName() string {
+	return "roles"
+}
+
+// RolePermission is a permission granted by a role.
+type RolePermission struct {
+	RoleName   Role       `gorm:"primaryKey;size:40"`
+	Permission Permission `gorm:"primaryKey;size:40"`
+}
+
+func (rd *RoleDefinition) toGrants() {
+	rd.Grants = make([]RolePermission, len(rd.Permissions))
+	for i, permission := range rd.Permissions {
+		rd.Grants[i] = RolePermission{RoleName: rd.Name, Permission: permission}
+	}
+}
+
+func (rd *RoleDefinition) fromGrants() {
+	rd.Permissions = make([]Permission, len(rd.Grants))
+	for i, grant := range rd.Grants {
+		rd.Permissions[i] = grant.Permission
+	}
+}
+
+// Validate checks every permission of the role exists.
+func (rd RoleDefinition) Validate() error {
+	for _, permission := range rd.Permissions {
+		known := false
+		for _, existing := range Permissions {
+			if permission == existing {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return ErrUnknownPermission
+		}
+	}
+
+	return nil
+}
+
+// NewRoleService is the constructor for a RoleService.
+func NewRoleService(db *gorm.DB) *RoleService {
+	return &RoleService{
+		db: db,
+	}
+}
+
+// RoleService is a service made to manage roles and their permissions.
+type RoleService struct {
+	db *gorm.DB
+}
+
+// SeedDefaultRoles creates the default roles if they don't exist yet, existing roles other than admin are left untouched.
+func (rs *RoleService) SeedDefaultRoles() error {
+	return rs.db.Transaction(func(tx *gorm.DB) error {
+		for _, role := range defaultRoles {
+			role.toGrants()
+
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit("Grants", "RequireTwoFactor").Create(&role)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			// the admin role always gets every permission, including the ones added since it was created
+			if (result.RowsAffected == 0 && role.Name != Admin) || len(role.Grants) == 0 {
+				continue
+			}
+
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&role.Grants).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetRoles returns every role with its permissions.
+func (rs *RoleService) GetRoles() ([]RoleDefinition, error) {
+	var roles []RoleDefinition
+
+	err := rs.db.Preload("Grants").Order("name").Find(&roles).Error
+	for i := range roles {
+		roles[i].fromGrants()
+	}
+
+	return roles, err
+}
+
+// GetRole takes the name of a role and returns it with its permissions, or gorm.ErrRecordNotFound if it doesn't exist.
+func (rs *RoleService) GetRole(name Role) (RoleDefinition, error) {
+	var role RoleDefinition
+
+	err := rs.db.Preload("Grants").Where("name = ?", name).First(&role).Error
+	role.fromGrants()
+
+	return role, err
+}
+
+// SaveRole takes a role and creates it, or replaces the permissions of the existing role with the same name.
+func (rs *RoleService) SaveRole(role RoleDefinition) error {
+	if role.Name == Admin {
+		return ErrProtectedRole
+	}
+
+	if err := role.Validate(); err != nil {
+		return err
+	}
+
+	role.toGrants()
+
+	return rs.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit("Grants", "RequireTwoFactor").Create(&role).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("role_name = ?", role.Name).Delete(&RolePermission{}).Error; err != nil {
+			return err
+		}
+
+		if len(role.Grants) == 0 {
+			return nil
+		}
+
+		return tx.Create(&role.Grants).Error
+	})
+}
+
+// DeleteRole takes the name of a role and deletes it, returning ErrRoleInUse if some users still have it or gorm.ErrRecordNotFound if it doesn't exist.
+func (rs *RoleService) DeleteRole(name Role) error {
+	if name == Admin {
+		return ErrProtectedRole
+	}
+
+	return rs.db.Transaction(func(tx *gorm.DB) error {
+		var users int64
+		// deleted users keep their role, which the users table references
+		if err := tx.Unscoped().Model(&User{}).Where("role = ?", name).Count(&users).Error; err != nil {
+			return err
+		}
+
+		if users > 0 {
+			return ErrRoleInUse
+		}
+
+		if err := tx.Where("role_name = ?", name).Delete(&RolePermission{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("name = ?", name).Delete(&RoleDefinition{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// HasPermission tells if a role grants a permission.
+func (rs *RoleService) HasPermission(role Role, permission Permission) (bool, error) {
+	var count int64
+
+	err := rs.db.Model(&RolePermission{}).Where("role_name = ? AND permission = ?", role, permission).Count(&count).Error
+
+	return count > 0, err
+}
+
+// RequiresTwoFactor tells if users having a role must log in with a second factor to use its permissions.
+func (rs *RoleService) RequiresTwoFactor(role Role) (bool, error) {
+	var count int64
+
+	err := rs.db.Model(&RoleDefinition{}).Where("name = ? AND require_two_factor", role).Count(&count).Error
+
+	return count > 0, err
+}
+
+// GetTwoFactorPolicy returns the roles whose users must log in with a second factor.
+func (rs *RoleService) GetTwoFactorPolicy() (TwoFactorPolicy, error) {
+	policy := TwoFactorPolicy{Roles: []Role{}}
+
+	err := rs.db.Model(&RoleDefinition{}).Where("require_two_factor").Order("name").Pluck("name", &policy.Roles).Error
+
+	return policy, err
+}
+
+// SetTwoFactorPolicy takes the roles whose users must log in with a second factor, other roles stop requiring it.
+// It returns gorm.ErrRecordNotFound if one of the roles doesn't exist.
+func (rs *RoleService) SetTwoFactorPolicy(policy TwoFactorPolicy) error {
+	return rs.db.Transaction(func(tx *gorm.DB) error {
+		if len(policy.Roles) > 0 {
+			unique := map[Role]bool{}
+			for _, role := range policy.Roles {
+				unique[role] = true
+			}
+
+			var count int64
+			if err := tx.Model(&RoleDefinition{}).Where("name IN ?", policy.Roles).Count(&count).Error; err != nil {
+				return err
+			}
+
+			if int(count) != len(unique) {
+				return gorm.ErrRecordNotFound
+			}
+		}
+
+		if err := tx.Model(&RoleDefinition{}).Where("require_two_factor").Update("require_two_factor", false).Error; err != nil {
+			return err
+		}
+
+		if len(policy.Roles) == 0 {
+			return nil
+		}
+
+		return tx.Model(&RoleDefinition{}).Where("name IN ?", policy.Roles).Update("require_two_factor", true).Error
+	})
+}
@@ -0,0 +1,171 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/gorm"
+)
+
+func TestSaveRoleSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "roles" ("name") VALUES ($1) ON CONFLICT DO NOTHING`)).WithArgs("moderator").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "role_permissions" WHERE role_name = $1`)).WithArgs("moderator").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "role_permissions" ("role_name","permission") VALUES ($1,$2),($3,$4)`)).WithArgs("moderator", "recipe:update", "moderator", "recipe:delete").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	err := roleService.SaveRole(RoleDefinition{Name: "moderator", Permissions: []Permission{RecipeUpdate, RecipeDelete}})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestSaveRoleFailOnUnknownPermission(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	err := roleService.SaveRole(RoleDefinition{Name: "moderator", Permissions: []Permission{"cheese:eat"}})
+	if !errors.Is(err, ErrUnknownPermission) {
+		t.Errorf("expected an unknown permission error, got %v", err)
+	}
+}
+
+func TestSaveRoleFailOnAdmin(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	err := roleService.SaveRole(RoleDefinition{Name: Admin, Permissions: []Permission{}})
+	if !errors.Is(err, ErrProtectedRole) {
+		t.Errorf("expected a protected role error, got %v", err)
+	}
+}
+
+func TestGetRoleSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "roles" WHERE name = $1 ORDER BY "roles"."name" LIMIT 1`)).WithArgs("cheddarexpert").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("cheddarexpert"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "role_permissions" WHERE "role_permissions"."role_name" = $1`)).WithArgs("cheddarexpert").WillReturnRows(sqlmock.NewRows([]string{"role_name", "permission"}).AddRow("cheddarexpert", "recipe:create").AddRow("cheddarexpert", "ingredient:create"))
+
+	role, err := roleService.GetRole(CheddarExpert)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(role.Permissions) != 2 || role.Permissions[0] != RecipeCreate {
+		t.Errorf("expected recipe:create and ingredient:create permissions, got %v", role.Permissions)
+	}
+}
+
+func TestDeleteRoleSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE role = $1`)).WithArgs("moderator").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "role_permissions" WHERE role_name = $1`)).WithArgs("moderator").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "roles" WHERE name = $1`)).WithArgs("moderator").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := roleService.DeleteRole("moderator")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestDeleteRoleFailOnRoleInUse(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE role = $1`)).WithArgs("cheddarexpert").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectRollback()
+
+	err := roleService.DeleteRole(CheddarExpert)
+	if !errors.Is(err, ErrRoleInUse) {
+		t.Errorf("expected a role in use error, got %v", err)
+	}
+}
+
+func TestDeleteRoleFailOnUnknownRole(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE role = $1`)).WithArgs("moderator").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "role_permissions" WHERE role_name = $1`)).WithArgs("moderator").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "roles" WHERE name = $1`)).WithArgs("moderator").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err := roleService.DeleteRole("moderator")
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "role_permissions" WHERE role_name = $1 AND permission = $2`)).WithArgs("cheddarexpert", "recipe:create").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "role_permissions" WHERE role_name = $1 AND permission = $2`)).WithArgs("basicuser", "recipe:create").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	if granted, err := roleService.HasPermission(CheddarExpert, RecipeCreate); err != nil || !granted {
+		t.Errorf("expected cheddar experts to be able to create recipes, got %v %v", granted, err)
+	}
+
+	if granted, err := roleService.HasPermission(BasicUser, RecipeCreate); err != nil || granted {
+		t.Errorf("expected basic users not to be able to create recipes, got %v %v", granted, err)
+	}
+}
+
+func TestSetTwoFactorPolicySucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "roles" WHERE name IN ($1,$2)`)).WithArgs("admin", "cheddarexpert").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "roles" SET "require_two_factor"=$1 WHERE require_two_factor`)).WithArgs(false).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "roles" SET "require_two_factor"=$1 WHERE name IN ($2,$3)`)).WithArgs(true, "admin", "cheddarexpert").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	err := roleService.SetTwoFactorPolicy(TwoFactorPolicy{Roles: []Role{Admin, CheddarExpert}})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestSetTwoFactorPolicyFailOnUnknownRole(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "roles" WHERE name IN ($1,$2)`)).WithArgs("admin", "cheesemonger").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	err := roleService.SetTwoFactorPolicy(TwoFactorPolicy{Roles: []Role{Admin, "cheesemonger"}})
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestRequiresTwoFactorSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "roles" WHERE name = $1 AND require_two_factor`)).WithArgs("admin").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	required, err := roleService.RequiresTwoFactor(Admin)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if !required {
+		t.Errorf("expected admins to require two-factor authentication")
+	}
+}
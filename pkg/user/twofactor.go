@@ -0,0 +1,238 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mjehanno/welsh-academy/pkg/tokenhash"
+	"github.com/mjehanno/welsh-academy/pkg/totp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidCode is returned when a two-factor code or a recovery code isn't valid.
+var ErrInvalidCode = errors.New("invalid two-factor code")
+
+// ErrTwoFactorNotEnrolled is returned when enabling two-factor authentication before starting the enrolment.
+var ErrTwoFactorNotEnrolled = errors.New("two-factor authentication enrolment hasn't been started")
+
+// ErrTwoFactorAlreadyEnabled is returned when enrolling a user who already has two-factor authentication enabled.
+var ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+
+// recoveryCodeCount is the number of recovery codes given to a user when he enables two-factor authentication.
+const recoveryCodeCount = 10
+
+// TwoFactor is the TOTP secret of a user.
+type TwoFactor struct {
+	UserID uint `gorm:"primaryKey;autoIncrement:false"`
+	// The base32 encoded secret shared with the authenticator application
+	Secret string `gorm:"size:64;not null"`
+	// Whether the enrolment has been confirmed with a code
+	Enabled bool `gorm:"not null"`
+	// The last time step a code was accepted for, so that a code can't be used twice
+	LastStep int64
+}
+
+// RecoveryCode can be used once instead of a TOTP code, when the authenticator application is lost.
+type RecoveryCode struct {
+	ID     uint `gorm:"primarykey"`
+	UserID uint `gorm:"not null;index"`
+	// The SHA-256 hash of the code
+	Hash   string `gorm:"size:64;not null"`
+	UsedAt *time.Time
+}
+
+// TwoFactorEnrolment is what a user needs to register his account in an authenticator application.
+// @Description TwoFactorEnrolment is what a user needs to register his account in an authenticator application.
+type TwoFactorEnrolment struct {
+	// The base32 encoded secret, to type in the application
+	Secret string `example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	// The otpauth URI, to render as a QR code scanned by the application
+	URI string `example:"otpauth://totp/Welsh%20Academy:cam-amber?algorithm=SHA1&digits=6&issuer=Welsh%20Academy&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+}
+
+// TwoFactorChallenge is returned by the login of a user having two-factor authentication enabled, its token has to be sent back with a code.
+// @Description TwoFactorChallenge is returned by the login of a user having two-factor authentication enabled, its token has to be sent back with a code.
+type TwoFactorChallenge struct {
+	// The token to send along with the code, valid for 5 minutes
+	Token string `example:"eyJhbGciOiJFZERTQSIsImtpZCI6ImdlbmVyYXRlZCIsInR5cCI6IkpXVCJ9..."`
+}
+
+// TwoFactorCode is a code given by a user to prove his identity, either from his authenticator application or a recovery code.
+// @Description TwoFactorCode is a code given by a user to prove his identity, either from his authenticator application or a recovery code.
+type TwoFactorCode struct {
+	// The token returned by the first step of the login, only needed to log in
+	Token string `json:",omitempty" example:"eyJhbGciOiJFZERTQSIsImtpZCI6ImdlbmVyYXRlZCIsInR5cCI6IkpXVCJ9..."`
+	// The 6 digits code or a recovery code
+	Code string `example:"287082"`
+}
+
+// RecoveryCodes are the codes a user can use once each instead of a TOTP code.
+// @Description RecoveryCodes are the codes a user can use once each instead of a TOTP code, they are only shown once.
+type RecoveryCodes struct {
+	Codes []string `example:"k3jd8-2mx9q,p0c4e-7hv1z"`
+}
+
+// TwoFactorPolicy lists the roles whose users must log in with a second factor to use their permissions.
+// @Description TwoFactorPolicy lists the roles whose users must log in with a second factor to use their permissions.
+type TwoFactorPolicy struct {
+	Roles []Role `example:"admin,cheddarexpert"`
+}
+
+// normalizeRecoveryCode removes what users might type differently in a recovery code.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// newRecoveryCodes returns new recovery codes along with their hashes.
+func newRecoveryCodes(userID uint) ([]string, []RecoveryCode, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]RecoveryCode, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(b)[:10])
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = RecoveryCode{UserID: userID, Hash: tokenhash.Sum(code)}
+	}
+
+	return codes, hashes, nil
+}
+
+// StartTwoFactorEnrolment takes the id of a user and returns a new TOTP secret for him, which has to be confirmed with a code
+// to enable two-factor authentication. It returns ErrTwoFactorAlreadyEnabled if it's already enabled.
+func (us *UserService) StartTwoFactorEnrolment(userID uint) (string, error) {
+	enabled, err := us.IsTwoFactorEnabled(userID)
+	if err != nil {
+		return "", err
+	}
+
+	if enabled {
+		return "", ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = us.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&TwoFactor{UserID: userID, Secret: secret}).Error
+
+	return secret, err
+}
+
+// EnableTwoFactor takes the id of a user and a code of his new secret, enables two-factor authentication and returns his recovery codes.
+// It returns ErrTwoFactorNotEnrolled if the enrolment wasn't started and ErrInvalidCode if the code isn't valid.
+func (us *UserService) EnableTwoFactor(userID uint, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		var twoFactor TwoFactor
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&twoFactor).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTwoFactorNotEnrolled
+		}
+		if err != nil {
+			return err
+		}
+
+		if twoFactor.Enabled {
+			return ErrTwoFactorAlreadyEnabled
+		}
+
+		valid, step := totp.Validate(twoFactor.Secret, code, time.Now())
+		if !valid {
+			return ErrInvalidCode
+		}
+
+		if err := tx.Model(&TwoFactor{}).Where("user_id = ?", userID).Updates(map[string]interface{}{"enabled": true, "last_step": step}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&hashes).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// IsTwoFactorEnabled tells if a user has enabled two-factor authentication.
+func (us *UserService) IsTwoFactorEnabled(userID uint) (bool, error) {
+	var count int64
+
+	err := us.db.Model(&TwoFactor{}).Where("user_id = ? AND enabled", userID).Count(&count).Error
+
+	return count > 0, err
+}
+
+// verifySecondFactor checks a TOTP code or a recovery code of a user within a transaction, a code can only be used once.
+func verifySecondFactor(tx *gorm.DB, userID uint, code string) error {
+	var twoFactor TwoFactor
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ? AND enabled", userID).First(&twoFactor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		return err
+	}
+
+	if valid, step := totp.Validate(twoFactor.Secret, code, time.Now()); valid {
+		if step <= twoFactor.LastStep {
+			return ErrInvalidCode
+		}
+
+		return tx.Model(&TwoFactor{}).Where("user_id = ?", userID).Update("last_step", step).Error
+	}
+
+	result := tx.Model(&RecoveryCode{}).Where("user_id = ? AND hash = ? AND used_at IS NULL", userID, tokenhash.Sum(normalizeRecoveryCode(code))).Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// VerifySecondFactor takes the id of a user having two-factor authentication enabled and checks a TOTP code or one of his recovery codes.
+// It returns ErrInvalidCode if the code isn't valid or has already been used.
+func (us *UserService) VerifySecondFactor(userID uint, code string) error {
+	return us.db.Transaction(func(tx *gorm.DB) error {
+		return verifySecondFactor(tx, userID, code)
+	})
+}
+
+// DisableTwoFactor takes the id of a user and a TOTP code or one of his recovery codes, and disables two-factor authentication.
+// It returns ErrInvalidCode if the code isn't valid.
+func (us *UserService) DisableTwoFactor(userID uint, code string) error {
+	return us.db.Transaction(func(tx *gorm.DB) error {
+		if err := verifySecondFactor(tx, userID, code); err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&TwoFactor{}).Error
+	})
+}
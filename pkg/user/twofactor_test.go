@@ -0,0 +1,130 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mjehanno/welsh-academy/pkg/tokenhash"
+	"github.com/mjehanno/welsh-academy/pkg/totp"
+)
+
+const totpSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+func TestStartTwoFactorEnrolmentSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "two_factors" WHERE user_id = $1 AND enabled`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "two_factors" ("user_id","secret","enabled","last_step") VALUES ($1,$2,$3,$4) ON CONFLICT ("user_id") DO UPDATE SET "secret"="excluded"."secret","enabled"="excluded"."enabled","last_step"="excluded"."last_step"`)).WithArgs(1, sqlmock.AnyArg(), false, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	generated, err := userService.StartTwoFactorEnrolment(1)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(generated) != 32 {
+		t.Errorf("expected a 32 characters secret, got %s", generated)
+	}
+}
+
+func TestStartTwoFactorEnrolmentFailOnEnabled(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "two_factors" WHERE user_id = $1 AND enabled`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	_, err := userService.StartTwoFactorEnrolment(1)
+	if !errors.Is(err, ErrTwoFactorAlreadyEnabled) {
+		t.Errorf("expected an already enabled error, got %v", err)
+	}
+}
+
+func TestEnableTwoFactorSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	code, _ := totp.Code(totpSecret, time.Now())
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "two_factors" WHERE user_id = $1 ORDER BY "two_factors"."user_id" LIMIT 1 FOR UPDATE`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"user_id", "secret", "enabled", "last_step"}).AddRow(1, totpSecret, false, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "two_factors" SET "enabled"=$1,"last_step"=$2 WHERE user_id = $3`)).WithArgs(true, sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "recovery_codes" WHERE user_id = $1`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "recovery_codes" ("user_id","hash","used_at") VALUES ($1,$2,$3),`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	codes, err := userService.EnableTwoFactor(1, code)
+	if err != nil {
+		t.Fatalf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if len(codes) != recoveryCodeCount || len(codes[0]) != 11 {
+		t.Errorf("expected %d recovery codes like xxxxx-xxxxx, got %v", recoveryCodeCount, codes)
+	}
+}
+
+func TestEnableTwoFactorFailOnInvalidCode(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "two_factors" WHERE user_id = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"user_id", "secret", "enabled", "last_step"}).AddRow(1, totpSecret, false, 0))
+	mock.ExpectRollback()
+
+	_, err := userService.EnableTwoFactor(1, "000000")
+	if !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected an invalid code error, got %v", err)
+	}
+}
+
+func TestVerifySecondFactorSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	code, _ := totp.Code(totpSecret, time.Now())
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "two_factors" WHERE user_id = $1 AND enabled ORDER BY "two_factors"."user_id" LIMIT 1 FOR UPDATE`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"user_id", "secret", "enabled", "last_step"}).AddRow(1, totpSecret, true, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "two_factors" SET "last_step"=$1 WHERE user_id = $2`)).WithArgs(totp.Step(time.Now()), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := userService.VerifySecondFactor(1, code)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestVerifySecondFactorFailOnReusedCode(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	code, _ := totp.Code(totpSecret, time.Now())
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "two_factors" WHERE user_id = $1 AND enabled`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"user_id", "secret", "enabled", "last_step"}).AddRow(1, totpSecret, true, totp.Step(time.Now())))
+	mock.ExpectRollback()
+
+	err := userService.VerifySecondFactor(1, code)
+	if !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected an invalid code error, got %v", err)
+	}
+}
+
+func TestVerifySecondFactorSucceedWithRecoveryCode(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "two_factors" WHERE user_id = $1 AND enabled`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"user_id", "secret", "enabled", "last_step"}).AddRow(1, totpSecret, true, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "recovery_codes" SET "used_at"=$1 WHERE user_id = $2 AND hash = $3 AND used_at IS NULL`)).WithArgs(sqlmock.AnyArg(), 1, tokenhash.Sum("k3jd82mx9q")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := userService.VerifySecondFactor(1, "K3JD8-2MX9Q")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
@@ -1,13 +1,47 @@
 package user
 
 import (
-	"crypto/sha256"
-	"fmt"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/mjehanno/welsh-academy/pkg/recipe"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+// ErrAccountNotActive is returned when a user whose account isn't active tries to log in.
+var ErrAccountNotActive = errors.New("the account isn't active")
+
+// ErrUnknownStatus is returned when filtering users by a status that doesn't exist.
+var ErrUnknownStatus = errors.New("status must be active, pending or suspended")
+
+// ErrUserExists is returned when registering a user whose username or email is already taken.
+var ErrUserExists = errors.New("username or email already taken")
+
+// ErrReservedUsername is returned when a username is used by the application, like the names of the /users routes
+// or the names given to anonymised users.
+var ErrReservedUsername = errors.New("this username is reserved")
+
+// reservedUsernames are the static segments of the /users routes, the public profile of a user having one of them couldn't be reached.
+var reservedUsernames = map[string]bool{
+	"me": true, "verify": true, "register": true, "favorites": true, "pantry": true, "api-keys": true,
+	"shopping-lists": true, "login": true, "logout": true, "password": true, "token": true, "oidc": true,
+}
+
+// ValidateUsername checks a username isn't reserved.
+func ValidateUsername(username string) error {
+	lower := strings.ToLower(username)
+	if reservedUsernames[lower] || strings.HasPrefix(lower, recipe.AnonymisedUsernamePrefix) {
+		return ErrReservedUsername
+	}
+
+	return nil
+}
+
+// PendingLifetime is how long a registered user has to verify his email before his username and email can be taken again.
+const PendingLifetime = 24 * time.Hour
+
 type Role string
 
 const (
@@ -16,6 +50,28 @@ const (
 	Admin         Role = "admin"
 )
 
+// Status is the state of a user account.
+type Status string
+
+const (
+	// Active users can log in
+	Active Status = "active"
+	// Pending users registered but haven't verified their email yet
+	Pending Status = "pending"
+	// Suspended users have been disabled by an admin
+	Suspended Status = "suspended"
+)
+
+// Validate checks the status exists.
+func (s Status) Validate() error {
+	switch s {
+	case Active, Pending, Suspended:
+		return nil
+	default:
+		return ErrUnknownStatus
+	}
+}
+
 // User represent user.
 type User struct {
 	gorm.Model
@@ -25,45 +81,171 @@ type User struct {
 	Password string `gorm:"size:255;not null;default:null" json:",omitempty" example:"admin"`
 	// The user's favorites recipes
 	FavoritesRecipes []recipe.Recipe `gorm:"many2many:favorite_recipe" swaggerignore:"true"`
-	Role             Role            `gorm:"type:roles"`
+	// The role of the user, defining his permissions
+	Role           Role            `gorm:"size:40;index" example:"basicuser"`
+	RoleDefinition *RoleDefinition `gorm:"foreignKey:Role;references:Name;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT" json:"-" swaggerignore:"true"`
+	// The email address of the user, used to send him notifications
+	Email string `gorm:"size:254;uniqueIndex;default:null" json:",omitempty" example:"cam-amber@welsh.academy"`
+	// The state of the account, only active users can log in
+	Status Status `gorm:"size:20;not null;default:active" json:",omitempty" example:"active"`
 }
 
 // NewUserService is the constructor for a UserService.
 func NewUserService(db *gorm.DB) *UserService {
 	return &UserService{
-		db: db,
+		db:           db,
+		personalData: userPersonalData(),
 	}
 }
 
 // UserService is a service made to manage user related queries.
 type UserService struct {
-	db *gorm.DB
+	db           *gorm.DB
+	personalData []PersonalData
 }
 
-// CreateUser takes a user and insert it in database, it returns the id of inserted user or an error.
+// CreateUser takes a user and insert it in database with a hashed password, it returns the id of inserted user or an error
+// such as ErrReservedUsername.
 func (us *UserService) CreateUser(user User) (uint, error) {
-	h := sha256.New()
-	h.Write([]byte(user.Password))
-	hash := h.Sum(nil)
-	user.Password = fmt.Sprintf("%x", string(hash))
+	if err := ValidateUsername(user.Username); err != nil {
+		return 0, err
+	}
+
+	hash, err := HashPassword(user.Password)
+	if err != nil {
+		return 0, err
+	}
+	user.Password = hash
 
 	result := us.db.Create(&user)
 
 	return user.ID, result.Error
 }
 
-// LogUser verifies user credential to log him or not.
+// Register takes a user signing up by himself and creates him as a basic user, pending until his email is verified
+// unless verified is true. A pending user who didn't verify his email within PendingLifetime is replaced.
+// It returns the id of the created user, ErrReservedUsername if his username is reserved or ErrUserExists if it or his email is taken.
+func (us *UserService) Register(user User, verified bool) (uint, error) {
+	if err := ValidateUsername(user.Username); err != nil {
+		return 0, err
+	}
+
+	hash, err := HashPassword(user.Password)
+	if err != nil {
+		return 0, err
+	}
+	user.Password = hash
+
+	user.Role = BasicUser
+	user.Status = Pending
+	if verified {
+		user.Status = Active
+	}
+
+	err = us.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("status = ? AND updated_at < ? AND (username = ? OR email = ?)", Pending, time.Now().Add(-PendingLifetime), user.Username, user.Email).Delete(&User{}).Error
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&User{}).Unscoped().Where("username = ? OR email = ?", user.Username, user.Email).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return ErrUserExists
+		}
+
+		return tx.Create(&user).Error
+	})
+
+	return user.ID, err
+}
+
+// RenewPending takes the email of a pending user and gives him PendingLifetime again to verify it.
+// It returns the user without his password, or gorm.ErrRecordNotFound if there is no pending user with this email.
+func (us *UserService) RenewPending(email string) (User, error) {
+	var dbUser User
+
+	err := us.db.Omit("password").Where("email = ? AND status = ?", email, Pending).First(&dbUser).Error
+	if err != nil {
+		return dbUser, err
+	}
+
+	err = us.db.Model(&User{}).Where("id = ?", dbUser.ID).Update("updated_at", time.Now()).Error
+
+	return dbUser, err
+}
+
+// Activate takes the id of a pending user and activates his account, returning gorm.ErrRecordNotFound if there is no such pending user.
+func (us *UserService) Activate(userID uint) error {
+	result := us.db.Model(&User{}).Where("id = ? AND status = ?", userID, Pending).Update("status", Active)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetUser takes the id of a user and returns him without his password, or gorm.ErrRecordNotFound if he doesn't exist.
+func (us *UserService) GetUser(userID uint) (User, error) {
+	var dbUser User
+
+	err := us.db.Omit("password").Where("id = ?", userID).First(&dbUser).Error
+
+	return dbUser, err
+}
+
+// GetActiveUser takes the id of a user and returns him without his password, or gorm.ErrRecordNotFound if he isn't active.
+func (us *UserService) GetActiveUser(userID uint) (User, error) {
+	var dbUser User
+
+	err := us.db.Omit("password").Where("id = ? AND status = ?", userID, Active).First(&dbUser).Error
+
+	return dbUser, err
+}
+
+// LogUser verifies user credential to log him or not, returning gorm.ErrRecordNotFound if they don't match.
+// Passwords stored with a legacy hash are upgraded on the fly.
 func (us *UserService) LogUser(user User) (*User, error) {
-	h := sha256.New()
-	h.Write([]byte(user.Password))
-	hash := h.Sum(nil)
 	var dbUser User
 
-	err := us.db.Where("username = ?", user.Username).Where("password = ?", fmt.Sprintf("%x", string(hash))).Omit("password", "created_at", "deleted_at", "updated_at").First(&dbUser).Error
+	err := us.db.Where("username = ?", user.Username).Omit("created_at", "deleted_at", "updated_at").First(&dbUser).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(user.Password))
+		return nil, err
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	match, needsRehash := CheckPassword(dbUser.Password, user.Password)
+	if !match {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if dbUser.Status != Active {
+		return nil, ErrAccountNotActive
+	}
+
+	if needsRehash {
+		hash, err := HashPassword(user.Password)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := us.db.Model(&User{}).Where("id = ?", dbUser.ID).Update("password", hash).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	dbUser.Password = ""
+
 	return &dbUser, nil
 }
 
@@ -81,7 +263,7 @@ func (us *UserService) GetFavoriteRecipe(userID uint) ([]recipe.Recipe, error) {
 	var recipes []recipe.Recipe
 	us.db.Where("id=?", userID).First(&user)
 
-	err := us.db.Preload("Ingredients").Model(&user).Association("FavoritesRecipes").Find(&recipes)
+	err := us.db.Preload("Ingredients.Ingredient").Model(&user).Association("FavoritesRecipes").Find(&recipes)
 
 	return recipes, err
 }
@@ -2,12 +2,12 @@ package user
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"regexp"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/mjehanno/welsh-academy/pkg/ingredient"
 	"github.com/mjehanno/welsh-academy/pkg/recipe"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -16,6 +16,7 @@ import (
 var mock sqlmock.Sqlmock
 var db *sql.DB
 var userService *UserService
+var roleService *RoleService
 
 func Setup(t *testing.T) func(t *testing.T) {
 	var err error
@@ -38,6 +39,7 @@ func Setup(t *testing.T) func(t *testing.T) {
 	}
 
 	userService = NewUserService(gdb)
+	roleService = NewRoleService(gdb)
 
 	return func(t *testing.T) {
 		defer db.Close()
@@ -53,7 +55,7 @@ func TestCreateUserSucceed(t *testing.T) {
 	defer tearDown(t)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users" ("created_at","updated_at","deleted_at","username","password") VALUES ($1,$2,$3,$4,$5) RETURNING "id","username","password"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "cam-amber", "5de4c437b552985b0fa4a9566a60d767ab89310343e4c5e3d7a373bc1b68747b").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users" ("created_at","updated_at","deleted_at","role","status","username","password") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id","username","password","email"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "", "active", "cam-amber", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectCommit()
 
 	_, err := userService.CreateUser(User{Username: "cam-amber", Password: "mytopsecretpassword"})
@@ -67,7 +69,7 @@ func TestCreateUserFailOnEmptyName(t *testing.T) {
 	defer tearDown(t)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users" ("created_at","updated_at","deleted_at","password") VALUES ($1,$2,$3,$4) RETURNING "id","username","password"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "5de4c437b552985b0fa4a9566a60d767ab89310343e4c5e3d7a373bc1b68747b").WillReturnError(fmt.Errorf("can't create user with empty name"))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users" ("created_at","updated_at","deleted_at","role","status","password") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id","username","password","email"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "", "active", sqlmock.AnyArg()).WillReturnError(fmt.Errorf("can't create user with empty name"))
 	mock.ExpectRollback()
 	_, err := userService.CreateUser(User{Username: "", Password: "mytopsecretpassword"})
 	if err == nil {
@@ -80,7 +82,7 @@ func TestCreateUserFailOnEmptyPassword(t *testing.T) {
 	defer tearDown(t)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users" ("created_at","updated_at","deleted_at","username","password") VALUES ($1,$2,$3,$4,$5) RETURNING "id","username","password"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "cam-amber", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855").WillReturnError(fmt.Errorf("can't create user with empty password"))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users" ("created_at","updated_at","deleted_at","role","status","username","password") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id","username","password","email"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "", "active", "cam-amber", sqlmock.AnyArg()).WillReturnError(fmt.Errorf("can't create user with empty password"))
 	mock.ExpectRollback()
 	_, err := userService.CreateUser(User{Username: "cam-amber", Password: ""})
 	if err == nil {
@@ -92,7 +94,8 @@ func TestLogUserSucceed(t *testing.T) {
 	tearDown := Setup(t)
 	defer tearDown(t)
 
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."username" FROM "users" WHERE username = $1 AND password = $2 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs("cam-amber", "5de4c437b552985b0fa4a9566a60d767ab89310343e4c5e3d7a373bc1b68747b").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "cam-amber"))
+	hash, _ := HashPassword("mytopsecretpassword")
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."username","users"."password","users"."role","users"."email","users"."status" FROM "users" WHERE username = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs("cam-amber").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "status"}).AddRow(1, "cam-amber", hash, "active"))
 
 	user, err := userService.LogUser(User{Username: "cam-amber", Password: "mytopsecretpassword"})
 	if err != nil {
@@ -104,17 +107,189 @@ func TestLogUserSucceed(t *testing.T) {
 
 }
 
+func TestLogUserSucceedWithLegacyHash(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."username","users"."password","users"."role","users"."email","users"."status" FROM "users" WHERE username = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs("cam-amber").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "status"}).AddRow(1, "cam-amber", "5de4c437b552985b0fa4a9566a60d767ab89310343e4c5e3d7a373bc1b68747b", "active"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "password"=$1,"updated_at"=$2 WHERE id = $3 AND "users"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, err := userService.LogUser(User{Username: "cam-amber", Password: "mytopsecretpassword"})
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+	if user.Password != "" {
+		t.Error("password should be empty here for security reason, we do not want to send password back in the frontend !")
+	}
+}
+
 func TestLogUserFail(t *testing.T) {
 	tearDown := Setup(t)
 	defer tearDown(t)
 
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."username" FROM "users" WHERE username = $1 AND password = $2 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs("cam-amber", "ed9909730fb6e9af1d563ce4a0019f0006141d0d818509ea4c1babf25821ecba").WillReturnError(fmt.Errorf("can't find matching user"))
+	hash, _ := HashPassword("mytopsecretpassword")
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."username","users"."password","users"."role","users"."email","users"."status" FROM "users" WHERE username = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs("cam-amber").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "status"}).AddRow(1, "cam-amber", hash, "active"))
 
 	_, err := userService.LogUser(User{Username: "cam-amber", Password: "mynotsosecretpassword"})
-	if err == nil {
-		t.Error("error did not occured while it should have")
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+
+}
+
+func TestLogUserFailOnUnknownUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."username","users"."password","users"."role","users"."email","users"."status" FROM "users" WHERE username = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs("cam-amber").WillReturnError(gorm.ErrRecordNotFound)
+
+	_, err := userService.LogUser(User{Username: "cam-amber", Password: "mytopsecretpassword"})
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestLogUserFailOnPendingUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	hash, _ := HashPassword("mytopsecretpassword")
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."username","users"."password","users"."role","users"."email","users"."status" FROM "users" WHERE username = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs("cam-amber").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "status"}).AddRow(1, "cam-amber", hash, "pending"))
+
+	_, err := userService.LogUser(User{Username: "cam-amber", Password: "mytopsecretpassword"})
+	if !errors.Is(err, ErrAccountNotActive) {
+		t.Errorf("expected an account not active error, got %v", err)
+	}
+}
+
+func TestRegisterSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "users" WHERE status = $1 AND updated_at < $2 AND (username = $3 OR email = $4)`)).WithArgs("pending", sqlmock.AnyArg(), "cam-amber", "cam-amber@welsh.academy").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE username = $1 OR email = $2`)).WithArgs("cam-amber", "cam-amber@welsh.academy").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users" ("created_at","updated_at","deleted_at","role","status","username","password","email") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "basicuser", "pending", "cam-amber", sqlmock.AnyArg(), "cam-amber@welsh.academy").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectCommit()
+
+	id, err := userService.Register(User{Username: "cam-amber", Password: "mytopsecretpassword", Email: "cam-amber@welsh.academy", Role: Admin}, false)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if id != 4 {
+		t.Errorf("expected user 4, got %d", id)
+	}
+}
+
+func TestRegisterFailOnTakenUsername(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "users" WHERE status = $1 AND updated_at < $2 AND (username = $3 OR email = $4)`)).WithArgs("pending", sqlmock.AnyArg(), "cam-amber", "cam-amber@welsh.academy").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "users" WHERE username = $1 OR email = $2`)).WithArgs("cam-amber", "cam-amber@welsh.academy").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	_, err := userService.Register(User{Username: "cam-amber", Password: "mytopsecretpassword", Email: "cam-amber@welsh.academy"}, false)
+	if !errors.Is(err, ErrUserExists) {
+		t.Errorf("expected a user exists error, got %v", err)
+	}
+}
+
+func TestRegisterFailOnReservedUsername(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	_, err := userService.Register(User{Username: recipe.AnonymisedUsernamePrefix + "42", Password: "mytopsecretpassword", Email: "cam-amber@welsh.academy"}, false)
+	if !errors.Is(err, ErrReservedUsername) {
+		t.Errorf("expected a reserved username error, got %v", err)
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	for username, reserved := range map[string]bool{"cam-amber": false, "me": true, "Shopping-Lists": true, "deleted-user-42": true, "deleted": false} {
+		if err := ValidateUsername(username); (err != nil) != reserved {
+			t.Errorf("expected %q to be reserved: %t, got %v", username, reserved, err)
+		}
 	}
+}
 
+func TestRenewPendingSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE (email = $1 AND status = $2) AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs("cam-amber@welsh.academy", "pending").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email"}).AddRow(4, "cam-amber", "cam-amber@welsh.academy"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "updated_at"=$1 WHERE id = $2 AND "users"."deleted_at" IS NULL`)).WithArgs(sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	pending, err := userService.RenewPending("cam-amber@welsh.academy")
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if pending.ID != 4 || pending.Username != "cam-amber" {
+		t.Errorf("expected cam-amber to be returned, got %v", pending)
+	}
+}
+
+func TestActivateSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "status"=$1,"updated_at"=$2 WHERE (id = $3 AND status = $4) AND "users"."deleted_at" IS NULL`)).WithArgs("active", sqlmock.AnyArg(), 4, "pending").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := userService.Activate(4)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+}
+
+func TestActivateFailOnActiveUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "status"=$1`)).WithArgs("active", sqlmock.AnyArg(), 4, "pending").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := userService.Activate(4)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestGetUserFailOnUnknownUser(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE id = $1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs(4).WillReturnError(gorm.ErrRecordNotFound)
+
+	_, err := userService.GetUser(4)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected a record not found error, got %v", err)
+	}
+}
+
+func TestGetActiveUserSucceed(t *testing.T) {
+	tearDown := Setup(t)
+	defer tearDown(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "users"."id","users"."created_at","users"."updated_at","users"."deleted_at","users"."username","users"."role","users"."email","users"."status" FROM "users" WHERE (id = $1 AND status = $2) AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs(4, "active").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(4, "cam-amber", "admin"))
+
+	u, err := userService.GetActiveUser(4)
+	if err != nil {
+		t.Errorf("error occured while it shouldn't have : %s", err.Error())
+	}
+
+	if u.Username != "cam-amber" || u.Role != Admin {
+		t.Errorf("expected cam-amber the admin, got %+v", u)
+	}
 }
 
 func TestAddFavoriteRecipeSucceed(t *testing.T) {
@@ -124,11 +299,11 @@ func TestAddFavoriteRecipeSucceed(t *testing.T) {
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE id=$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "cam-amber"))
 	mock.ExpectBegin()
 	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "updated_at"=$1 WHERE "users"."deleted_at" IS NULL AND "id" = $2`)).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "recipes" ("created_at","updated_at","deleted_at","name") VALUES ($1,$2,$3,$4) ON CONFLICT DO NOTHING RETURNING "id","name"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "welsh").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "recipes" ("created_at","updated_at","deleted_at","servings","name") VALUES ($1,$2,$3,$4,$5) ON CONFLICT DO NOTHING RETURNING "id","name"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 4, "welsh").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
 	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "favorite_recipe" ("user_id","recipe_id") VALUES ($1,$2) ON CONFLICT DO NOTHING`)).WithArgs(1, 0).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
-	err := userService.AddFavoriteRecipe(recipe.Recipe{Name: "welsh", Ingredients: []*ingredient.Ingredient{{Name: "cheddar"}, {Name: "bière brune"}}}, 1)
+	err := userService.AddFavoriteRecipe(recipe.Recipe{Name: "welsh", Ingredients: []recipe.RecipeIngredient{{IngredientID: 1, Quantity: 250, Unit: "g"}, {IngredientID: 2, Quantity: 25, Unit: "cl"}}}, 1)
 
 	if err != nil {
 		t.Errorf("error occured while it shouldn't have : %s", err.Error())
@@ -142,11 +317,11 @@ func TestAddFavoriteRecipeFail(t *testing.T) {
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE id=$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "cam-amber"))
 	mock.ExpectBegin()
 	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "updated_at"=$1 WHERE "users"."deleted_at" IS NULL AND "id" = $2`)).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "recipes" ("created_at","updated_at","deleted_at","name") VALUES ($1,$2,$3,$4) ON CONFLICT DO NOTHING RETURNING "id","name"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "welsh").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "recipes" ("created_at","updated_at","deleted_at","servings","name") VALUES ($1,$2,$3,$4,$5) ON CONFLICT DO NOTHING RETURNING "id","name"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 4, "welsh").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
 	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "favorite_recipe" ("user_id","recipe_id") VALUES ($1,$2) ON CONFLICT DO NOTHING`)).WithArgs(1, 0).WillReturnError(fmt.Errorf("can't add a non existing recipe to favorites"))
 	mock.ExpectRollback()
 
-	err := userService.AddFavoriteRecipe(recipe.Recipe{Name: "welsh", Ingredients: []*ingredient.Ingredient{{Name: "cheddar"}, {Name: "bière brune"}}}, 1)
+	err := userService.AddFavoriteRecipe(recipe.Recipe{Name: "welsh", Ingredients: []recipe.RecipeIngredient{{IngredientID: 1, Quantity: 250, Unit: "g"}, {IngredientID: 2, Quantity: 25, Unit: "cl"}}}, 1)
 
 	if err == nil {
 		t.Error("error occured while it shouldn't have")
@@ -158,9 +333,9 @@ func TestGetFavoriteRecipeSucceed(t *testing.T) {
 	defer tearDown(t)
 
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE id=$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "cam-amber"))
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "recipes"."id","recipes"."created_at","recipes"."updated_at","recipes"."deleted_at","recipes"."name" FROM "recipes" JOIN "favorite_recipe" ON "favorite_recipe"."recipe_id" = "recipes"."id" AND "favorite_recipe"."user_id" = $1 WHERE "recipes"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "recipe_ingredient"."recipe_id","recipe_ingredient"."ingredient_id" FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id"}).AddRow(1, 1).AddRow(1, 2).AddRow(1, 3))
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "ingredients"."id","ingredients"."created_at","ingredients"."updated_at","ingredients"."deleted_at","ingredients"."name" FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2,$3) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 2, 3).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune").AddRow(3, "pain"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "recipes"."id","recipes"."created_at","recipes"."updated_at","recipes"."deleted_at","recipes"."name","recipes"."servings","recipes"."author_id" FROM "recipes" JOIN "favorite_recipe" ON "favorite_recipe"."recipe_id" = "recipes"."id" AND "favorite_recipe"."user_id" = $1 WHERE "recipes"."deleted_at" IS NULL`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "welsh"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "recipe_ingredient"."recipe_id","recipe_ingredient"."ingredient_id","recipe_ingredient"."quantity","recipe_ingredient"."unit","recipe_ingredient"."note","recipe_ingredient"."optional" FROM "recipe_ingredient" WHERE "recipe_ingredient"."recipe_id" = $1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"recipe_id", "ingredient_id"}).AddRow(1, 1).AddRow(1, 2).AddRow(1, 3))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "ingredients"."id","ingredients"."created_at","ingredients"."updated_at","ingredients"."deleted_at","ingredients"."name","ingredients"."density" FROM "ingredients" WHERE "ingredients"."id" IN ($1,$2,$3) AND "ingredients"."deleted_at" IS NULL`)).WithArgs(1, 2, 3).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "cheddar").AddRow(2, "bière brune").AddRow(3, "pain"))
 
 	_, err := userService.GetFavoriteRecipe(1)
 	if err != nil {
@@ -173,7 +348,7 @@ func TestGetFavoriteRecipeFail(t *testing.T) {
 	defer tearDown(t)
 
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE id=$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "cam-amber"))
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "recipes"."id","recipes"."created_at","recipes"."updated_at","recipes"."deleted_at","recipes"."name" FROM "recipes" JOIN "favorite_recipe" ON "favorite_recipe"."recipe_id" = "recipes"."id" AND "favorite_recipe"."user_id" = $1 WHERE "recipes"."deleted_at" IS NULL`)).WithArgs(1).WillReturnError(fmt.Errorf("record not found"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "recipes"."id","recipes"."created_at","recipes"."updated_at","recipes"."deleted_at","recipes"."name","recipes"."servings","recipes"."author_id" FROM "recipes" JOIN "favorite_recipe" ON "favorite_recipe"."recipe_id" = "recipes"."id" AND "favorite_recipe"."user_id" = $1 WHERE "recipes"."deleted_at" IS NULL`)).WithArgs(1).WillReturnError(fmt.Errorf("record not found"))
 
 	_, err := userService.GetFavoriteRecipe(1)
 	if err == nil {
@@ -190,7 +365,7 @@ func TestDeleteFavoriteRecipeSucceed(t *testing.T) {
 	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "favorite_recipe" WHERE "favorite_recipe"."user_id" = $1 AND "favorite_recipe"."recipe_id" IN (NULL)`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
-	err := userService.DeleteFavoriteRecipe(recipe.Recipe{Name: "welsh", Ingredients: []*ingredient.Ingredient{{Name: "cheddar"}, {Name: "bière brune"}}}, 1)
+	err := userService.DeleteFavoriteRecipe(recipe.Recipe{Name: "welsh", Ingredients: []recipe.RecipeIngredient{{IngredientID: 1, Quantity: 250, Unit: "g"}, {IngredientID: 2, Quantity: 25, Unit: "cl"}}}, 1)
 	if err != nil {
 		t.Errorf("error occured while it shouldn't have : %s", err.Error())
 	}
@@ -205,7 +380,7 @@ func TestDeleteFavoriteRecipeFail(t *testing.T) {
 	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "favorite_recipe" WHERE "favorite_recipe"."user_id" = $1 AND "favorite_recipe"."recipe_id" IN (NULL)`)).WithArgs(1).WillReturnError(fmt.Errorf("can't delete inexistent record"))
 	mock.ExpectRollback()
 
-	err := userService.DeleteFavoriteRecipe(recipe.Recipe{Name: "welsh", Ingredients: []*ingredient.Ingredient{{Name: "cheddar"}, {Name: "bière brune"}}}, 1)
+	err := userService.DeleteFavoriteRecipe(recipe.Recipe{Name: "welsh", Ingredients: []recipe.RecipeIngredient{{IngredientID: 1, Quantity: 250, Unit: "g"}, {IngredientID: 2, Quantity: 25, Unit: "cl"}}}, 1)
 	if err == nil {
 		t.Error("error did not occured while it should have")
 	}